@@ -0,0 +1,352 @@
+// Package a2s implements the Valve Source server query protocol (A2S_INFO, A2S_PLAYER and A2S_RULES)
+// over UDP, which doesn't need the RCON password.
+// See https://developer.valvesoftware.com/wiki/Server_queries
+package a2s
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"time"
+)
+
+const (
+	singlePacket = -1 // 0xFFFFFFFF
+	splitPacket  = -2 // 0xFFFFFFFE
+
+	infoRequest    = 'T'
+	infoResponse   = 'I'
+	playerRequest  = 'U'
+	playerResponse = 'D'
+	rulesRequest   = 'V'
+	rulesResponse  = 'E'
+	challengeReply = 'A'
+)
+
+var (
+	ErrBadResponse = errors.New("a2s: unexpected response")
+	ErrCompressed  = errors.New("a2s: compressed split responses are not supported")
+)
+
+type Info struct {
+	Protocol    byte   `json:"protocol"`
+	Name        string `json:"name"`
+	Map         string `json:"map"`
+	Folder      string `json:"folder"`
+	Game        string `json:"game"`
+	AppID       uint16 `json:"app_id"`
+	Players     int    `json:"players"` // includes bots
+	MaxPlayers  int    `json:"max_players"`
+	Bots        int    `json:"bots"`
+	ServerType  byte   `json:"server_type"` // 'd' dedicated, 'l' listen, 'p' SourceTV
+	Environment byte   `json:"environment"` // 'l' linux, 'w' windows, 'm' mac
+	Private     bool   `json:"private"`
+	VAC         bool   `json:"vac"`
+	Version     string `json:"version"`
+	Port        uint16 `json:"port,omitempty"`
+	SteamID     uint64 `json:"steam_id,omitempty"`
+	Keywords    string `json:"keywords,omitempty"`
+	GameID      uint64 `json:"game_id,omitempty"`
+}
+
+type Player struct {
+	Index    byte          `json:"index"`
+	Name     string        `json:"name"`
+	Score    int32         `json:"score"`
+	Duration time.Duration `json:"duration"`
+}
+
+type Client struct {
+	Addr    string
+	Timeout time.Duration
+}
+
+// NewClient returns a client for the server query port at addr (host:port)
+func NewClient(addr string, timeout time.Duration) *Client {
+	return &Client{Addr: addr, Timeout: timeout}
+}
+
+// QueryInfo sends A2S_INFO
+func (c *Client) QueryInfo() (Info, error) {
+	request := append(header(infoRequest), []byte("Source Engine Query\x00")...)
+	data, err := c.query(request, true)
+	if err != nil {
+		return Info{}, err
+	}
+	return parseInfo(data)
+}
+
+// QueryPlayers sends A2S_PLAYER
+func (c *Client) QueryPlayers() ([]Player, error) {
+	data, err := c.query(append(header(playerRequest), 0xFF, 0xFF, 0xFF, 0xFF), false)
+	if err != nil {
+		return nil, err
+	}
+	return parsePlayers(data)
+}
+
+// QueryRules sends A2S_RULES and returns the server's cvars
+func (c *Client) QueryRules() (map[string]string, error) {
+	data, err := c.query(append(header(rulesRequest), 0xFF, 0xFF, 0xFF, 0xFF), false)
+	if err != nil {
+		return nil, err
+	}
+	return parseRules(data)
+}
+
+// query sends a request and handles the challenge exchange. A2S_INFO appends the challenge
+// to the request while A2S_PLAYER and A2S_RULES replace their placeholder challenge with it.
+func (c *Client) query(request []byte, appendChallenge bool) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", c.Addr, c.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(c.Timeout)); err != nil {
+		return nil, err
+	}
+
+	// servers may ask for a fresh challenge more than once
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err := conn.Write(request); err != nil {
+			return nil, err
+		}
+
+		data, err := readResponse(conn)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 {
+			return nil, ErrBadResponse
+		}
+
+		if data[0] != challengeReply {
+			return data, nil
+		}
+		if len(data) < 5 {
+			return nil, ErrBadResponse
+		}
+
+		challenge := data[1:5]
+		if appendChallenge {
+			request = append(request[:len(request):len(request)], challenge...)
+			appendChallenge = false
+		} else {
+			request = append(request[:len(request)-4:len(request)-4], challenge...)
+		}
+	}
+
+	return nil, fmt.Errorf("%w: challenge was not accepted", ErrBadResponse)
+}
+
+// readResponse reads one response, reassembling split packets, and strips the packet header
+func readResponse(conn net.Conn) ([]byte, error) {
+	buf := make([]byte, 65535)
+	var parts [][]byte
+	var total, received int
+	var id int32
+
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if n < 4 {
+			return nil, ErrBadResponse
+		}
+
+		packet := buf[:n]
+		kind := int32(binary.LittleEndian.Uint32(packet))
+		if kind == singlePacket {
+			return append([]byte(nil), packet[4:]...), nil
+		}
+		if kind != splitPacket || n < 12 {
+			return nil, ErrBadResponse
+		}
+
+		// split packet: id (4), total (1), number (1), max packet size (2)
+		packetID := int32(binary.LittleEndian.Uint32(packet[4:]))
+		if uint32(packetID)&0x80000000 != 0 {
+			return nil, ErrCompressed
+		}
+		if parts == nil {
+			id = packetID
+			total = int(packet[8])
+			if total == 0 {
+				return nil, ErrBadResponse
+			}
+			parts = make([][]byte, total)
+		} else if packetID != id {
+			continue // stale packet from an earlier response
+		}
+
+		number := int(packet[9])
+		if number >= total {
+			return nil, ErrBadResponse
+		}
+		if parts[number] == nil {
+			parts[number] = append([]byte(nil), packet[12:]...)
+			received++
+		}
+
+		if received == total {
+			payload := bytes.Join(parts, nil)
+			if len(payload) < 4 || int32(binary.LittleEndian.Uint32(payload)) != singlePacket {
+				return nil, ErrBadResponse
+			}
+			return payload[4:], nil
+		}
+	}
+}
+
+func header(kind byte) []byte {
+	return []byte{0xFF, 0xFF, 0xFF, 0xFF, kind}
+}
+
+func parseInfo(data []byte) (Info, error) {
+	r := &reader{data: data}
+	if r.byte() != infoResponse {
+		return Info{}, ErrBadResponse
+	}
+
+	var info Info
+	info.Protocol = r.byte()
+	info.Name = r.string()
+	info.Map = r.string()
+	info.Folder = r.string()
+	info.Game = r.string()
+	info.AppID = r.uint16()
+	info.Players = int(r.byte())
+	info.MaxPlayers = int(r.byte())
+	info.Bots = int(r.byte())
+	info.ServerType = r.byte()
+	info.Environment = r.byte()
+	info.Private = r.byte() == 1
+	info.VAC = r.byte() == 1
+	info.Version = r.string()
+	if r.err != nil {
+		return Info{}, r.err
+	}
+
+	// extra data flag, absent on old servers
+	if r.remaining() == 0 {
+		return info, nil
+	}
+	edf := r.byte()
+	if edf&0x80 != 0 {
+		info.Port = r.uint16()
+	}
+	if edf&0x10 != 0 {
+		info.SteamID = r.uint64()
+	}
+	if edf&0x40 != 0 {
+		r.uint16() // SourceTV port
+		r.string() // SourceTV name
+	}
+	if edf&0x20 != 0 {
+		info.Keywords = r.string()
+	}
+	if edf&0x01 != 0 {
+		info.GameID = r.uint64()
+	}
+
+	return info, r.err
+}
+
+func parsePlayers(data []byte) ([]Player, error) {
+	r := &reader{data: data}
+	if r.byte() != playerResponse {
+		return nil, ErrBadResponse
+	}
+
+	count := int(r.byte())
+	players := make([]Player, 0, count)
+	for i := 0; i < count && r.err == nil; i++ {
+		var player Player
+		player.Index = r.byte()
+		player.Name = r.string()
+		player.Score = int32(r.uint32())
+		player.Duration = time.Duration(float64(r.float32()) * float64(time.Second))
+		players = append(players, player)
+	}
+
+	return players, r.err
+}
+
+func parseRules(data []byte) (map[string]string, error) {
+	r := &reader{data: data}
+	if r.byte() != rulesResponse {
+		return nil, ErrBadResponse
+	}
+
+	count := int(r.uint16())
+	rules := make(map[string]string, count)
+	for i := 0; i < count && r.err == nil; i++ {
+		name := r.string()
+		rules[name] = r.string()
+	}
+
+	return rules, r.err
+}
+
+// reader decodes the little-endian fields of a response, remembering the first short read
+type reader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *reader) remaining() int {
+	return len(r.data) - r.pos
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return make([]byte, n)
+	}
+	if r.remaining() < n {
+		r.err = fmt.Errorf("%w: response truncated", ErrBadResponse)
+		return make([]byte, n)
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *reader) byte() byte {
+	return r.next(1)[0]
+}
+
+func (r *reader) uint16() uint16 {
+	return binary.LittleEndian.Uint16(r.next(2))
+}
+
+func (r *reader) uint32() uint32 {
+	return binary.LittleEndian.Uint32(r.next(4))
+}
+
+func (r *reader) uint64() uint64 {
+	return binary.LittleEndian.Uint64(r.next(8))
+}
+
+func (r *reader) float32() float32 {
+	return math.Float32frombits(r.uint32())
+}
+
+func (r *reader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	end := bytes.IndexByte(r.data[r.pos:], 0)
+	if end < 0 {
+		r.err = fmt.Errorf("%w: unterminated string", ErrBadResponse)
+		return ""
+	}
+	s := string(r.data[r.pos : r.pos+end])
+	r.pos += end + 1
+	return s
+}
@@ -0,0 +1,181 @@
+package a2s
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+var testChallenge = []byte{0x12, 0x34, 0x56, 0x78}
+
+// testServer is a local UDP stand-in for a game server. It demands a challenge for every query
+// and answers A2S_PLAYER with a split response.
+type testServer struct {
+	conn *net.UDPConn
+}
+
+func newTestServer(t *testing.T) *testServer {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := &testServer{conn: conn}
+	go s.serve()
+	t.Cleanup(func() { conn.Close() })
+	return s
+}
+
+func (s *testServer) addr() string {
+	return s.conn.LocalAddr().String()
+}
+
+func (s *testServer) serve() {
+	buf := make([]byte, 1400)
+	for {
+		n, addr, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		request := buf[:n]
+		if n < 9 {
+			continue
+		}
+
+		challenge := request[n-4:]
+		if !bytes.Equal(challenge, testChallenge) {
+			s.send(addr, append([]byte{0xFF, 0xFF, 0xFF, 0xFF, challengeReply}, testChallenge...))
+			continue
+		}
+
+		switch request[4] {
+		case infoRequest:
+			s.send(addr, infoPayload())
+		case playerRequest:
+			s.sendSplit(addr, playerPayload(), 3)
+		case rulesRequest:
+			s.send(addr, rulesPayload())
+		}
+	}
+}
+
+func (s *testServer) send(addr *net.UDPAddr, packet []byte) {
+	_, _ = s.conn.WriteToUDP(packet, addr)
+}
+
+// sendSplit sends payload in parts packets, last part first, to exercise reassembly
+func (s *testServer) sendSplit(addr *net.UDPAddr, payload []byte, parts int) {
+	size := (len(payload) + parts - 1) / parts
+	for i := parts - 1; i >= 0; i-- {
+		end := min((i+1)*size, len(payload))
+		packet := []byte{0xFE, 0xFF, 0xFF, 0xFF, 0x01, 0x00, 0x00, 0x00, byte(parts), byte(i), 0xE0, 0x04}
+		s.send(addr, append(packet, payload[i*size:end]...))
+	}
+}
+
+func infoPayload() []byte {
+	var b bytes.Buffer
+	b.Write([]byte{0xFF, 0xFF, 0xFF, 0xFF, infoResponse, 17})
+	b.WriteString("simple surf server (us) - servers.tf2dl.net\x00surf_utopia_v3\x00tf\x00Team Fortress\x00")
+	binary.Write(&b, binary.LittleEndian, uint16(440))
+	b.Write([]byte{3, 24, 1, 'd', 'l', 0, 1})
+	b.WriteString("8835751\x00")
+	b.WriteByte(0x80 | 0x10 | 0x20 | 0x01)
+	binary.Write(&b, binary.LittleEndian, uint16(27015))
+	binary.Write(&b, binary.LittleEndian, uint64(85568392924952893))
+	b.WriteString("surf,nocrits\x00")
+	binary.Write(&b, binary.LittleEndian, uint64(440))
+	return b.Bytes()
+}
+
+func playerPayload() []byte {
+	var b bytes.Buffer
+	b.Write([]byte{0xFF, 0xFF, 0xFF, 0xFF, playerResponse, 2})
+	b.WriteByte(0)
+	b.WriteString("Player One\x00")
+	binary.Write(&b, binary.LittleEndian, int32(12))
+	binary.Write(&b, binary.LittleEndian, math.Float32bits(754.5))
+	b.WriteByte(1)
+	b.WriteString("SourceTV\x00")
+	binary.Write(&b, binary.LittleEndian, int32(0))
+	binary.Write(&b, binary.LittleEndian, math.Float32bits(3600))
+	return b.Bytes()
+}
+
+func rulesPayload() []byte {
+	var b bytes.Buffer
+	b.Write([]byte{0xFF, 0xFF, 0xFF, 0xFF, rulesResponse})
+	binary.Write(&b, binary.LittleEndian, uint16(2))
+	b.WriteString("mp_timelimit\x0030\x00sv_gravity\x00800\x00")
+	return b.Bytes()
+}
+
+// Test A2S_INFO including the challenge exchange and extra data fields
+func TestQueryInfo(t *testing.T) {
+	server := newTestServer(t)
+	info, err := NewClient(server.addr(), time.Second).QueryInfo()
+	if err != nil {
+		t.Fatalf("Failed to query info: %v", err)
+	}
+
+	expected := Info{
+		Protocol: 17, Name: "simple surf server (us) - servers.tf2dl.net", Map: "surf_utopia_v3",
+		Folder: "tf", Game: "Team Fortress", AppID: 440, Players: 3, MaxPlayers: 24, Bots: 1,
+		ServerType: 'd', Environment: 'l', Private: false, VAC: true, Version: "8835751",
+		Port: 27015, SteamID: 85568392924952893, Keywords: "surf,nocrits", GameID: 440,
+	}
+	if info != expected {
+		t.Errorf("Unexpected info\n got: %+v\nwant: %+v", info, expected)
+	}
+}
+
+// Test A2S_PLAYER with a response split over several packets arriving out of order
+func TestQueryPlayers(t *testing.T) {
+	server := newTestServer(t)
+	players, err := NewClient(server.addr(), time.Second).QueryPlayers()
+	if err != nil {
+		t.Fatalf("Failed to query players: %v", err)
+	}
+
+	if len(players) != 2 {
+		t.Fatalf("Expected 2 players, got %d", len(players))
+	}
+	if players[0].Name != "Player One" || players[0].Score != 12 || players[0].Duration != 754500*time.Millisecond {
+		t.Errorf("Unexpected player %+v", players[0])
+	}
+	if players[1].Name != "SourceTV" || players[1].Duration != time.Hour {
+		t.Errorf("Unexpected player %+v", players[1])
+	}
+}
+
+// Test A2S_RULES
+func TestQueryRules(t *testing.T) {
+	server := newTestServer(t)
+	rules, err := NewClient(server.addr(), time.Second).QueryRules()
+	if err != nil {
+		t.Fatalf("Failed to query rules: %v", err)
+	}
+
+	if len(rules) != 2 || rules["mp_timelimit"] != "30" || rules["sv_gravity"] != "800" {
+		t.Errorf("Unexpected rules %v", rules)
+	}
+}
+
+// Test that a server that never answers times out instead of blocking the poller
+func TestQueryTimeout(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	if _, err := NewClient(conn.LocalAddr().String(), 100*time.Millisecond).QueryInfo(); err == nil {
+		t.Fatal("Expected timeout error")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Query took %v, expected to time out after 100ms", time.Since(start))
+	}
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/sawatkins/tf2dl-servers/auth/authtest"
+)
+
+const (
+	testSteamID = 76561197960287930
+	returnTo    = "https://servers.tf2dl.net/login/callback"
+)
+
+func TestVerify(t *testing.T) {
+	provider := authtest.NewProvider(t)
+	openID := NewOpenID(provider.Endpoint())
+	ctx := context.Background()
+
+	params := provider.Assert(returnTo, testSteamID)
+	steamID, err := openID.Verify(ctx, returnTo, params)
+	if err != nil || steamID != testSteamID {
+		t.Fatalf("Expected %d to be signed in, got %d %v", testSteamID, steamID, err)
+	}
+
+	if _, err := openID.Verify(ctx, returnTo, params); !errors.Is(err, ErrInvalidAssertion) {
+		t.Errorf("Expected a replayed assertion to be rejected, got %v", err)
+	}
+
+	tampered := map[string]func(params url.Values){
+		"forged signature": func(params url.Values) { params.Set("openid.sig", "forged") },
+		"other return_to":  func(params url.Values) { params.Set("openid.return_to", "https://example.com/callback") },
+		"other provider":   func(params url.Values) { params.Set("openid.op_endpoint", "https://example.com/openid/login") },
+		"other identity": func(params url.Values) {
+			params.Set("openid.identity", "https://steamcommunity.com/openid/id/76561197960265729")
+		},
+		"unsigned claim": func(params url.Values) {
+			params.Set("openid.signed", "op_endpoint,return_to,response_nonce,assoc_handle")
+		},
+		"stale nonce": func(params url.Values) {
+			params.Set("openid.response_nonce", time.Now().Add(-time.Hour).UTC().Format("2006-01-02T15:04:05Z")+"abc")
+		},
+	}
+	for name, tamper := range tampered {
+		params := provider.Assert(returnTo, testSteamID)
+		tamper(params)
+		if _, err := openID.Verify(ctx, returnTo, params); !errors.Is(err, ErrInvalidAssertion) {
+			t.Errorf("Expected an assertion with a %s to be rejected, got %v", name, err)
+		}
+	}
+
+	if _, err := openID.Verify(ctx, returnTo, url.Values{"openid.mode": {"cancel"}}); !errors.Is(err, ErrCancelled) {
+		t.Errorf("Expected a cancelled sign in, got %v", err)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	sessions := NewSessions([]byte("secret"))
+	value := sessions.Encode(testSteamID)
+	if steamID, err := sessions.Decode(value); err != nil || steamID != testSteamID {
+		t.Errorf("Expected the session to decode, got %d %v", steamID, err)
+	}
+
+	if _, err := NewSessions([]byte("other secret")).Decode(value); err != ErrInvalidSession {
+		t.Errorf("Expected a session signed with another secret to be rejected, got %v", err)
+	}
+	if _, err := sessions.Decode("76561197960265729" + value[17:]); err != ErrInvalidSession {
+		t.Errorf("Expected a changed SteamID to be rejected, got %v", err)
+	}
+
+	sessions.MaxAge = -time.Minute
+	if _, err := sessions.Decode(sessions.Encode(testSteamID)); err != ErrInvalidSession {
+		t.Errorf("Expected an expired session to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+// Package authtest runs a stand-in for Steam's OpenID provider in tests.
+package authtest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Provider answers check_authentication requests for the assertions it issued, each only once
+// like Steam does
+type Provider struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	signatures map[string]bool // unused signatures of issued assertions
+}
+
+// NewProvider starts a provider that is closed when the test ends
+func NewProvider(t *testing.T) *Provider {
+	p := &Provider{signatures: map[string]bool{}}
+	p.Server = httptest.NewServer(http.HandlerFunc(p.serveHTTP))
+	t.Cleanup(p.Close)
+	return p
+}
+
+// Endpoint is the URL to configure as the OpenID endpoint
+func (p *Provider) Endpoint() string {
+	return p.URL + "/openid/login"
+}
+
+// Assert returns the query the provider would send the player back to returnTo with
+// after signing in as steamID64
+func (p *Provider) Assert(returnTo string, steamID64 uint64) url.Values {
+	signature := randomHex()
+	p.mu.Lock()
+	p.signatures[signature] = true
+	p.mu.Unlock()
+
+	claimedID := fmt.Sprintf("https://steamcommunity.com/openid/id/%d", steamID64)
+	return url.Values{
+		"openid.ns":             {"http://specs.openid.net/auth/2.0"},
+		"openid.mode":           {"id_res"},
+		"openid.op_endpoint":    {p.Endpoint()},
+		"openid.claimed_id":     {claimedID},
+		"openid.identity":       {claimedID},
+		"openid.return_to":      {returnTo},
+		"openid.response_nonce": {time.Now().UTC().Format("2006-01-02T15:04:05Z") + randomHex()},
+		"openid.assoc_handle":   {"1234567890"},
+		"openid.signed":         {"signed,op_endpoint,claimed_id,identity,return_to,response_nonce,assoc_handle"},
+		"openid.sig":            {signature},
+	}
+}
+
+func (p *Provider) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/openid/login" || r.PostFormValue("openid.mode") != "check_authentication" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	valid := p.signatures[r.PostFormValue("openid.sig")]
+	delete(p.signatures, r.PostFormValue("openid.sig"))
+	p.mu.Unlock()
+
+	fmt.Fprintf(w, "ns:http://specs.openid.net/auth/2.0\nis_valid:%t\n", valid)
+}
+
+func randomHex() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
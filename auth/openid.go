@@ -0,0 +1,194 @@
+// Package auth signs players in through Steam's OpenID 2.0 provider and keeps them
+// signed in with signed session cookies.
+package auth
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sawatkins/tf2dl-servers/steamid"
+)
+
+// SteamEndpoint is Steam's OpenID provider
+const SteamEndpoint = "https://steamcommunity.com/openid/login"
+
+const (
+	openIDNamespace  = "http://specs.openid.net/auth/2.0"
+	identifierSelect = "http://specs.openid.net/auth/2.0/identifier_select"
+)
+
+var (
+	ErrCancelled        = errors.New("auth: sign in was cancelled")
+	ErrInvalidAssertion = errors.New("auth: invalid openid assertion")
+)
+
+// claimedIDRe matches the identity Steam asserts, e.g. https://steamcommunity.com/openid/id/76561197960287930
+var claimedIDRe = regexp.MustCompile(`^https?://steamcommunity\.com/openid/id/(\d+)$`)
+
+// OpenID verifies sign ins with a Steam OpenID provider. Assertions are checked with the
+// provider directly (stateless mode), so no associations are kept.
+type OpenID struct {
+	Endpoint string
+	Client   *http.Client
+	MaxAge   time.Duration // oldest response nonce accepted
+
+	mu     sync.Mutex
+	nonces map[string]time.Time // nonces already used, by when they can be forgotten
+}
+
+func NewOpenID(endpoint string) *OpenID {
+	return &OpenID{
+		Endpoint: endpoint,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		MaxAge:   5 * time.Minute,
+		nonces:   map[string]time.Time{},
+	}
+}
+
+// AuthURL returns the provider's sign in page, which sends the player back to returnTo.
+// realm is the site the player is asked to trust and must contain returnTo.
+func (o *OpenID) AuthURL(realm, returnTo string) string {
+	params := url.Values{
+		"openid.ns":         {openIDNamespace},
+		"openid.mode":       {"checkid_setup"},
+		"openid.claimed_id": {identifierSelect},
+		"openid.identity":   {identifierSelect},
+		"openid.return_to":  {returnTo},
+		"openid.realm":      {realm},
+	}
+	return o.Endpoint + "?" + params.Encode()
+}
+
+// Verify checks the assertion the provider sent the player back to returnTo with, and returns
+// the player it signed in
+func (o *OpenID) Verify(ctx context.Context, returnTo string, params url.Values) (steamid.ID, error) {
+	switch params.Get("openid.mode") {
+	case "id_res":
+	case "cancel":
+		return 0, ErrCancelled
+	default:
+		return 0, fmt.Errorf("%w: mode %q", ErrInvalidAssertion, params.Get("openid.mode"))
+	}
+
+	if params.Get("openid.ns") != openIDNamespace {
+		return 0, fmt.Errorf("%w: namespace %q", ErrInvalidAssertion, params.Get("openid.ns"))
+	}
+	if params.Get("openid.op_endpoint") != o.Endpoint {
+		return 0, fmt.Errorf("%w: endpoint %q", ErrInvalidAssertion, params.Get("openid.op_endpoint"))
+	}
+	if params.Get("openid.return_to") != returnTo {
+		return 0, fmt.Errorf("%w: return_to %q", ErrInvalidAssertion, params.Get("openid.return_to"))
+	}
+
+	claimedID := params.Get("openid.claimed_id")
+	match := claimedIDRe.FindStringSubmatch(claimedID)
+	if match == nil || params.Get("openid.identity") != claimedID {
+		return 0, fmt.Errorf("%w: claimed id %q", ErrInvalidAssertion, claimedID)
+	}
+	steamID, err := steamid.Parse(match[1])
+	if err != nil {
+		return 0, fmt.Errorf("%w: claimed id %q", ErrInvalidAssertion, claimedID)
+	}
+
+	// everything read above has to be covered by the signature
+	signed := map[string]bool{}
+	for _, field := range strings.Split(params.Get("openid.signed"), ",") {
+		signed[field] = true
+	}
+	for _, field := range []string{"op_endpoint", "return_to", "response_nonce", "assoc_handle", "claimed_id", "identity"} {
+		if !signed[field] {
+			return 0, fmt.Errorf("%w: %s is not signed", ErrInvalidAssertion, field)
+		}
+	}
+
+	nonce := params.Get("openid.response_nonce")
+	if err := o.useNonce(nonce); err != nil {
+		return 0, err
+	}
+
+	if err := o.checkAuthentication(ctx, params); err != nil {
+		return 0, err
+	}
+	return steamID, nil
+}
+
+// useNonce rejects nonces that are too old or were seen before, so an assertion can't be replayed
+func (o *OpenID) useNonce(nonce string) error {
+	// nonces start with the time they were issued, e.g. 2024-08-01T17:03:12Zb1vX0fHm
+	if len(nonce) < 20 {
+		return fmt.Errorf("%w: nonce %q", ErrInvalidAssertion, nonce)
+	}
+	issued, err := time.Parse("2006-01-02T15:04:05Z", nonce[:20])
+	if err != nil {
+		return fmt.Errorf("%w: nonce %q", ErrInvalidAssertion, nonce)
+	}
+	now := time.Now()
+	if age := now.Sub(issued); age > o.MaxAge || age < -o.MaxAge {
+		return fmt.Errorf("%w: nonce issued at %s", ErrInvalidAssertion, issued)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.nonces == nil {
+		o.nonces = map[string]time.Time{}
+	}
+	for seen, expires := range o.nonces {
+		if now.After(expires) {
+			delete(o.nonces, seen)
+		}
+	}
+	if _, ok := o.nonces[nonce]; ok {
+		return fmt.Errorf("%w: nonce %q was already used", ErrInvalidAssertion, nonce)
+	}
+	o.nonces[nonce] = issued.Add(o.MaxAge)
+	return nil
+}
+
+// checkAuthentication asks the provider whether it signed the assertion
+func (o *OpenID) checkAuthentication(ctx context.Context, params url.Values) error {
+	form := url.Values{}
+	for key, values := range params {
+		if strings.HasPrefix(key, "openid.") {
+			form[key] = values
+		}
+	}
+	form.Set("openid.mode", "check_authentication")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("check_authentication: status %d", resp.StatusCode)
+	}
+
+	// the response is key:value lines
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if key, value, _ := strings.Cut(scanner.Text(), ":"); key == "is_valid" {
+			if value != "true" {
+				return fmt.Errorf("%w: rejected by the provider", ErrInvalidAssertion)
+			}
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: no is_valid in the provider's response", ErrInvalidAssertion)
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sawatkins/tf2dl-servers/steamid"
+)
+
+var ErrInvalidSession = errors.New("auth: invalid session")
+
+// Sessions signs session cookies, so the player's SteamID can be kept in the cookie itself
+// instead of a session table
+type Sessions struct {
+	secret []byte
+	MaxAge time.Duration
+}
+
+func NewSessions(secret []byte) *Sessions {
+	return &Sessions{secret: secret, MaxAge: 30 * 24 * time.Hour}
+}
+
+// RandomSecret returns a secret for signing sessions that are only valid until a restart
+func RandomSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return secret
+}
+
+// Encode returns a cookie value signing in the player for MaxAge, as steamid64.expiry.signature
+func (s *Sessions) Encode(id steamid.ID) string {
+	payload := fmt.Sprintf("%d.%d", id.SteamID64(), time.Now().Add(s.MaxAge).Unix())
+	return payload + "." + s.sign(payload)
+}
+
+// Decode returns the player a cookie value signs in, if it is signed and hasn't expired
+func (s *Sessions) Decode(value string) (steamid.ID, error) {
+	i := strings.LastIndexByte(value, '.')
+	if i < 0 {
+		return 0, ErrInvalidSession
+	}
+	payload, signature := value[:i], value[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(s.sign(payload))) {
+		return 0, ErrInvalidSession
+	}
+
+	steamID64, expiry, _ := strings.Cut(payload, ".")
+	id, err := steamid.Parse(steamID64)
+	if err != nil {
+		return 0, ErrInvalidSession
+	}
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().Unix() >= expires {
+		return 0, ErrInvalidSession
+	}
+	return id, nil
+}
+
+func (s *Sessions) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
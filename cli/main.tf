@@ -25,27 +25,18 @@ module "tf2_server_eu" {
 }
 
 
-output "tf2_server_us_id" {
-  value = module.tf2_server_us.instance_id
-}
-
-output "tf2_server_us_public_ip" {
-  value = module.tf2_server_us.instance_public_ip
-}
-
-output "tf2_server_us_public_dns" {
-  value = module.tf2_server_us.instance_public_dns
-}
-
-
-output "tf2_server_eu_id" {
-  value = module.tf2_server_eu.instance_id
-}
-
-output "tf2_server_eu_public_ip" {
-  value = module.tf2_server_eu.instance_public_ip
-}
-
-output "tf2_server_eu_public_dns" {
-  value = module.tf2_server_eu.instance_public_dns
+# servers is read by manage.py, which registers every entry with the metadata in servers.json
+output "servers" {
+  value = {
+    tf2_server_us = {
+      instance_id = module.tf2_server_us.instance_id
+      public_ip   = module.tf2_server_us.instance_public_ip
+      public_dns  = module.tf2_server_us.instance_public_dns
+    }
+    tf2_server_eu = {
+      instance_id = module.tf2_server_eu.instance_id
+      public_ip   = module.tf2_server_eu.instance_public_ip
+      public_dns  = module.tf2_server_eu.instance_public_dns
+    }
+  }
 }
@@ -9,39 +9,65 @@ import json
 import requests
 
 
-def write_server_to_curent_servers_file(new_server):
-    current_servers = read_current_servers_file()
-    current_servers[new_server["instance_id"]] = {
+# fields of servers.json sent to the api when registering a server
+METADATA_FIELDS = ["server_hostname", "region", "display_name", "flag", "game_port", "sort_order", "hidden"]
+
+def current_server_entry(new_server):
+    return {
         "public_ip": new_server["public_ip"],
         "public_dns": new_server["public_dns"],
         "name": new_server["name"],
-        "server_hostname": new_server["server_hostname"]
+        **{field: new_server[field] for field in METADATA_FIELDS if field in new_server}
     }
 
-    with open("./current-servers.json", "w") as f:
+# write_current_servers_file replaces current-servers.json in one step, so a failure leaves the old file
+def write_current_servers_file(current_servers):
+    with open("./current-servers.json.tmp", "w") as f:
         json.dump(current_servers, f, indent=4)
+    os.replace("./current-servers.json.tmp", "./current-servers.json")
+
+def api_headers():
+    return { "Authorization": os.getenv("CLI_AUTH_KEY").strip('"') }
+
+def api_url(instance_id):
+    port = 8080 #(5000) TODO: find better way to manage this
+    return f"http://localhost:{port}/api/servers/{instance_id}"
 
 def post_current_servers_to_db():
     current_servers = read_current_servers_file()
-    headers = { "Authorization": os.getenv("CLI_AUTH_KEY").strip('"') }
-    port = 8080 #(5000) TODO: find better way to manage this
     
     for instance_id, server_info in current_servers.items():
         payload = {
-            "instance_id": instance_id,
             "public_ip": server_info.get("public_ip"),
             "public_dns": server_info.get("public_dns"),
             "name": server_info.get("name"),
-            "server_hostname": server_info.get("server_hostname"),
+            **{field: server_info[field] for field in METADATA_FIELDS if field in server_info}
         }
         
         try:
-            response = requests.post(f"http://localhost:{port}/api/current-servers", json=payload, headers=headers)
+            response = requests.put(api_url(instance_id), json=payload, headers=api_headers())
             response.raise_for_status()  
             print(f"Posted server {instance_id} to database.")
         except requests.exceptions.RequestException as e:
             print(f"Error posting server {instance_id} to database: {e}")
 
+def delete_servers_from_db(instance_ids):
+    for instance_id in instance_ids:
+        try:
+            response = requests.delete(api_url(instance_id), headers=api_headers())
+            if response.status_code == 404:
+                print(f"Server {instance_id} was not in database.")
+                continue
+            response.raise_for_status()
+            print(f"Deleted server {instance_id} from database.")
+        except requests.exceptions.RequestException as e:
+            print(f"Error deleting server {instance_id} from database: {e}")
+
+# read_server_metadata returns the display metadata of each terraform server from servers.json
+def read_server_metadata():
+    with open("./servers.json", "r") as f:
+        return json.load(f)
+
 def read_current_servers_file():
     if os.path.exists("./current-servers.json"):
         with open("./current-servers.json", "r") as f:
@@ -60,25 +86,30 @@ def create_server():
         print(f"Error: Terraform apply failed with exit code {e.returncode}")
         sys.exit(1)
     
-    tf2_server_us = {
-        "instance_id": subprocess.check_output(["terraform", "output", "-raw", "tf2_server_us_id"]).decode().strip(),
-        "public_ip": subprocess.check_output(["terraform", "output", "-raw", "tf2_server_us_public_ip"]).decode().strip(), # TODO get the elastic ip
-        "public_dns": subprocess.check_output(["terraform", "output", "-raw", "tf2_server_us_public_dns"]).decode().strip(),
-        "name": "tf2_server_us",
-        "server_hostname": "simple surf server (us) - servers.tf2dl.net"
-    }
-    write_server_to_curent_servers_file(tf2_server_us)
-    
-    tf2_server_eu = {
-        "instance_id": subprocess.check_output(["terraform", "output", "-raw", "tf2_server_eu_id"]).decode().strip(),
-        "public_ip": subprocess.check_output(["terraform", "output", "-raw", "tf2_server_eu_public_ip"]).decode().strip(),
-        "public_dns": subprocess.check_output(["terraform", "output", "-raw", "tf2_server_eu_public_dns"]).decode().strip(),
-        "name": "tf2_server_eu",
-        "server_hostname": "simple surf server (eu) - servers.tf2dl.net"
-    }
-    write_server_to_curent_servers_file(tf2_server_eu)
+    previous_servers = read_current_servers_file()
+    metadata = read_server_metadata()
+    try:
+        servers = json.loads(subprocess.check_output(["terraform", "output", "-json", "servers"]).decode())
+    except subprocess.CalledProcessError as e:
+        print(f"Error: Terraform output failed with exit code {e.returncode}")
+        sys.exit(1)
+
+    current_servers = {}
+    for name, outputs in servers.items():
+        if name not in metadata:
+            print(f"Warning: {name} has no entry in servers.json, registering it without display metadata")
+        current_servers[outputs["instance_id"]] = current_server_entry({
+            "public_ip": outputs["public_ip"], # TODO get the elastic ip
+            "public_dns": outputs["public_dns"],
+            "name": name,
+            **metadata.get(name, {})
+        })
+    write_current_servers_file(current_servers)
     
     post_current_servers_to_db()
+
+    # remove instances terraform replaced or no longer manages
+    delete_servers_from_db([i for i in previous_servers if i not in current_servers])
     
 def print_current_servers():
     current_servers = read_current_servers_file()
@@ -91,7 +122,8 @@ def print_current_servers():
         print(f"  Public IP: {server_info['public_ip']}")
         print(f"  Public DNS: {server_info['public_dns']}")
         print(f"  Name: {server_info['name']}")
-        print(f"  Server Hostname: {server_info['server_hostname']}")
+        print(f"  Server Hostname: {server_info.get('server_hostname', '')}")
+        print(f"  Region: {server_info.get('region', '')}")
         print("")
 
 def connect_to_server():
@@ -112,8 +144,25 @@ def connect_to_server():
         
 def destroy_server():
     # for now, delete all
-    subprocess.run(["terraform", "destroy", "-var-file", f"./upfast.tfvars"], check=True)
-    os.remove("./current-servers.json")
+    try:
+        subprocess.run(["terraform", "destroy", "-var-file", f"./upfast.tfvars"], check=True)
+    except subprocess.CalledProcessError as e:
+        print(f"Error: Terraform destroy failed with exit code {e.returncode}")
+        sys.exit(1)
+    delete_servers_from_db(read_current_servers_file().keys())
+    write_current_servers_file({})
+
+def start_update():
+    port = 8080
+    response = requests.post(f"http://localhost:{port}/api/updates", params={"reason": "Started from manage.py"}, headers=api_headers())
+    if response.status_code != 202:
+        print(f"Error: failed to start update: {response.status_code} {response.text}")
+        return
+    started = response.json()["started"]
+    if not started:
+        print("Every server is already being updated.")
+        return
+    print(f"Updating {', '.join(started)}")
 
 def check_dependencies():
     required_programs = ["aws", "terraform"]
@@ -134,7 +183,7 @@ def main():
                 os.environ[key] = value
 
     parser = argparse.ArgumentParser(description="manage servers.tf2dl.net servers")
-    parser.add_argument("command", choices=["create", "destroy", "list", "connect", "write_db"], help="command to execute")
+    parser.add_argument("command", choices=["create", "destroy", "list", "connect", "write_db", "update"], help="command to execute")
 
     args = parser.parse_args()
     if args.command == "create":
@@ -147,6 +196,8 @@ def main():
         connect_to_server()
     elif args.command == "write_db":
         post_current_servers_to_db()
+    elif args.command == "update":
+        start_update()
     else:
         parser.print_help()
         sys.exit(1)
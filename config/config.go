@@ -0,0 +1,391 @@
+// Package config loads the backend's settings from defaults, a TOML file, environment
+// variables and command line flags, each overriding the ones before it.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+
+	"github.com/sawatkins/tf2dl-servers/auth"
+	"github.com/sawatkins/tf2dl-servers/notify"
+)
+
+// Config is every setting of the backend
+type Config struct {
+	Listen         string        `toml:"listen"`   // address the web server listens on
+	Dev            bool          `toml:"dev"`      // reload templates on every request
+	Database       string        `toml:"database"` // path of the SQLite database
+	CLIAuthKey     string        `toml:"cli_auth_key"`
+	LogAddr        string        `toml:"log_addr"` // UDP address to receive game server logs on, empty to disable
+	LogSecret      string        `toml:"log_secret"`
+	LogTimezone    string        `toml:"log_timezone"` // time zone of the game servers' log timestamps, e.g. Europe/Berlin
+	ReconnectGrace time.Duration `toml:"reconnect_grace"`
+
+	Poll    Poll              `toml:"poll"`
+	Servers map[string]Server `toml:"servers"` // settings of individual servers by public IP
+	Notify  Notify            `toml:"notify"`
+	RSS     RSS               `toml:"rss"`
+	Updates Updates           `toml:"updates"`
+	Auth    Auth              `toml:"auth"`
+	Privacy Privacy           `toml:"privacy"`
+}
+
+type Poll struct {
+	Interval          time.Duration `toml:"interval"`
+	Timeout           time.Duration `toml:"timeout"`
+	DownAfterFailures int           `toml:"down_after_failures"`
+	RCONPort          int           `toml:"rcon_port"`
+	RCONPassword      string        `toml:"rcon_password"`
+	QueryPort         int           `toml:"query_port"`
+}
+
+// Server overrides the poll settings for one server, zero values keep them
+type Server struct {
+	RCONPort     int    `toml:"rcon_port"`
+	RCONPassword string `toml:"rcon_password"`
+}
+
+type Notify struct {
+	Targets       []string                  `toml:"targets"` // kind:url, see notify.ParseTargets
+	WebhookSecret string                    `toml:"webhook_secret"`
+	Templates     map[string]NotifyTemplate `toml:"templates"` // by event, e.g. server_down
+}
+
+// NotifyTemplate overrides the text/template of an alert's title or text, empty ones keep the default
+type NotifyTemplate struct {
+	Title string `toml:"title"`
+	Text  string `toml:"text"`
+}
+
+type RSS struct {
+	URL      string        `toml:"url"`
+	Interval time.Duration `toml:"interval"`
+}
+
+type Updates struct {
+	Auto      bool          `toml:"auto"`
+	Wait      time.Duration `toml:"wait"`
+	Countdown time.Duration `toml:"countdown"`
+	Hook      string        `toml:"hook"`
+}
+
+// Auth is Steam sign in
+type Auth struct {
+	PublicURL      string `toml:"public_url"`      // the site's URL, e.g. https://servers.tf2dl.net, taken from requests if empty
+	SessionSecret  string `toml:"session_secret"`  // signs session cookies, random if empty so restarts sign everyone out
+	OpenIDEndpoint string `toml:"openid_endpoint"` // Steam's OpenID provider
+}
+
+// Privacy mode stores keyed hashes instead of Steam ids. Ids already stored are hashed when it
+// is turned on, which can't be undone, and the secret can't change afterwards without splitting
+// every player's stats in two.
+type Privacy struct {
+	HashIDs bool   `toml:"hash_ids"`
+	Secret  string `toml:"secret"`
+}
+
+// Default returns the settings used when nothing else sets them
+func Default() Config {
+	return Config{
+		Listen:         ":8080",
+		Dev:            true,
+		Database:       "./data/upfast.db",
+		LogTimezone:    "UTC",
+		ReconnectGrace: 5 * time.Minute,
+		Poll: Poll{
+			Interval:          30 * time.Second,
+			Timeout:           10 * time.Second,
+			DownAfterFailures: 3,
+			RCONPort:          27015,
+			QueryPort:         27015,
+		},
+		RSS: RSS{
+			URL:      "https://www.teamfortress.com/rss.xml",
+			Interval: 3 * time.Hour,
+		},
+		Updates: Updates{
+			Wait:      30 * time.Minute,
+			Countdown: 5 * time.Minute,
+		},
+		Auth: Auth{
+			OpenIDEndpoint: auth.SteamEndpoint,
+		},
+	}
+}
+
+// Load reads the configuration for the command line args, without the program name.
+// printConfig is true if -print-config was given.
+func Load(args []string) (cfg Config, printConfig bool, err error) {
+	// the first pass only finds the files to read, the flags are applied again on top of them
+	cfg = Default()
+	fs, options := newFlagSet(&cfg)
+	if err := fs.Parse(args); err != nil {
+		return cfg, false, err
+	}
+
+	if options.envFile != "" {
+		if err := godotenv.Load(options.envFile); errors.Is(err, os.ErrNotExist) {
+			log.Printf("No env file at %s", options.envFile)
+		} else if err != nil {
+			return cfg, false, fmt.Errorf("reading %s: %w", options.envFile, err)
+		}
+	}
+
+	cfg = Default()
+	if options.file != "" {
+		if _, err := toml.DecodeFile(options.file, &cfg); err != nil {
+			return cfg, false, fmt.Errorf("reading %s: %w", options.file, err)
+		}
+	}
+	if err := applyEnv(&cfg); err != nil {
+		return cfg, false, err
+	}
+
+	fs, options = newFlagSet(&cfg)
+	if err := fs.Parse(args); err != nil {
+		return cfg, false, err
+	}
+
+	return cfg, options.printConfig, cfg.Validate()
+}
+
+type flagOptions struct {
+	file        string
+	envFile     string
+	printConfig bool
+}
+
+func newFlagSet(cfg *Config) (*flag.FlagSet, *flagOptions) {
+	options := &flagOptions{}
+	fs := flag.NewFlagSet("tf2dl-servers", flag.ContinueOnError)
+	fs.StringVar(&options.file, "config", os.Getenv("TF2DL_CONFIG"), "TOML file to read settings from")
+	fs.StringVar(&options.envFile, "env-file", "./cli/.env", "File of environment variables to load if it exists, empty to skip")
+	fs.BoolVar(&options.printConfig, "print-config", false, "Print the configuration with secrets redacted and exit")
+
+	fs.StringVar(&cfg.Listen, "port", cfg.Listen, "Address to listen on")
+	fs.BoolVar(&cfg.Dev, "dev", cfg.Dev, "Enable development mode")
+	fs.StringVar(&cfg.Database, "db", cfg.Database, "Path of the SQLite database")
+	fs.StringVar(&cfg.LogAddr, "log-addr", cfg.LogAddr, "UDP address to receive game server logs on (logaddress_add), empty to disable")
+	fs.StringVar(&cfg.LogTimezone, "log-timezone", cfg.LogTimezone, "Time zone the game servers write log timestamps in")
+	fs.DurationVar(&cfg.ReconnectGrace, "reconnect-grace", cfg.ReconnectGrace, "Resume player sessions last seen within this window before a restart")
+	fs.DurationVar(&cfg.Poll.Interval, "poll-interval", cfg.Poll.Interval, "How often the game servers are polled")
+	fs.StringVar(&cfg.RSS.URL, "rss-url", cfg.RSS.URL, "TF2 news feed to watch for game updates")
+	fs.BoolVar(&cfg.Updates.Auto, "auto-update", cfg.Updates.Auto, "Restart the game servers onto a new TF2 patch when one is released")
+	fs.DurationVar(&cfg.Updates.Wait, "update-wait", cfg.Updates.Wait, "How long to wait for a server to empty before warning its players of an update, 0 to wait indefinitely")
+	fs.DurationVar(&cfg.Updates.Countdown, "update-countdown", cfg.Updates.Countdown, "How long players are warned for before a server restarts for an update")
+	fs.BoolVar(&cfg.Privacy.HashIDs, "hash-ids", cfg.Privacy.HashIDs, "Store keyed hashes of Steam ids instead of the ids, needs a privacy secret")
+	fs.StringVar(&cfg.Updates.Hook, "update-hook", cfg.Updates.Hook, "Shell command that updates and restarts the server in $SERVER_IP, instead of RCON quit")
+	return fs, options
+}
+
+// envVars are the environment variables read into the configuration, in the order they are applied
+var envVars = []struct {
+	name  string
+	apply func(cfg *Config, value string) error
+}{
+	{"TF2DL_LISTEN", func(cfg *Config, value string) error { cfg.Listen = value; return nil }},
+	{"TF2DL_DATABASE", func(cfg *Config, value string) error { cfg.Database = value; return nil }},
+	{"TF2DL_POLL_INTERVAL", func(cfg *Config, value string) (err error) {
+		cfg.Poll.Interval, err = time.ParseDuration(value)
+		return err
+	}},
+	{"TF2DL_RSS_URL", func(cfg *Config, value string) error { cfg.RSS.URL = value; return nil }},
+	{"RCON_PASSWORD", func(cfg *Config, value string) error { cfg.Poll.RCONPassword = value; return nil }},
+	{"CLI_AUTH_KEY", func(cfg *Config, value string) error { cfg.CLIAuthKey = value; return nil }},
+	{"LOG_SECRET", func(cfg *Config, value string) error { cfg.LogSecret = value; return nil }},
+	{"TF2DL_LOG_TIMEZONE", func(cfg *Config, value string) error { cfg.LogTimezone = value; return nil }},
+	{"NOTIFY_TARGETS", func(cfg *Config, value string) error {
+		cfg.Notify.Targets = strings.FieldsFunc(value, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+		return nil
+	}},
+	// the single ntfy target from before NOTIFY_TARGETS, added to the others
+	{"NOTIFY_URL", func(cfg *Config, value string) error {
+		cfg.Notify.Targets = append(cfg.Notify.Targets, notify.KindNtfy+":"+value)
+		return nil
+	}},
+	{"NOTIFY_WEBHOOK_SECRET", func(cfg *Config, value string) error { cfg.Notify.WebhookSecret = value; return nil }},
+	{"TF2DL_PUBLIC_URL", func(cfg *Config, value string) error { cfg.Auth.PublicURL = value; return nil }},
+	{"SESSION_SECRET", func(cfg *Config, value string) error { cfg.Auth.SessionSecret = value; return nil }},
+	{"PRIVACY_SECRET", func(cfg *Config, value string) error { cfg.Privacy.Secret = value; return nil }},
+}
+
+func applyEnv(cfg *Config) error {
+	for _, env := range envVars {
+		value := os.Getenv(env.name)
+		if value == "" {
+			continue
+		}
+		if err := env.apply(cfg, value); err != nil {
+			return fmt.Errorf("%s: %w", env.name, err)
+		}
+	}
+	return nil
+}
+
+// Validate reports the first setting that can't work
+func (c Config) Validate() error {
+	switch {
+	case c.Listen == "":
+		return errors.New("listen address is empty")
+	case c.Database == "":
+		return errors.New("database path is empty")
+	case c.ReconnectGrace < 0:
+		return errors.New("reconnect_grace is negative")
+	case c.Poll.Interval < time.Second:
+		return fmt.Errorf("poll interval %s is under 1s", c.Poll.Interval)
+	case c.Poll.Timeout <= 0:
+		return errors.New("poll timeout must be positive")
+	case c.Poll.DownAfterFailures < 1:
+		return errors.New("down_after_failures must be at least 1")
+	case !validPort(c.Poll.RCONPort):
+		return fmt.Errorf("invalid rcon_port %d", c.Poll.RCONPort)
+	case !validPort(c.Poll.QueryPort):
+		return fmt.Errorf("invalid query_port %d", c.Poll.QueryPort)
+	case c.RSS.Interval < time.Minute:
+		return fmt.Errorf("rss interval %s is under 1m", c.RSS.Interval)
+	case c.Updates.Wait < 0 || c.Updates.Countdown < 0:
+		return errors.New("update wait and countdown can't be negative")
+	case c.Privacy.HashIDs && len(c.Privacy.Secret) < 16:
+		return errors.New("privacy mode needs a secret of at least 16 characters")
+	}
+
+	if _, err := c.LogLocation(); err != nil {
+		return fmt.Errorf("invalid log_timezone %q", c.LogTimezone)
+	}
+	if !validHTTPURL(c.RSS.URL) {
+		return fmt.Errorf("invalid rss url %q", c.RSS.URL)
+	}
+	if !validHTTPURL(c.Auth.OpenIDEndpoint) {
+		return fmt.Errorf("invalid openid endpoint %q", c.Auth.OpenIDEndpoint)
+	}
+	if c.Auth.PublicURL != "" && !validHTTPURL(c.Auth.PublicURL) {
+		return fmt.Errorf("invalid public url %q", c.Auth.PublicURL)
+	}
+	for ip, server := range c.Servers {
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("servers: %q is not an IP address", ip)
+		}
+		if server.RCONPort != 0 && !validPort(server.RCONPort) {
+			return fmt.Errorf("servers.%s: invalid rcon_port %d", ip, server.RCONPort)
+		}
+	}
+	if _, err := c.NotifyTargets(); err != nil {
+		return fmt.Errorf("notify: %w", err)
+	}
+	if _, err := c.NotifyTemplates(); err != nil {
+		return fmt.Errorf("notify: %w", err)
+	}
+	return nil
+}
+
+// NotifyTargets builds the configured notify targets
+func (c Config) NotifyTargets() ([]notify.Notifier, error) {
+	return notify.ParseTargets(strings.Join(c.Notify.Targets, " "), c.Notify.WebhookSecret)
+}
+
+// NotifyTemplates returns the default alert templates with the configured ones applied
+func (c Config) NotifyTemplates() (map[string]notify.Template, error) {
+	templates := notify.DefaultTemplates()
+	for event, override := range c.Notify.Templates {
+		tmpl, ok := templates[event]
+		if !ok {
+			return nil, fmt.Errorf("templates: unknown event %q", event)
+		}
+		tmpl, err := tmpl.Override(override.Title, override.Text)
+		if err != nil {
+			return nil, fmt.Errorf("templates.%s %w", event, err)
+		}
+		templates[event] = tmpl
+	}
+	return templates, nil
+}
+
+// LogLocation returns the time zone of the game servers' log timestamps
+func (c Config) LogLocation() (*time.Location, error) {
+	return time.LoadLocation(c.LogTimezone)
+}
+
+// RCON returns the RCON port and password of the server at ip
+func (c Config) RCON(ip string) (port, password string) {
+	port, password = strconv.Itoa(c.Poll.RCONPort), c.Poll.RCONPassword
+	if server, ok := c.Servers[ip]; ok {
+		if server.RCONPort != 0 {
+			port = strconv.Itoa(server.RCONPort)
+		}
+		if server.RCONPassword != "" {
+			password = server.RCONPassword
+		}
+	}
+	return port, password
+}
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+func validHTTPURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+const redacted = "REDACTED"
+
+// Redacted returns a copy of the configuration with passwords, keys and secret URLs hidden
+func (c Config) Redacted() Config {
+	redact := func(secret string) string {
+		if secret == "" {
+			return ""
+		}
+		return redacted
+	}
+
+	c.CLIAuthKey = redact(c.CLIAuthKey)
+	c.LogSecret = redact(c.LogSecret)
+	c.Poll.RCONPassword = redact(c.Poll.RCONPassword)
+	c.Notify.WebhookSecret = redact(c.Notify.WebhookSecret)
+	c.Auth.SessionSecret = redact(c.Auth.SessionSecret)
+	c.Privacy.Secret = redact(c.Privacy.Secret)
+
+	servers := make(map[string]Server, len(c.Servers))
+	for ip, server := range c.Servers {
+		server.RCONPassword = redact(server.RCONPassword)
+		servers[ip] = server
+	}
+	c.Servers = servers
+
+	// webhook URLs carry their token in the path, so only the host is kept
+	targets := make([]string, len(c.Notify.Targets))
+	for i, target := range c.Notify.Targets {
+		kind, rawURL, _ := strings.Cut(target, ":")
+		if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+			targets[i] = kind + ":" + u.Scheme + "://" + u.Host + "/" + redacted
+		} else {
+			targets[i] = kind + ":" + redacted
+		}
+	}
+	c.Notify.Targets = targets
+
+	return c
+}
+
+// String formats the configuration as TOML, with secrets redacted
+func (c Config) String() string {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(c.Redacted()); err != nil {
+		return "error encoding config: " + err.Error()
+	}
+	return buf.String()
+}
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sawatkins/tf2dl-servers/notify"
+)
+
+const testConfig = `
+listen = ":9000"
+database = "/var/lib/tf2dl/servers.db"
+cli_auth_key = "cli-secret"
+log_timezone = "Europe/Berlin"
+
+[poll]
+interval = "15s"
+rcon_password = "file-password"
+
+[servers."54.193.198.90"]
+rcon_port = 27016
+rcon_password = "us-password"
+
+[notify]
+targets = ["discord:https://discord.com/api/webhooks/1/token-secret"]
+
+[notify.templates.server_down]
+title = "{{.Server}} went down"
+
+[auth]
+session_secret = "session-secret"
+
+[privacy]
+hash_ids = true
+secret = "privacy-secret-1234"
+`
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+// clearEnv unsets the environment variables read by Load for the rest of the test
+func clearEnv(t *testing.T) {
+	t.Setenv("TF2DL_CONFIG", "")
+	for _, env := range envVars {
+		t.Setenv(env.name, "")
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	clearEnv(t)
+
+	cfg, printConfig, err := Load([]string{"-env-file", ""})
+	if err != nil {
+		t.Fatalf("Failed to load defaults: %v", err)
+	}
+	if printConfig || cfg.Listen != ":8080" || cfg.Database != "./data/upfast.db" || cfg.Poll.Interval != 30*time.Second || cfg.LogTimezone != "UTC" {
+		t.Errorf("Unexpected defaults %+v", cfg)
+	}
+}
+
+// Env vars override the file and flags override both, but only flags that were given
+func TestLayering(t *testing.T) {
+	clearEnv(t)
+	path := writeConfig(t, testConfig)
+	t.Setenv("TF2DL_POLL_INTERVAL", "20s")
+	t.Setenv("RCON_PASSWORD", "env-password")
+	t.Setenv("NOTIFY_URL", "https://ntfy.sh/tf2dl")
+
+	cfg, printConfig, err := Load([]string{"-config", path, "-env-file", "", "-port", ":9100", "-print-config"})
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if !printConfig {
+		t.Errorf("Expected -print-config to be reported")
+	}
+	if cfg.Listen != ":9100" || cfg.Database != "/var/lib/tf2dl/servers.db" || cfg.Poll.Interval != 20*time.Second {
+		t.Errorf("Unexpected layering %+v", cfg)
+	}
+	if loc, err := cfg.LogLocation(); err != nil || loc.String() != "Europe/Berlin" {
+		t.Errorf("Expected the log time zone from the file, got %v %v", loc, err)
+	}
+	if len(cfg.Notify.Targets) != 2 || cfg.Notify.Targets[1] != "ntfy:https://ntfy.sh/tf2dl" {
+		t.Errorf("Expected NOTIFY_URL to be added to the targets, got %v", cfg.Notify.Targets)
+	}
+
+	templates, err := cfg.NotifyTemplates()
+	if err != nil {
+		t.Fatalf("Failed to build notify templates: %v", err)
+	}
+	var title strings.Builder
+	templates[notify.EventServerDown].Title.Execute(&title, map[string]string{"Server": "surf (eu)"})
+	if title.String() != "surf (eu) went down" || templates[notify.EventServerUp].Title == nil {
+		t.Errorf("Expected the server_down title from the file and the other defaults, got %q", title.String())
+	}
+
+	if port, password := cfg.RCON("54.193.198.90"); port != "27016" || password != "us-password" {
+		t.Errorf("Expected the server's own RCON settings, got %s %s", port, password)
+	}
+	if port, password := cfg.RCON("10.0.0.1"); port != "27015" || password != "env-password" {
+		t.Errorf("Expected the default RCON settings, got %s %s", port, password)
+	}
+}
+
+func TestEnvFile(t *testing.T) {
+	clearEnv(t)
+	envFile := filepath.Join(t.TempDir(), ".env")
+	os.WriteFile(envFile, []byte("CLI_AUTH_KEY=from-env-file\n"), 0o600)
+	os.Unsetenv("CLI_AUTH_KEY") // the env file doesn't override variables that are set, restored by clearEnv
+
+	cfg, _, err := Load([]string{"-env-file", envFile})
+	if err != nil || cfg.CLIAuthKey != "from-env-file" {
+		t.Errorf("Expected the key from the env file, got %q %v", cfg.CLIAuthKey, err)
+	}
+
+	if _, _, err := Load([]string{"-env-file", filepath.Join(t.TempDir(), "missing")}); err != nil {
+		t.Errorf("Expected a missing env file to be skipped, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	clearEnv(t)
+
+	for name, content := range map[string]string{
+		"poll interval": "[poll]\ninterval = \"100ms\"",
+		"rcon port":     "[poll]\nrcon_port = 70000",
+		"server ip":     "[servers.us-west]\nrcon_port = 27015",
+		"rss url":       "[rss]\nurl = \"ftp://example.com/rss.xml\"",
+		"notify target": "[notify]\ntargets = [\"email:admin@example.com\"]",
+		"notify event":  "[notify.templates.server_crashed]\ntitle = \"{{.Server}} crashed\"",
+		"template":      "[notify.templates.server_down]\ntext = \"{{.Server\"",
+		"public url":    "[auth]\npublic_url = \"servers.tf2dl.net\"",
+		"privacy":       "[privacy]\nhash_ids = true",
+		"log time zone": "log_timezone = \"Mars/Olympus\"",
+		"unknown type":  "listen = 8080",
+	} {
+		if _, _, err := Load([]string{"-config", writeConfig(t, content), "-env-file", ""}); err == nil {
+			t.Errorf("Expected an error for an invalid %s", name)
+		}
+	}
+}
+
+// Printing the config must not reveal passwords, keys or webhook tokens
+func TestRedacted(t *testing.T) {
+	clearEnv(t)
+	cfg, _, err := Load([]string{"-config", writeConfig(t, testConfig), "-env-file", ""})
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	printed := cfg.String()
+	for _, secret := range []string{"cli-secret", "file-password", "us-password", "token-secret", "session-secret", "privacy-secret"} {
+		if strings.Contains(printed, secret) {
+			t.Errorf("Printed config contains %q:\n%s", secret, printed)
+		}
+	}
+	if !strings.Contains(printed, `"discord:https://discord.com/REDACTED"`) || !strings.Contains(printed, "rcon_port = 27016") {
+		t.Errorf("Expected the rest of the config to be printed:\n%s", printed)
+	}
+	if cfg.Poll.RCONPassword != "file-password" {
+		t.Errorf("Redacting must not change the config")
+	}
+}
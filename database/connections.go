@@ -0,0 +1,255 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/sawatkins/tf2dl-servers/metrics"
+	"github.com/sawatkins/tf2dl-servers/models"
+)
+
+// EndReasonShutdown marks sessions that were closed because this process stopped, not because the player left
+const EndReasonShutdown = "server shutdown"
+
+// EndReasonRemoved marks sessions that were closed because their server was deleted
+const EndReasonRemoved = "server removed"
+
+// LoadActiveConnections returns the player connections that were open when the process last stopped,
+// in the map[ip]map[playerID]timestamp form used by UpdateServerInfo.
+// Connections last seen within grace are resumed with their original connect time, and the session
+// FlushConnections wrote for them is removed again. Older ones are closed as sessions ending at their
+// last sighting, since the player can't be assumed to still be there.
+func LoadActiveConnections(grace time.Duration) map[string]map[string]int64 {
+	connections := map[string]map[string]int64{}
+
+	rows, err := db.Query("SELECT steam_id, public_ip, connect_time, last_seen, closed_session_id FROM active_connections")
+	if err != nil {
+		log.Printf("Error querying active connections: %v", err)
+		return connections
+	}
+
+	var stale []models.PlayerSession
+	var flushed, resumedSessions []int64
+	cutoff := time.Now().Add(-grace).Unix()
+	for rows.Next() {
+		var steamID, ip string
+		var connectTime, lastSeen int64
+		var closedSessionID sql.NullInt64
+		if err := rows.Scan(&steamID, &ip, &connectTime, &lastSeen, &closedSessionID); err != nil {
+			log.Printf("Error scanning active connection: %v", err)
+			continue
+		}
+
+		if lastSeen < cutoff {
+			if closedSessionID.Valid {
+				flushed = append(flushed, closedSessionID.Int64)
+			} else {
+				stale = append(stale, newPlayerSession(steamID, ip, connectTime, lastSeen, ""))
+			}
+			continue
+		}
+
+		if closedSessionID.Valid {
+			resumedSessions = append(resumedSessions, closedSessionID.Int64)
+		}
+		if connections[ip] == nil {
+			connections[ip] = make(map[string]int64)
+		}
+		connections[ip][steamID] = connectTime
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating over active connections: %v", err)
+	}
+	rows.Close()
+
+	for i := range stale {
+		if err := closePlayerSession(&stale[i]); err != nil {
+			log.Printf("Error closing stale connection for SteamID %s: %v", stale[i].SteamID, err)
+		}
+	}
+
+	// sessions already written on shutdown stay closed, the connection just isn't tracked anymore
+	for _, id := range flushed {
+		if _, err := db.Exec("DELETE FROM active_connections WHERE closed_session_id = ?;", id); err != nil {
+			log.Printf("Error removing flushed connection: %v", err)
+		}
+	}
+
+	// resumed connections carry on, so the session written on shutdown would be counted twice
+	for _, id := range resumedSessions {
+		if err := reopenPlayerSession(id); err != nil {
+			log.Printf("Error reopening flushed session %d: %v", id, err)
+		}
+	}
+
+	log.Printf("Resumed active connections for %d servers, closed %d stale", len(connections), len(stale))
+	return connections
+}
+
+// openConnection records that a player was first seen on a server at connectTime under name.
+// An existing connection keeps the earlier of the two connect times.
+func openConnection(ip, steamID, name string, connectTime int64) error {
+	_, err := db.Exec(`
+	INSERT INTO active_connections (steam_id, public_ip, connect_time, last_seen)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT (public_ip, steam_id) DO UPDATE SET connect_time = MIN(connect_time, excluded.connect_time);`,
+		steamID, ip, connectTime, connectTime)
+	if err != nil {
+		return err
+	}
+	return seePlayer(steamID, name, connectTime)
+}
+
+// reopenPlayerSession removes the session written on shutdown for a connection that was resumed
+func reopenPlayerSession(id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var steamID string
+	if err := tx.QueryRow("SELECT steam_id FROM player_sessions WHERE id = ?;", id).Scan(&steamID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM player_sessions WHERE id = ?;", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE active_connections SET closed_session_id = NULL WHERE closed_session_id = ?;", id); err != nil {
+		return err
+	}
+	if err := countPlayerSessions(tx, steamID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// touchConnections marks every open connection on a server as seen at the given time
+func touchConnections(ip string, seen int64) error {
+	_, err := db.Exec("UPDATE active_connections SET last_seen = ? WHERE public_ip = ?;", seen, ip)
+	return err
+}
+
+// closePlayerSession writes a finished session and removes its open connection in one transaction.
+// The sessions of players erased while connected aren't written.
+func closePlayerSession(session *models.PlayerSession) error {
+	if isErased(session.SteamID) {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = insertPlayerSession(tx, session); err != nil {
+		return err
+	}
+	if err := countPlayerSessions(tx, session.SteamID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM active_connections WHERE public_ip = ? AND steam_id = ?;", session.PublicIP, session.SteamID)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	observeSessionEnd(session)
+	return nil
+}
+
+func observeSessionEnd(session *models.PlayerSession) {
+	reason := session.EndReason
+	if reason == "" {
+		reason = "disconnect"
+	}
+	metrics.SessionsEnded.WithLabelValues(session.PublicIP, reason).Inc()
+	metrics.SessionDuration.WithLabelValues(session.PublicIP).Observe(float64(session.Duration))
+}
+
+// FlushConnections writes a session ending now for every tracked connection, marked with reason.
+// The connections stay in active_connections so LoadActiveConnections can resume them after a restart.
+func FlushConnections(connections map[string]map[string]int64, reason string) {
+	dropErasedConnections(connections)
+	now := time.Now().Unix()
+	count := 0
+	for ip, players := range connections {
+		for steamID, connectTime := range players {
+			session := newPlayerSession(steamID, ip, connectTime, now, reason)
+			if err := flushPlayerSession(&session); err != nil {
+				log.Printf("Error flushing session for SteamID %s: %v", steamID, err)
+				continue
+			}
+			observeSessionEnd(&session)
+			count++
+		}
+	}
+	log.Printf("Flushed %d open player sessions", count)
+}
+
+func flushPlayerSession(session *models.PlayerSession) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sessionID, err := insertPlayerSession(tx, session)
+	if err != nil {
+		return err
+	}
+	if err := countPlayerSessions(tx, session.SteamID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+	UPDATE active_connections SET last_seen = ?, closed_session_id = ?
+	WHERE public_ip = ? AND steam_id = ?;`,
+		time.Now().Unix(), sessionID, session.PublicIP, session.SteamID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertPlayerSession(tx *sql.Tx, session *models.PlayerSession) (int64, error) {
+	var endReason sql.NullString
+	if session.EndReason != "" {
+		endReason = sql.NullString{String: session.EndReason, Valid: true}
+	}
+
+	result, err := tx.Exec(`
+	INSERT INTO player_sessions (
+		steam_id, connect_time, disconnect_time, duration, public_ip, end_reason
+	) VALUES (?, ?, ?, ?, ?, ?);`,
+		session.SteamID,
+		session.ConnectTime,
+		session.DisconnectTime,
+		session.Duration,
+		session.PublicIP,
+		endReason,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func newPlayerSession(steamID, ip string, connectUnix, disconnectUnix int64, endReason string) models.PlayerSession {
+	connectTime := time.Unix(connectUnix, 0)
+	disconnectTime := time.Unix(disconnectUnix, 0)
+	return models.PlayerSession{
+		SteamID:        steamID,
+		ConnectTime:    formatTime(connectTime),
+		DisconnectTime: formatTime(disconnectTime),
+		Duration:       int(disconnectTime.Sub(connectTime).Seconds()),
+		PublicIP:       ip,
+		EndReason:      endReason,
+	}
+}
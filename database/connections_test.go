@@ -0,0 +1,80 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sawatkins/tf2dl-servers/steamid"
+)
+
+// Connections seen within the grace window are resumed, older ones are closed as sessions
+func TestLoadActiveConnections(t *testing.T) {
+	InitDB(":memory:")
+	t.Cleanup(Close)
+
+	now := time.Now().Unix()
+	if err := openConnection("192.168.1.1", "U:1:1", "Player One", now-600); err != nil {
+		t.Fatalf("Failed to open connection: %v", err)
+	}
+	if err := touchConnections("192.168.1.1", now-30); err != nil {
+		t.Fatalf("Failed to touch connections: %v", err)
+	}
+	if err := openConnection("192.168.1.2", "U:1:2", "Player Two", now-7200); err != nil {
+		t.Fatalf("Failed to open connection: %v", err)
+	}
+
+	connections := LoadActiveConnections(5 * time.Minute)
+
+	if got := connections["192.168.1.1"]["U:1:1"]; got != now-600 {
+		t.Errorf("Expected resumed connect time %d, got %d", now-600, got)
+	}
+	if _, ok := connections["192.168.1.2"]; ok {
+		t.Errorf("Expected stale connection to be closed, got %v", connections)
+	}
+
+	if sessions := GetTotalPlayerSessions(); sessions != 1 {
+		t.Errorf("Expected 1 closed session, got %d", sessions)
+	}
+	if player, err := GetPlayer(steamid.FromAccount(2)); err != nil || player.SessionCount != 1 || player.LastName != "Player Two" {
+		t.Errorf("Expected the closed session to be counted for the player, got %+v %v", player, err)
+	}
+
+	// the closed connection must not be resumed again on the next start
+	connections = LoadActiveConnections(5 * time.Minute)
+	if _, ok := connections["192.168.1.2"]; ok {
+		t.Errorf("Expected stale connection to be removed, got %v", connections)
+	}
+}
+
+// Sessions flushed on shutdown are reopened if the player is still around after a quick restart
+func TestFlushConnections(t *testing.T) {
+	InitDB(":memory:")
+	t.Cleanup(Close)
+
+	now := time.Now().Unix()
+	connections := map[string]map[string]int64{"192.168.1.1": {"U:1:1": now - 600}}
+	if err := openConnection("192.168.1.1", "U:1:1", "Player One", now-600); err != nil {
+		t.Fatalf("Failed to open connection: %v", err)
+	}
+
+	FlushConnections(connections, EndReasonShutdown)
+
+	var reason string
+	if err := db.QueryRow("SELECT end_reason FROM player_sessions").Scan(&reason); err != nil {
+		t.Fatalf("Failed to read flushed session: %v", err)
+	}
+	if reason != EndReasonShutdown {
+		t.Errorf("Expected end reason %q, got %q", EndReasonShutdown, reason)
+	}
+
+	connections = LoadActiveConnections(5 * time.Minute)
+	if got := connections["192.168.1.1"]["U:1:1"]; got != now-600 {
+		t.Errorf("Expected resumed connect time %d, got %d", now-600, got)
+	}
+	if sessions := GetTotalPlayerSessions(); sessions != 0 {
+		t.Errorf("Expected flushed session to be reopened, got %d sessions", sessions)
+	}
+	if player, err := GetPlayer(steamid.FromAccount(1)); err != nil || player.SessionCount != 0 || player.TotalPlaytime != 0 {
+		t.Errorf("Expected the reopened session to be taken off the player's totals, got %+v %v", player, err)
+	}
+}
@@ -2,19 +2,20 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"log"
-	"os"
-	"regexp"
 	"strings"
 	"time"
 
-	"github.com/gorcon/rcon"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/sawatkins/tf2dl-servers/models"
 )
 
 var db *sql.DB
 
+// ErrServerNotFound is returned when no server is registered under the given instance ID
+var ErrServerNotFound = errors.New("server not found")
+
 func InitDB(filepath string) {
 	var err error
 	db, err = sql.Open("sqlite3", filepath)
@@ -22,45 +23,28 @@ func InitDB(filepath string) {
 		log.Fatalf("Error opening database: %v", err)
 	}
 
+	// the poller writes from one goroutine per server, and each connection to
+	// ":memory:" would otherwise open its own empty database
+	db.SetMaxOpenConns(1)
+
 	if err = db.Ping(); err != nil {
 		log.Fatalf("Error connecting to database: %v", err)
 	}
 	log.Println("Database connected")
-}
-
-func InitServerTable() {
-	createServerTableSQL := `
-	CREATE TABLE IF NOT EXISTS servers (
-		instance_id VARCHAR(20) PRIMARY KEY,
-		public_ip CHAR(15),
-		public_dns VARCHAR(100),
-		name VARCHAR(50),
-		server_hostname VARCHAR(100),
-		map VARCHAR(50),
-		players INTEGER,
-		max_players INTEGER,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);`
-
-	ExecuteSQL(createServerTableSQL)
-
-	log.Println("Servers table created")
-}
-
-func InitPlayerSessionTable() {
-	createPlayerSessionTableSQL := `
-	CREATE TABLE IF NOT EXISTS player_sessions (
-    	id INTEGER PRIMARY KEY AUTOINCREMENT,
-    	steam_id TEXT NOT NULL,
-    	connect_time TEXT NOT NULL,
-    	disconnect_time TEXT,
-    	duration INTEGER,
-		public_ip CHAR(15)
-	);`
 
-	ExecuteSQL(createPlayerSessionTableSQL)
+	if err = migrate(); err != nil {
+		log.Fatalf("Error migrating database: %v", err)
+	}
 
-	log.Println("PlayerSession table created")
+	if HashesPlayerIDs() {
+		hashed, err := hashPlayerIDs()
+		if err != nil {
+			log.Fatalf("Error hashing stored Steam ids: %v", err)
+		}
+		if hashed > 0 {
+			log.Printf("Privacy mode: hashed %d stored Steam ids", hashed)
+		}
+	}
 }
 
 func ExecuteSQL(sqlStatement string) {
@@ -70,17 +54,43 @@ func ExecuteSQL(sqlStatement string) {
 	}
 }
 
+// Ping checks that the database can still be reached
+func Ping() error {
+	if db == nil {
+		return errors.New("database not initialised")
+	}
+	return db.Ping()
+}
+
 func Close() {
 	if db != nil {
 		db.Close()
 	}
 }
 
+// DefaultGamePort is the game port of servers registered without one
+const DefaultGamePort = 27015
+
+// WriteServerToDB registers a server, or updates the registration of an existing instance.
+// Fields the poller maintains (map, players) are left alone on update.
 func WriteServerToDB(server *models.Server) error {
 	writeServerSQL := `
 	INSERT INTO servers (
-		instance_id, public_ip, public_dns, name, server_hostname, map, players, max_players, created_at
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`
+		instance_id, public_ip, public_dns, name, server_hostname, map, players, max_players, created_at, query_method,
+		region, display_name, flag, game_port, sort_order, hidden
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, COALESCE(NULLIF(?, ''), CURRENT_TIMESTAMP), ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (instance_id) DO UPDATE SET
+		public_ip = excluded.public_ip,
+		public_dns = excluded.public_dns,
+		name = excluded.name,
+		server_hostname = excluded.server_hostname,
+		query_method = excluded.query_method,
+		region = excluded.region,
+		display_name = excluded.display_name,
+		flag = excluded.flag,
+		game_port = excluded.game_port,
+		sort_order = excluded.sort_order,
+		hidden = excluded.hidden;`
 
 	statement, err := db.Prepare(writeServerSQL)
 	if err != nil {
@@ -89,6 +99,13 @@ func WriteServerToDB(server *models.Server) error {
 	}
 	defer statement.Close()
 
+	if server.QueryMethod == "" {
+		server.QueryMethod = QueryRCON
+	}
+	if server.GamePort == 0 {
+		server.GamePort = DefaultGamePort
+	}
+
 	_, err = statement.Exec(
 		server.InstanceID,
 		server.PublicIP,
@@ -99,13 +116,126 @@ func WriteServerToDB(server *models.Server) error {
 		server.Players,
 		server.MaxPlayers,
 		server.CreatedAt,
+		server.QueryMethod,
+		server.Region,
+		server.DisplayName,
+		server.Flag,
+		server.GamePort,
+		server.SortOrder,
+		server.Hidden,
 	)
 	if err != nil {
 		log.Printf("Error executing SQL statement: %v", err)
 		return err
 	}
 
-	log.Println("Server record inserted")
+	log.Printf("Server record saved for instance: %s", server.InstanceID)
+	return nil
+}
+
+// GetServer returns the registration of a server by instance ID
+func GetServer(instanceID string) (models.Server, error) {
+	query := `
+	SELECT instance_id, COALESCE(public_ip, ''), COALESCE(public_dns, ''), COALESCE(name, ''),
+		COALESCE(server_hostname, ''), COALESCE(map, ''), COALESCE(players, 0), COALESCE(max_players, 0),
+		COALESCE(created_at, ''), query_method, region, display_name, flag, game_port, sort_order, hidden
+	FROM servers
+	WHERE instance_id = ?;`
+
+	var server models.Server
+	err := db.QueryRow(query, instanceID).Scan(
+		&server.InstanceID,
+		&server.PublicIP,
+		&server.PublicDNS,
+		&server.Name,
+		&server.ServerHostname,
+		&server.Map,
+		&server.Players,
+		&server.MaxPlayers,
+		&server.CreatedAt,
+		&server.QueryMethod,
+		&server.Region,
+		&server.DisplayName,
+		&server.Flag,
+		&server.GamePort,
+		&server.SortOrder,
+		&server.Hidden,
+	)
+	if err == sql.ErrNoRows {
+		return server, ErrServerNotFound
+	}
+	return server, err
+}
+
+// UpdateServer changes the fields of a registered server that are set in update
+func UpdateServer(instanceID string, update *models.ServerUpdate) error {
+	var sets []string
+	var args []any
+	fields := []updateField{
+		fieldOf("public_ip", update.PublicIP),
+		fieldOf("public_dns", update.PublicDNS),
+		fieldOf("name", update.Name),
+		fieldOf("server_hostname", update.ServerHostname),
+		fieldOf("query_method", update.QueryMethod),
+		fieldOf("region", update.Region),
+		fieldOf("display_name", update.DisplayName),
+		fieldOf("flag", update.Flag),
+		fieldOf("game_port", update.GamePort),
+		fieldOf("sort_order", update.SortOrder),
+		fieldOf("hidden", update.Hidden),
+	}
+	for _, field := range fields {
+		if field.set {
+			sets = append(sets, field.column+" = ?")
+			args = append(args, field.value)
+		}
+	}
+
+	if len(sets) == 0 {
+		_, err := GetServer(instanceID)
+		return err
+	}
+
+	args = append(args, instanceID)
+	result, err := db.Exec("UPDATE servers SET "+strings.Join(sets, ", ")+" WHERE instance_id = ?;", args...)
+	if err != nil {
+		log.Printf("Error updating server %s: %v", instanceID, err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrServerNotFound
+	}
+
+	log.Printf("Server record updated for instance: %s", instanceID)
+	return nil
+}
+
+type updateField struct {
+	column string
+	set    bool
+	value  any
+}
+
+func fieldOf[T any](column string, value *T) updateField {
+	if value == nil {
+		return updateField{column: column}
+	}
+	return updateField{column, true, *value}
+}
+
+// DeleteServer removes a server registration. Sessions still open on it are closed by the next
+// UpdateServerInfo, recorded sessions are kept.
+func DeleteServer(instanceID string) error {
+	result, err := db.Exec("DELETE FROM servers WHERE instance_id = ?;", instanceID)
+	if err != nil {
+		log.Printf("Error deleting server %s: %v", instanceID, err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrServerNotFound
+	}
+
+	log.Printf("Server record deleted for instance: %s", instanceID)
 	return nil
 }
 
@@ -137,12 +267,13 @@ func GetServerIPs() ([]string, error) {
 
 func GetServerInfo(ip string) (models.ServerStatus, error) {
 	query := `
-	SELECT public_ip, map, players, max_players, server_hostname
+	SELECT instance_id, public_ip, map, players, max_players, server_hostname
 	FROM servers
 	WHERE public_ip = ?;`
 
 	var serverStatus models.ServerStatus
 	err := db.QueryRow(query, ip).Scan(
+		&serverStatus.InstanceID,
 		&serverStatus.PublicIP,
 		&serverStatus.Map,
 		&serverStatus.Players,
@@ -151,8 +282,7 @@ func GetServerInfo(ip string) (models.ServerStatus, error) {
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			log.Printf("No server found with IP: %s", ip)
-			return serverStatus, nil // TODO: is nil correct here?
+			return serverStatus, ErrServerNotFound
 		}
 		log.Printf("Error querying server info for IP %s: %v", ip, err)
 		return serverStatus, err
@@ -161,10 +291,12 @@ func GetServerInfo(ip string) (models.ServerStatus, error) {
 	return serverStatus, nil
 }
 
-// GetTotalPlayerSessions return the total amount of player sessions
+// GetTotalPlayerSessions return the total amount of player sessions, including erased ones
 func GetTotalPlayerSessions() int {
 	var count int
-	query := "SELECT COUNT(*) FROM player_sessions"
+	query := `
+	SELECT (SELECT COUNT(*) FROM player_sessions) +
+		(SELECT COALESCE(SUM(sessions), 0) FROM player_erasures WHERE mode = 'erase')`
 
 	err := db.QueryRow(query).Scan(&count)
 	if err != nil {
@@ -175,10 +307,12 @@ func GetTotalPlayerSessions() int {
 	return count
 }
 
-// GetTotalTimePlayed return the total time of all player sessions in min
+// GetTotalTimePlayed return the total time of all player sessions in min, including erased ones
 func GetTotalTimePlayed() int {
 	var totalDuration int
-	query := "SELECT SUM(duration) FROM player_sessions"
+	query := `
+	SELECT (SELECT COALESCE(SUM(duration), 0) FROM player_sessions) +
+		(SELECT COALESCE(SUM(duration), 0) FROM player_erasures WHERE mode = 'erase')`
 
 	err := db.QueryRow(query).Scan(&totalDuration)
 	if err != nil {
@@ -201,7 +335,7 @@ func GetLastPlayerTime() int {
 		return 0
 	}
 
-	lastPlayerTimeParsed, err := time.Parse("2006-01-02 15:04:05 -0700 MST", lastPlayerTime)
+	lastPlayerTimeParsed, err := parseTime(lastPlayerTime)
 	if err != nil {
 		log.Printf("Error parsing last player time: %v", err)
 		return 0
@@ -210,175 +344,3 @@ func GetLastPlayerTime() int {
 	timeSinceConnect := time.Since(lastPlayerTimeParsed)
 	return int(timeSinceConnect.Minutes()) - duration/60
 }
-
-// UpdateServerInfo updates the server information and active player connection in the db for each server IP
-func UpdateServerInfo(prevPlayerConnections *map[string]map[string]int64) {
-	ips, err := GetServerIPs()
-	if err != nil {
-		log.Printf("Error updating server info: %v", err)
-		return
-	}
-
-	for _, ip := range ips {
-		rconPass := os.Getenv("RCON_PASSWORD")
-
-		client, err := rcon.Dial(ip+":27015", rconPass)
-		if err != nil {
-			log.Printf("Failed to connect to RCON: %v", err)
-			return
-			// TODO: delete prev sessions, or continue loop, if this fails?
-		}
-		defer client.Close()
-
-		response, err := client.Execute("status")
-		if err != nil {
-			log.Printf("Failed to execute RCON command: %v", err)
-			return
-		}
-
-		// get server status
-		hostname := extract(`hostname:\s*(.+)`, response)
-		gameMap := extract(`map\s*:\s*([^\s]+)`, response)
-		players, maxPlayers := extractPlayers(`players\s*:\s*(\d+)\s*humans.*\((\d+)\s*max\)`, response)
-
-		// Update the server information in the database
-		serverStatus := models.ServerStatus{
-			PublicIP:   ip,
-			Map:        gameMap,
-			Players:    players,
-			MaxPlayers: maxPlayers,
-			Hostname:   hostname,
-		}
-
-		updateServerSQL := `
-		UPDATE servers
-		SET map = ?, players = ?, max_players = ?, server_hostname = ?
-		WHERE public_ip = ?;`
-
-		statement, err := db.Prepare(updateServerSQL)
-		if err != nil {
-			log.Printf("Error preparing SQL statement: %v", err)
-			return
-		}
-		defer statement.Close()
-
-		_, err = statement.Exec(
-			serverStatus.Map,
-			serverStatus.Players,
-			serverStatus.MaxPlayers,
-			serverStatus.Hostname,
-			serverStatus.PublicIP,
-		)
-		if err != nil {
-			log.Printf("Error executing SQL statement: %v", err)
-			return
-		}
-
-		log.Printf("Server info updated for IP: %s", ip)
-
-		// Update active player connections
-		currentPlayerIds := extractUniqueIDs(response)
-		// log.Println("currentPlayerIds", currentPlayerIds)
-
-		// get new ids (ids in current players not in prev ids)
-		for _, currID := range currentPlayerIds {
-			if _, exists := (*prevPlayerConnections)[ip][currID]; !exists {
-				// for newIds, create new entry in prevplayer connections
-				if (*prevPlayerConnections)[ip] == nil {
-					(*prevPlayerConnections)[ip] = make(map[string]int64)
-				}
-				(*prevPlayerConnections)[ip][currID] = time.Now().Unix()
-			}
-		}
-
-		log.Println("prevPlayerConenctions", (*prevPlayerConnections))
-
-		// get disconnedted ids (ids in prev ids not in current players)
-		disconnectedIds := []string{}
-		for prevID := range (*prevPlayerConnections)[ip] {
-			if !contains(currentPlayerIds, prevID) {
-				disconnectedIds = append(disconnectedIds, prevID)
-			}
-		}
-
-		// log.Println("disconnectedIds", disconnectedIds)
-
-		// for disconnectedIds, add player session to the db
-		for _, id := range disconnectedIds {
-			connectTime := time.Unix((*prevPlayerConnections)[ip][id], 0)
-			disconnectTime := time.Now()
-			duration := disconnectTime.Sub(connectTime)
-			newPlayerSession := models.PlayerSession{
-				SteamID:        id,
-				ConnectTime:    connectTime.String(),
-				DisconnectTime: disconnectTime.String(),
-				Duration:       int(duration.Seconds()),
-				PublicIP:       ip,
-			}
-
-			// add newPlayerSession to the player_sessions table
-			insertPlayerSessionSQL := `
-			INSERT INTO player_sessions (
-				steam_id, connect_time, disconnect_time, duration, public_ip
-			) VALUES (?, ?, ?, ?, ?);`
-
-			statement, err := db.Prepare(insertPlayerSessionSQL)
-			if err != nil {
-				log.Printf("Error preparing SQL statement for player session: %v", err)
-				continue
-			}
-			defer statement.Close()
-
-			_, err = statement.Exec(
-				newPlayerSession.SteamID,
-				newPlayerSession.ConnectTime,
-				newPlayerSession.DisconnectTime,
-				newPlayerSession.Duration,
-				newPlayerSession.PublicIP,
-			)
-			if err != nil {
-				log.Printf("Error executing SQL statement for player session: %v", err)
-				continue
-			}
-
-			log.Printf("Player session recorded for SteamID: %s", id)
-
-			// delete entry from prevPlayerConnections
-			delete((*prevPlayerConnections)[ip], id)
-		}
-
-	}
-}
-
-func contains(slice []string, item string) bool {
-	for _, s := range slice {
-		if s == item {
-			return true
-		}
-	}
-	return false
-}
-
-func extract(pattern, response string) string {
-	re := regexp.MustCompile(pattern)
-	match := re.FindStringSubmatch(response)
-	if len(match) > 1 {
-		return strings.TrimSpace(match[1])
-	}
-	return ""
-}
-
-func extractPlayers(pattern, response string) (string, string) {
-	re := regexp.MustCompile(pattern)
-	match := re.FindStringSubmatch(response)
-	if len(match) > 2 {
-		return match[1], match[2]
-	}
-	return string(rune(0)), string(rune(0))
-}
-
-func extractUniqueIDs(response string) []string {
-	re := regexp.MustCompile(`U:1:\d+`)
-	matches := re.FindAllString(response, -1)
-	return matches
-}
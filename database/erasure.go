@@ -0,0 +1,117 @@
+package database
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/sawatkins/tf2dl-servers/models"
+	"github.com/sawatkins/tf2dl-servers/steamid"
+)
+
+// Ways a player's data can be removed
+const (
+	ErasureErase     = "erase"     // sessions are deleted, their totals are kept in the audit record
+	ErasureAnonymize = "anonymize" // sessions are kept under random ids that can't be linked to each other
+)
+
+// ErasePlayer removes everything stored about an account: its sessions, its player record, its
+// sign in and its leaderboard opt out. Sessions are deleted or anonymized depending on mode, and
+// either way the site's totals still count them. The erasure is recorded in player_erasures.
+// A session still in progress is dropped along with the rest, if the player stays on they start
+// a new one.
+func ErasePlayer(id steamid.ID, mode, requestedBy string) (models.Erasure, error) {
+	erasure := models.Erasure{Mode: mode, RequestedBy: requestedBy, ErasedAt: formatTime(time.Now())}
+	key := PlayerKey(id)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return erasure, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow("SELECT COUNT(*), COALESCE(SUM(duration), 0) FROM player_sessions WHERE steam_id = ?;", key).Scan(
+		&erasure.Sessions, &erasure.Duration)
+	if err != nil {
+		return erasure, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM players WHERE steam_id = ?;", key); err != nil {
+		return erasure, err
+	}
+	if _, err := tx.Exec("DELETE FROM users WHERE steam_id = ?;", key); err != nil {
+		return erasure, err
+	}
+	if _, err := tx.Exec("DELETE FROM leaderboard_opt_outs WHERE steam_id = ?;", key); err != nil {
+		return erasure, err
+	}
+	if _, err := tx.Exec("DELETE FROM active_connections WHERE steam_id = ?;", key); err != nil {
+		return erasure, err
+	}
+	sessions := "DELETE FROM player_sessions WHERE steam_id = ?;"
+	if mode == ErasureAnonymize {
+		sessions = "UPDATE player_sessions SET steam_id = 'anonymous:' || lower(hex(randomblob(8))) WHERE steam_id = ?;"
+	}
+	if _, err := tx.Exec(sessions, key); err != nil {
+		return erasure, err
+	}
+
+	// there are few enough accounts to hash every one of them, so the id is only kept keyed with
+	// the privacy secret, and not at all without one
+	playerHash := ""
+	if HashesPlayerIDs() {
+		playerHash = hashPlayerKey("erasure:" + key)
+	}
+	_, err = tx.Exec(`
+	INSERT INTO player_erasures (player_hash, mode, requested_by, sessions, duration, erased_at)
+	VALUES (?, ?, ?, ?, ?, ?);`,
+		playerHash, erasure.Mode, erasure.RequestedBy, erasure.Sessions, erasure.Duration, erasure.ErasedAt)
+	if err != nil {
+		return erasure, err
+	}
+
+	// marked before committing so a poll finishing meanwhile can't write the open session back
+	markErased(key)
+	if err := tx.Commit(); err != nil {
+		unmarkErased(key)
+		return erasure, err
+	}
+	log.Printf("Player data removed (%s, requested by %s): %d sessions", mode, requestedBy, erasure.Sessions)
+	return erasure, nil
+}
+
+var (
+	erasedMu   sync.Mutex
+	erasedKeys = map[string]bool{} // players erased since the tracked connections were last pruned
+)
+
+func markErased(key string) {
+	erasedMu.Lock()
+	defer erasedMu.Unlock()
+	erasedKeys[key] = true
+}
+
+func unmarkErased(key string) {
+	erasedMu.Lock()
+	defer erasedMu.Unlock()
+	delete(erasedKeys, key)
+}
+
+func isErased(key string) bool {
+	erasedMu.Lock()
+	defer erasedMu.Unlock()
+	return erasedKeys[key]
+}
+
+// dropErasedConnections stops tracking the connections of players erased since the last call.
+// It runs on the goroutine that owns connections, before polls that could close them start.
+func dropErasedConnections(connections map[string]map[string]int64) {
+	erasedMu.Lock()
+	defer erasedMu.Unlock()
+	for key := range erasedKeys {
+		for _, players := range connections {
+			delete(players, key)
+		}
+	}
+	clear(erasedKeys)
+}
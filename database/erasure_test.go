@@ -0,0 +1,111 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sawatkins/tf2dl-servers/logs"
+	"github.com/sawatkins/tf2dl-servers/steamid"
+)
+
+// Erased and anonymized sessions leave the player but stay in the site's totals
+func TestErasePlayer(t *testing.T) {
+	InitDB(":memory:")
+	t.Cleanup(Close)
+	t.Cleanup(func() { dropErasedConnections(nil) })
+
+	ExecuteSQL(`
+	INSERT INTO player_sessions (steam_id, connect_time, disconnect_time, duration, public_ip) VALUES
+	('U:1:1', '2024-08-01T17:00:00Z', '2024-08-01T17:30:00Z', 1800, '192.168.1.1'),
+	('U:1:1', '2024-08-02T17:00:00Z', '2024-08-02T18:00:00Z', 3600, '192.168.1.1'),
+	('U:1:2', '2024-08-01T17:00:00Z', '2024-08-01T17:10:00Z', 600, '192.168.1.1'),
+	('U:1:3', '2024-08-01T17:00:00Z', '2024-08-01T17:10:00Z', 600, '192.168.1.1');
+	INSERT INTO players (steam_id, first_seen, last_seen, last_name, total_playtime, session_count) VALUES
+	('U:1:1', '2024-08-01T17:00:00Z', '2024-08-02T18:00:00Z', 'Player One', 5400, 2);
+	INSERT INTO users (steam_id, created_at, last_login) VALUES ('U:1:1', '', '');
+	`)
+
+	erasure, err := ErasePlayer(steamid.FromAccount(1), ErasureErase, "player")
+	if err != nil || erasure.Sessions != 2 || erasure.Duration != 5400 {
+		t.Fatalf("Expected 2 sessions to be erased, got %+v %v", erasure, err)
+	}
+	if _, err := ErasePlayer(steamid.FromAccount(2), ErasureAnonymize, "admin"); err != nil {
+		t.Fatalf("Failed to anonymize player: %v", err)
+	}
+
+	if stats, _ := GetPlayerStats(steamid.FromAccount(1)); stats.Sessions != 0 {
+		t.Errorf("Expected no sessions left, got %+v", stats)
+	}
+	if _, err := GetPlayer(steamid.FromAccount(1)); err != ErrPlayerNotFound {
+		t.Errorf("Expected the player to be removed, got %v", err)
+	}
+	if _, err := GetUser(steamid.FromAccount(1)); err != ErrUserNotFound {
+		t.Errorf("Expected the user to be removed, got %v", err)
+	}
+	var anonymous int
+	db.QueryRow("SELECT COUNT(*) FROM player_sessions WHERE steam_id LIKE 'anonymous:%'").Scan(&anonymous)
+	if anonymous != 1 {
+		t.Errorf("Expected 1 anonymized session, got %d", anonymous)
+	}
+
+	if sessions, minutes := GetTotalPlayerSessions(), GetTotalTimePlayed(); sessions != 4 || minutes != 110 {
+		t.Errorf("Expected totals of 4 sessions and 110 minutes, got %d and %d", sessions, minutes)
+	}
+
+	// without the privacy secret no id is kept, an unkeyed hash of one could be brute forced
+	var audited int
+	db.QueryRow("SELECT COUNT(*) FROM player_erasures WHERE player_hash = ''").Scan(&audited)
+	if audited != 2 {
+		t.Errorf("Expected 2 audit records without an id, got %d", audited)
+	}
+
+	PrivacySecret = []byte("privacy-secret")
+	t.Cleanup(func() { PrivacySecret = nil })
+	if _, err := ErasePlayer(steamid.FromAccount(3), ErasureErase, "admin"); err != nil {
+		t.Fatalf("Failed to erase player: %v", err)
+	}
+	var hash string
+	db.QueryRow("SELECT player_hash FROM player_erasures ORDER BY id DESC LIMIT 1").Scan(&hash)
+	if len(hash) != 64 || hash == PlayerKey(steamid.FromAccount(3)) {
+		t.Errorf("Expected a keyed hash distinct from the stored id, got %q", hash)
+	}
+}
+
+// A player erased while connected doesn't get their open session written when they leave
+func TestEraseConnectedPlayer(t *testing.T) {
+	InitDB(":memory:")
+	t.Cleanup(Close)
+	t.Cleanup(func() { dropErasedConnections(nil) })
+	ExecuteSQL(`INSERT INTO servers (instance_id, public_ip, name) VALUES ('i-1', '192.168.1.1', 'us');`)
+
+	connections := map[string]map[string]int64{}
+	player := logs.Player{Name: "Player One", UserID: 3, SteamID: "[U:1:1]"}
+	connected := time.Now().Add(-time.Hour).Truncate(time.Second)
+	ApplyLogEvent(&connections, logs.Event{Server: "192.168.1.1", Time: connected, Type: logs.EventConnect, Player: player})
+
+	if _, err := ErasePlayer(steamid.FromAccount(1), ErasureErase, "player"); err != nil {
+		t.Fatalf("Failed to erase player: %v", err)
+	}
+	var open int
+	db.QueryRow("SELECT COUNT(*) FROM active_connections").Scan(&open)
+	if open != 0 {
+		t.Errorf("Expected the open connection to be removed, got %d", open)
+	}
+
+	// a poll that started before the erasure can't write the session back either
+	session := newPlayerSession("U:1:1", "192.168.1.1", connected.Unix(), time.Now().Unix(), "")
+	if err := closePlayerSession(&session); err != nil {
+		t.Fatalf("Failed to close session: %v", err)
+	}
+
+	ApplyLogEvent(&connections, logs.Event{Server: "192.168.1.1", Time: time.Now(), Type: logs.EventDisconnect, Player: player})
+	if len(connections["192.168.1.1"]) != 0 {
+		t.Errorf("Expected the connection to be dropped, got %v", connections)
+	}
+	if stats, _ := GetPlayerStats(steamid.FromAccount(1)); stats.Sessions != 0 {
+		t.Errorf("Expected no sessions to be written, got %+v", stats)
+	}
+	if _, err := GetPlayer(steamid.FromAccount(1)); err != ErrPlayerNotFound {
+		t.Errorf("Expected no player record, got %v", err)
+	}
+}
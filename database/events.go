@@ -0,0 +1,140 @@
+package database
+
+import (
+	"log"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/sawatkins/tf2dl-servers/hub"
+	"github.com/sawatkins/tf2dl-servers/models"
+)
+
+// Server-sent event types published on ServerEvents
+const (
+	EventServer        = "server"         // data is a models.ServerState
+	EventServerRemoved = "server-removed" // data is {"public_ip": ...}
+)
+
+// ServerEvents receives an event whenever a poll or log event changes what the server table shows
+var ServerEvents = hub.New()
+
+var (
+	publishedMu sync.Mutex
+	published   = map[string]models.ServerState{} // last state published for each server IP
+)
+
+// GetServerStates returns every listed server as shown in the live server table, in display order
+func GetServerStates() ([]models.ServerState, error) {
+	return queryServerStates("")
+}
+
+func queryServerStates(ip string) ([]models.ServerState, error) {
+	rows, err := db.Query(`
+	SELECT instance_id, COALESCE(public_ip, ''), COALESCE(NULLIF(server_hostname, ''), name, ''),
+		region, display_name, flag, game_port, sort_order,
+		COALESCE(map, ''), COALESCE(players, 0), COALESCE(max_players, 0), version
+	FROM servers
+	WHERE hidden = 0 AND (? = '' OR public_ip = ?)
+	ORDER BY sort_order, public_ip DESC;`, ip, ip)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var states []models.ServerState
+	for rows.Next() {
+		var s models.ServerState
+		err := rows.Scan(&s.InstanceID, &s.PublicIP, &s.Hostname,
+			&s.Region, &s.DisplayName, &s.Flag, &s.GamePort, &s.SortOrder,
+			&s.Map, &s.Players, &s.MaxPlayers, &s.Version)
+		if err != nil {
+			return nil, err
+		}
+		s.DisplayName, s.Flag = DisplayDefaults(s.Region, s.DisplayName, s.Flag)
+		s.Connect = ConnectAddress(s.PublicIP, s.GamePort)
+		states = append(states, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	newest, err := newestVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	pollStatusMu.Lock()
+	defer pollStatusMu.Unlock()
+	for i := range states {
+		status, ok := pollStatuses[states[i].PublicIP]
+		states[i].Online = ok && status.ConsecutiveFailures == 0
+		states[i].Outdated = isOutdated(states[i].PublicIP, states[i].Version, newest)
+	}
+
+	return states, nil
+}
+
+// DisplayDefaults fills in the display name and flag of a server registered without them
+func DisplayDefaults(region, displayName, flag string) (string, string) {
+	if displayName == "" {
+		displayName = region
+	}
+	if flag == "" && region != "" {
+		flag = "/img/" + region + ".svg"
+	}
+	return displayName, flag
+}
+
+// ConnectAddress returns the address players connect to a server on
+func ConnectAddress(ip string, port int) string {
+	return net.JoinHostPort(ip, strconv.Itoa(port))
+}
+
+// publishServerState publishes the state of the server at ip if it changed since last published,
+// or its removal if it has been hidden
+func publishServerState(ip string) {
+	states, err := queryServerStates(ip)
+	if err != nil {
+		log.Printf("Error reading state of server %s: %v", ip, err)
+		return
+	}
+
+	publishedMu.Lock()
+	defer publishedMu.Unlock()
+	if len(states) == 0 {
+		if _, ok := published[ip]; ok {
+			delete(published, ip)
+			publish(EventServerRemoved, map[string]string{"public_ip": ip})
+		}
+		return
+	}
+	for _, state := range states {
+		if last, ok := published[ip]; ok && last == state {
+			continue
+		}
+		published[ip] = state
+		publish(EventServer, state)
+	}
+}
+
+// publishRemovedServers publishes a removal for every server published before that isn't in registered
+func publishRemovedServers(registered map[string]string) {
+	publishedMu.Lock()
+	defer publishedMu.Unlock()
+	for ip := range published {
+		if _, ok := registered[ip]; ok {
+			continue
+		}
+		delete(published, ip)
+		publish(EventServerRemoved, map[string]string{"public_ip": ip})
+	}
+}
+
+func publish(event string, data any) {
+	msg, err := hub.Event(event, data)
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", event, err)
+		return
+	}
+	ServerEvents.Publish(msg)
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/sawatkins/tf2dl-servers/models"
+)
+
+// FeedStore keeps the RSS checker's state in the database
+type FeedStore struct{}
+
+func (FeedStore) FeedState(url string) (models.FeedState, error) {
+	return GetFeedState(url)
+}
+
+func (FeedStore) SaveFeedState(state models.FeedState) error {
+	return SaveFeedState(state)
+}
+
+// GetFeedState returns what was last read from the feed at url, with only the URL set
+// if it hasn't been read before
+func GetFeedState(url string) (models.FeedState, error) {
+	state := models.FeedState{URL: url}
+	err := db.QueryRow(`
+	SELECT etag, last_modified, last_guid, last_published, checked_at
+	FROM feed_state WHERE url = ?;`, url).Scan(
+		&state.ETag, &state.LastModified, &state.LastGUID, &state.LastPublished, &state.CheckedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return state, nil
+	}
+	if err != nil {
+		log.Printf("Error querying feed state for %s: %v", url, err)
+		return state, err
+	}
+	return state, nil
+}
+
+func SaveFeedState(state models.FeedState) error {
+	_, err := db.Exec(`
+	INSERT INTO feed_state (url, etag, last_modified, last_guid, last_published, checked_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT(url) DO UPDATE SET
+		etag = excluded.etag,
+		last_modified = excluded.last_modified,
+		last_guid = excluded.last_guid,
+		last_published = excluded.last_published,
+		checked_at = excluded.checked_at;`,
+		state.URL, state.ETag, state.LastModified, state.LastGUID, state.LastPublished, state.CheckedAt)
+	if err != nil {
+		log.Printf("Error saving feed state for %s: %v", state.URL, err)
+	}
+	return err
+}
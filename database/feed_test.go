@@ -0,0 +1,29 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/sawatkins/tf2dl-servers/models"
+)
+
+// A feed's state reads back as saved, and an unknown feed has only its URL
+func TestFeedStore(t *testing.T) {
+	InitDB(":memory:")
+	t.Cleanup(Close)
+
+	var store FeedStore
+	url := "https://www.teamfortress.com/rss.xml"
+	if state, err := store.FeedState(url); err != nil || state != (models.FeedState{URL: url}) {
+		t.Errorf("Expected an empty state, got %+v %v", state, err)
+	}
+
+	saved := models.FeedState{URL: url, ETag: `"new"`, LastGUID: "https://www.teamfortress.com/post.php?id=5", LastPublished: 1760745600, CheckedAt: 1760749200}
+	if err := store.SaveFeedState(saved); err != nil {
+		t.Fatalf("Failed to save feed state: %v", err)
+	}
+	saved.ETag = `"newer"`
+	store.SaveFeedState(saved)
+	if state, err := store.FeedState(url); err != nil || state != saved {
+		t.Errorf("Expected %+v, got %+v %v", saved, state, err)
+	}
+}
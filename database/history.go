@@ -0,0 +1,88 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/sawatkins/tf2dl-servers/models"
+)
+
+// recordMapChange stores the map a server is running if it differs from the last one recorded
+func recordMapChange(ip, gameMap string, at time.Time) error {
+	if gameMap == "" {
+		return nil
+	}
+
+	var lastMap string
+	err := db.QueryRow("SELECT map FROM map_changes WHERE public_ip = ? ORDER BY started_at DESC, id DESC LIMIT 1", ip).Scan(&lastMap)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if lastMap == gameMap {
+		return nil
+	}
+
+	_, err = db.Exec("INSERT INTO map_changes (public_ip, map, started_at) VALUES (?, ?, ?);", ip, gameMap, formatTime(at))
+	return err
+}
+
+// GetRecentMaps returns the last maps a server changed to, newest first
+func GetRecentMaps(ip string, limit int) ([]models.MapChange, error) {
+	rows, err := db.Query(`
+	SELECT public_ip, map, started_at
+	FROM map_changes
+	WHERE public_ip = ?
+	ORDER BY started_at DESC, id DESC
+	LIMIT ?;`, ip, limit)
+	if err != nil {
+		log.Printf("Error querying recent maps for IP %s: %v", ip, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var maps []models.MapChange
+	for rows.Next() {
+		var change models.MapChange
+		if err := rows.Scan(&change.PublicIP, &change.Map, &change.StartedAt); err != nil {
+			return nil, err
+		}
+		maps = append(maps, change)
+	}
+
+	return maps, rows.Err()
+}
+
+// GetRecentSessions returns the last finished player sessions on a server, newest first
+func GetRecentSessions(ip string, limit int) ([]models.PlayerSession, error) {
+	rows, err := db.Query(`
+	SELECT steam_id, connect_time, COALESCE(disconnect_time, ''), COALESCE(duration, 0), public_ip, COALESCE(end_reason, '')
+	FROM player_sessions
+	WHERE public_ip = ?
+	ORDER BY connect_time DESC
+	LIMIT ?;`, ip, limit)
+	if err != nil {
+		log.Printf("Error querying recent sessions for IP %s: %v", ip, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.PlayerSession
+	for rows.Next() {
+		var session models.PlayerSession
+		err := rows.Scan(
+			&session.SteamID,
+			&session.ConnectTime,
+			&session.DisconnectTime,
+			&session.Duration,
+			&session.PublicIP,
+			&session.EndReason,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
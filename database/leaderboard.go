@@ -0,0 +1,103 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sawatkins/tf2dl-servers/models"
+	"github.com/sawatkins/tf2dl-servers/steamid"
+)
+
+// Leaderboard metrics
+const (
+	MetricPlaytime       = "playtime"        // seconds played
+	MetricSessions       = "sessions"        // sessions played
+	MetricLongestSession = "longest_session" // seconds of the longest session
+	MetricStreak         = "streak"          // most consecutive UTC days with a session
+)
+
+// leaderboards select each player's value for a metric from the sessions being ranked
+var leaderboards = map[string]string{
+	MetricPlaytime:       "SELECT steam_id, SUM(COALESCE(duration, 0)) AS value FROM sessions GROUP BY steam_id",
+	MetricSessions:       "SELECT steam_id, COUNT(*) AS value FROM sessions GROUP BY steam_id",
+	MetricLongestSession: "SELECT steam_id, MAX(COALESCE(duration, 0)) AS value FROM sessions GROUP BY steam_id",
+	// a day's julian day minus its row number is the same for every day in a run of consecutive days
+	MetricStreak: `SELECT steam_id, MAX(days) AS value FROM (
+		SELECT steam_id, COUNT(*) AS days FROM (
+			SELECT steam_id, julianday(day) - ROW_NUMBER() OVER (PARTITION BY steam_id ORDER BY day) AS run
+			FROM (SELECT DISTINCT steam_id, date(connect_time) AS day FROM sessions)
+		) GROUP BY steam_id, run
+	) GROUP BY steam_id`,
+}
+
+// GetLeaderboard ranks players by metric over the sessions started since since, on the server at ip.
+// A zero since counts every session and an empty ip every server. Anonymized sessions and players
+// who opted out aren't ranked.
+func GetLeaderboard(metric, ip string, since time.Time, limit int) ([]models.LeaderboardEntry, error) {
+	ranked, ok := leaderboards[metric]
+	if !ok {
+		return nil, fmt.Errorf("unknown leaderboard metric %q", metric)
+	}
+	from := "" // sorts before every time
+	if !since.IsZero() {
+		from = formatTime(since)
+	}
+
+	rows, err := db.Query(`
+	WITH sessions AS (
+		SELECT steam_id, connect_time, duration FROM player_sessions
+		WHERE (?1 = '' OR public_ip = ?1) AND connect_time >= ?2
+			AND steam_id NOT LIKE 'anonymous:%'
+			AND steam_id NOT IN (SELECT steam_id FROM leaderboard_opt_outs)
+	),
+	ranked AS (`+ranked+`)
+	SELECT r.steam_id, COALESCE(p.last_name, ''), r.value
+	FROM ranked r LEFT JOIN players p ON p.steam_id = r.steam_id
+	WHERE r.value > 0
+	ORDER BY r.value DESC, r.steam_id
+	LIMIT ?3;`, ip, from, limit)
+	if err != nil {
+		log.Printf("Error querying %s leaderboard: %v", metric, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.LeaderboardEntry
+	for rows.Next() {
+		var key string
+		entry := models.LeaderboardEntry{Rank: len(entries) + 1}
+		if err := rows.Scan(&key, &entry.Name, &entry.Value); err != nil {
+			return nil, err
+		}
+		// hashed ids can't be turned back into Steam ids
+		if id, err := steamid.Parse(key); err == nil && !HashesPlayerIDs() {
+			entry.SteamID64 = id.SteamID64()
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// SetLeaderboardOptOut hides an account from the leaderboards, or shows it again
+func SetLeaderboardOptOut(id steamid.ID, optOut bool) error {
+	var err error
+	if optOut {
+		_, err = db.Exec("INSERT OR IGNORE INTO leaderboard_opt_outs (steam_id, opted_out_at) VALUES (?, ?);",
+			PlayerKey(id), formatTime(time.Now()))
+	} else {
+		_, err = db.Exec("DELETE FROM leaderboard_opt_outs WHERE steam_id = ?;", PlayerKey(id))
+	}
+	if err != nil {
+		log.Printf("Error updating leaderboard opt out for %d: %v", id, err)
+	}
+	return err
+}
+
+// LeaderboardOptedOut reports whether an account is hidden from the leaderboards
+func LeaderboardOptedOut(id steamid.ID) (bool, error) {
+	var optedOut bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM leaderboard_opt_outs WHERE steam_id = ?);", PlayerKey(id)).Scan(&optedOut)
+	return optedOut, err
+}
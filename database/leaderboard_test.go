@@ -0,0 +1,88 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sawatkins/tf2dl-servers/steamid"
+)
+
+func TestGetLeaderboard(t *testing.T) {
+	InitDB(":memory:")
+	t.Cleanup(Close)
+
+	now := time.Now().UTC().Truncate(24 * time.Hour).Add(12 * time.Hour)
+	day := func(days int) string { return formatTime(now.AddDate(0, 0, -days)) }
+	insertSession := func(steamID string, days, duration int, ip string) {
+		_, err := db.Exec("INSERT INTO player_sessions (steam_id, connect_time, disconnect_time, duration, public_ip) VALUES (?, ?, ?, ?, ?);",
+			steamID, day(days), day(days), duration, ip)
+		if err != nil {
+			t.Fatalf("Failed to insert session: %v", err)
+		}
+	}
+	// U:1:1 plays a lot on us a long time ago, U:1:2 a little on both every day this week
+	insertSession("U:1:1", 100, 36000, "192.168.1.1")
+	insertSession("U:1:1", 99, 600, "192.168.1.1")
+	for days := 1; days <= 4; days++ {
+		insertSession("U:1:2", days, 1200, "192.168.1.2")
+	}
+	insertSession("U:1:2", 6, 1200, "192.168.1.1")
+	insertSession("U:1:3", 2, 60, "192.168.1.1")
+	insertSession("anonymous:0123456789abcdef", 1, 99999, "192.168.1.1")
+	ExecuteSQL(`INSERT INTO players (steam_id, first_seen, last_seen, last_name) VALUES ('U:1:2', '', '', 'Player Two');`)
+
+	type ranking struct {
+		account uint32
+		value   int
+	}
+	for _, tc := range []struct {
+		name   string
+		metric string
+		ip     string
+		since  time.Time
+		want   []ranking
+	}{
+		{"playtime", MetricPlaytime, "", time.Time{}, []ranking{{1, 36600}, {2, 6000}, {3, 60}}},
+		{"playtime this week", MetricPlaytime, "", now.AddDate(0, 0, -7), []ranking{{2, 6000}, {3, 60}}},
+		{"sessions on us", MetricSessions, "192.168.1.1", time.Time{}, []ranking{{1, 2}, {2, 1}, {3, 1}}},
+		{"longest session", MetricLongestSession, "", time.Time{}, []ranking{{1, 36000}, {2, 1200}, {3, 60}}},
+		{"streak", MetricStreak, "", time.Time{}, []ranking{{2, 4}, {1, 2}, {3, 1}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			entries, err := GetLeaderboard(tc.metric, tc.ip, tc.since, 10)
+			if err != nil {
+				t.Fatalf("Failed to get leaderboard: %v", err)
+			}
+			if len(entries) != len(tc.want) {
+				t.Fatalf("Expected %d entries, got %+v", len(tc.want), entries)
+			}
+			for i, want := range tc.want {
+				entry := entries[i]
+				if entry.Rank != i+1 || entry.SteamID64 != steamid.FromAccount(want.account).SteamID64() || entry.Value != want.value {
+					t.Errorf("Expected U:1:%d with %d at rank %d, got %+v", want.account, want.value, i+1, entry)
+				}
+			}
+		})
+	}
+
+	if entries, _ := GetLeaderboard(MetricPlaytime, "", time.Time{}, 10); entries[1].Name != "Player Two" {
+		t.Errorf("Expected the player's name, got %+v", entries[1])
+	}
+	if _, err := GetLeaderboard("kills", "", time.Time{}, 10); err == nil {
+		t.Errorf("Expected an unknown metric to fail")
+	}
+
+	if err := SetLeaderboardOptOut(steamid.FromAccount(1), true); err != nil {
+		t.Fatalf("Failed to opt out: %v", err)
+	}
+	if optedOut, err := LeaderboardOptedOut(steamid.FromAccount(1)); err != nil || !optedOut {
+		t.Errorf("Expected the player to be opted out, got %v %v", optedOut, err)
+	}
+	if entries, _ := GetLeaderboard(MetricPlaytime, "", time.Time{}, 10); len(entries) != 2 || entries[0].SteamID64 != steamid.FromAccount(2).SteamID64() {
+		t.Errorf("Expected the opted out player to be left out, got %+v", entries)
+	}
+	SetLeaderboardOptOut(steamid.FromAccount(1), false)
+	if entries, _ := GetLeaderboard(MetricPlaytime, "", time.Time{}, 1); len(entries) != 1 || entries[0].SteamID64 != steamid.FromAccount(1).SteamID64() {
+		t.Errorf("Expected the player to be shown again, got %+v", entries)
+	}
+}
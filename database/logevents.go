@@ -0,0 +1,91 @@
+package database
+
+import (
+	"log"
+
+	"github.com/sawatkins/tf2dl-servers/logs"
+	"github.com/sawatkins/tf2dl-servers/metrics"
+	"github.com/sawatkins/tf2dl-servers/steamid"
+)
+
+// ApplyLogEvent updates player connections and server info from a game server log event.
+// Log events carry the exact time players joined and left, so when the log listener is enabled
+// it opens and closes sessions as they happen; UpdateServerInfo then only catches up on
+// players whose log lines were lost.
+func ApplyLogEvent(prevPlayerConnections *map[string]map[string]int64, event logs.Event) {
+	ip := event.Server
+	if !serverExists(ip) {
+		log.Printf("Ignoring log event from unknown server %s", ip)
+		return
+	}
+	dropErasedConnections(*prevPlayerConnections)
+
+	// bots, the console and ids Steam hasn't confirmed yet can't be tracked
+	switch event.Type {
+	case logs.EventConnect, logs.EventEnter:
+		steamID, err := steamid.Parse(event.Player.SteamID)
+		if err != nil {
+			return
+		}
+		id := PlayerKey(steamID)
+		connectTime := event.Time.Unix()
+
+		connections := (*prevPlayerConnections)[ip]
+		if connections == nil {
+			connections = make(map[string]int64)
+			(*prevPlayerConnections)[ip] = connections
+		}
+		// the poller may have seen the player first, the log has the more accurate time
+		existing, ok := connections[id]
+		if ok && existing <= connectTime {
+			return
+		}
+
+		if err := openConnection(ip, id, event.Player.Name, connectTime); err != nil {
+			log.Printf("Error recording connection for SteamID %s: %v", id, err)
+		}
+		if !ok {
+			metrics.SessionsStarted.WithLabelValues(ip).Inc()
+		}
+		connections[id] = connectTime
+
+	case logs.EventDisconnect:
+		steamID, err := steamid.Parse(event.Player.SteamID)
+		if err != nil {
+			return
+		}
+		id := PlayerKey(steamID)
+
+		connectTime, ok := (*prevPlayerConnections)[ip][id]
+		if !ok {
+			return
+		}
+		disconnectTime := max(event.Time.Unix(), connectTime)
+
+		session := newPlayerSession(id, ip, connectTime, disconnectTime, "")
+		if err := closePlayerSession(&session); err != nil {
+			log.Printf("Error executing SQL statement for player session: %v", err)
+			return
+		}
+		log.Printf("Player session recorded for SteamID: %s", id)
+		delete((*prevPlayerConnections)[ip], id)
+
+	case logs.EventMapChange:
+		if _, err := db.Exec("UPDATE servers SET map = ? WHERE public_ip = ?;", event.Map, ip); err != nil {
+			log.Printf("Error updating map for IP %s: %v", ip, err)
+		}
+		if err := recordMapChange(ip, event.Map, event.Time); err != nil {
+			log.Printf("Error recording map change for IP %s: %v", ip, err)
+		}
+		publishServerState(ip)
+	}
+}
+
+func serverExists(ip string) bool {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM servers WHERE public_ip = ?", ip).Scan(&count); err != nil {
+		log.Printf("Error querying server %s: %v", ip, err)
+		return false
+	}
+	return count > 0
+}
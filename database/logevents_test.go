@@ -0,0 +1,54 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sawatkins/tf2dl-servers/logs"
+	"github.com/sawatkins/tf2dl-servers/steamid"
+)
+
+// A visit shorter than the poll interval is recorded with its exact length
+func TestApplyLogEvent(t *testing.T) {
+	InitDB(":memory:")
+	t.Cleanup(Close)
+
+	ExecuteSQL(`INSERT INTO servers (instance_id, public_ip, name, server_hostname, map, players, max_players) VALUES ('i-1', '192.168.1.1', 'us', 'us', 'surf_utopia_v3', 0, 24)`)
+
+	connected := time.Now().Add(-time.Minute).Truncate(time.Second)
+	player := logs.Player{Name: "Player One", UserID: 3, SteamID: "[U:1:12345678]"}
+	connections := map[string]map[string]int64{}
+
+	ApplyLogEvent(&connections, logs.Event{Server: "192.168.1.1", Time: connected, Type: logs.EventConnect, Player: player})
+	if got := connections["192.168.1.1"]["U:1:12345678"]; got != connected.Unix() {
+		t.Errorf("Expected connect time %d, got %d", connected.Unix(), got)
+	}
+
+	ApplyLogEvent(&connections, logs.Event{Server: "192.168.1.1", Time: connected.Add(12 * time.Second), Type: logs.EventDisconnect, Player: player})
+	if _, ok := connections["192.168.1.1"]["U:1:12345678"]; ok {
+		t.Errorf("Expected connection to be closed, got %v", connections)
+	}
+
+	var duration int
+	if err := db.QueryRow("SELECT duration FROM player_sessions WHERE steam_id = 'U:1:12345678'").Scan(&duration); err != nil {
+		t.Fatalf("Failed to read session: %v", err)
+	}
+	if duration != 12 {
+		t.Errorf("Expected 12 second session, got %d", duration)
+	}
+	recorded, err := GetPlayer(steamid.FromAccount(12345678))
+	if err != nil || recorded.SessionCount != 1 || recorded.TotalPlaytime != 12 || recorded.LastName != "Player One" {
+		t.Errorf("Expected the session to be added to the player, got %+v %v", recorded, err)
+	}
+
+	ApplyLogEvent(&connections, logs.Event{Server: "192.168.1.1", Time: connected, Type: logs.EventMapChange, Map: "surf_kitsune"})
+	if info, err := GetServerInfo("192.168.1.1"); err != nil || info.Map != "surf_kitsune" {
+		t.Errorf("Expected map surf_kitsune, got %q", info.Map)
+	}
+
+	// events from servers that aren't registered are ignored
+	ApplyLogEvent(&connections, logs.Event{Server: "10.0.0.1", Time: connected, Type: logs.EventConnect, Player: player})
+	if _, ok := connections["10.0.0.1"]; ok {
+		t.Errorf("Expected event from unknown server to be ignored")
+	}
+}
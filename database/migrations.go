@@ -0,0 +1,357 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// migration moves the schema up one version. Migrations run in order inside a transaction
+// and are recorded in schema_version, so each runs exactly once per database.
+// Append new migrations to the end of the list, never edit one that has shipped.
+type migration struct {
+	version     int
+	description string
+	up          func(tx *sql.Tx) error
+}
+
+var migrations = []migration{
+	{1, "create base tables", createBaseTables},
+	{2, "widen address columns", widenAddressColumns},
+	{3, "store session timestamps as UTC ISO-8601", convertSessionTimestamps},
+	{4, "index player sessions", execMigration(`
+		CREATE INDEX IF NOT EXISTS idx_player_sessions_steam_id ON player_sessions (steam_id);
+		CREATE INDEX IF NOT EXISTS idx_player_sessions_public_ip ON player_sessions (public_ip);
+		CREATE INDEX IF NOT EXISTS idx_player_sessions_connect_time ON player_sessions (connect_time);`)},
+	{5, "create map_changes", execMigration(`
+		CREATE TABLE map_changes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			public_ip VARCHAR(45) NOT NULL,
+			map VARCHAR(50) NOT NULL,
+			started_at TEXT NOT NULL
+		);
+		CREATE INDEX idx_map_changes_public_ip_started_at ON map_changes (public_ip, started_at);`)},
+	{6, "create server_snapshots", execMigration(`
+		CREATE TABLE server_snapshots (
+			public_ip VARCHAR(45) NOT NULL,
+			resolution INTEGER NOT NULL,
+			taken_at INTEGER NOT NULL,
+			map VARCHAR(50) NOT NULL DEFAULT '',
+			players REAL NOT NULL,
+			peak_players INTEGER NOT NULL,
+			max_players INTEGER NOT NULL,
+			bots REAL NOT NULL,
+			reachable REAL NOT NULL,
+			PRIMARY KEY (public_ip, resolution, taken_at)
+		);
+		CREATE INDEX idx_server_snapshots_resolution_taken_at ON server_snapshots (resolution, taken_at);`)},
+	{7, "add server display metadata", execMigration(`
+		ALTER TABLE servers ADD COLUMN region VARCHAR(32) NOT NULL DEFAULT '';
+		ALTER TABLE servers ADD COLUMN display_name VARCHAR(100) NOT NULL DEFAULT '';
+		ALTER TABLE servers ADD COLUMN flag VARCHAR(255) NOT NULL DEFAULT '';
+		ALTER TABLE servers ADD COLUMN game_port INTEGER NOT NULL DEFAULT 27015;
+		ALTER TABLE servers ADD COLUMN sort_order INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE servers ADD COLUMN hidden INTEGER NOT NULL DEFAULT 0;
+		-- servers registered by manage.py before it sent a region
+		UPDATE servers SET region = 'us-west', sort_order = 1 WHERE name = 'tf2_server_us';
+		UPDATE servers SET region = 'eu-central', sort_order = 2 WHERE name = 'tf2_server_eu';`)},
+	{8, "track game versions", execMigration(`
+		ALTER TABLE servers ADD COLUMN version INTEGER NOT NULL DEFAULT 0;
+		CREATE TABLE game_versions (
+			version INTEGER PRIMARY KEY,
+			first_seen TEXT NOT NULL,
+			first_server VARCHAR(45) NOT NULL
+		);`)},
+	{9, "persist rss feed state", execMigration(`
+		CREATE TABLE feed_state (
+			url TEXT PRIMARY KEY,
+			etag TEXT NOT NULL DEFAULT '',
+			last_modified TEXT NOT NULL DEFAULT '',
+			last_guid TEXT NOT NULL DEFAULT '',
+			last_published INTEGER NOT NULL DEFAULT 0,
+			checked_at INTEGER NOT NULL DEFAULT 0
+		);`)},
+	{10, "create users", execMigration(`
+		CREATE TABLE users (
+			steam_id TEXT PRIMARY KEY, -- stored like player_sessions.steam_id, hashed in privacy mode
+			created_at TEXT NOT NULL,
+			last_login TEXT NOT NULL
+		);`)},
+	{11, "create players", execMigration(`
+		CREATE TABLE players (
+			steam_id TEXT PRIMARY KEY,
+			first_seen TEXT NOT NULL,
+			last_seen TEXT NOT NULL,
+			last_name TEXT NOT NULL DEFAULT '',
+			total_playtime INTEGER NOT NULL DEFAULT 0,
+			session_count INTEGER NOT NULL DEFAULT 0
+		);
+		INSERT INTO players (steam_id, first_seen, last_seen, total_playtime, session_count)
+		SELECT steam_id, MIN(connect_time), MAX(COALESCE(disconnect_time, connect_time)),
+			COALESCE(SUM(duration), 0), COUNT(*)
+		FROM player_sessions
+		WHERE steam_id GLOB 'U:1:[1-9]*'
+		GROUP BY steam_id;`)},
+	{12, "create player_erasures", execMigration(`
+		CREATE TABLE player_erasures (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			player_hash TEXT NOT NULL, -- HMAC of the id in privacy mode, empty otherwise
+			mode TEXT NOT NULL,
+			requested_by TEXT NOT NULL,
+			sessions INTEGER NOT NULL,
+			duration INTEGER NOT NULL,
+			erased_at TEXT NOT NULL
+		);`)},
+	{13, "create leaderboard_opt_outs", execMigration(`
+		CREATE TABLE leaderboard_opt_outs (
+			steam_id TEXT PRIMARY KEY,
+			opted_out_at TEXT NOT NULL
+		);`)},
+}
+
+// migrate brings the schema up to the latest version
+func migrate() error {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+
+	current, err := SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := runMigration(m); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+		}
+		log.Printf("Applied migration %d: %s", m.version, m.description)
+	}
+
+	return nil
+}
+
+// SchemaVersion returns the version of the newest migration applied to the database
+func SchemaVersion() (int, error) {
+	var version int
+	err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+func runMigration(m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO schema_version (version, description, applied_at) VALUES (?, ?, ?);",
+		m.version, m.description, formatTime(time.Now()))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func execMigration(statements string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(statements)
+		return err
+	}
+}
+
+// createBaseTables creates the tables as they were before migrations existed. Databases from
+// that time already have them, possibly without the columns added later.
+func createBaseTables(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS servers (
+		instance_id VARCHAR(20) PRIMARY KEY,
+		public_ip CHAR(15),
+		public_dns VARCHAR(100),
+		name VARCHAR(50),
+		server_hostname VARCHAR(100),
+		map VARCHAR(50),
+		players INTEGER,
+		max_players INTEGER,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS player_sessions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		steam_id TEXT NOT NULL,
+		connect_time TEXT NOT NULL,
+		disconnect_time TEXT,
+		duration INTEGER,
+		public_ip CHAR(15)
+	);
+
+	CREATE TABLE IF NOT EXISTS active_connections (
+		steam_id TEXT NOT NULL,
+		public_ip CHAR(15) NOT NULL,
+		connect_time INTEGER NOT NULL,
+		last_seen INTEGER NOT NULL,
+		PRIMARY KEY (public_ip, steam_id)
+	);`)
+	if err != nil {
+		return err
+	}
+
+	columns := []struct{ table, column, definition string }{
+		{"servers", "query_method", "VARCHAR(10) NOT NULL DEFAULT 'rcon'"},
+		{"player_sessions", "end_reason", "TEXT"},
+		// closed_session_id points at the session written for this connection on shutdown
+		{"active_connections", "closed_session_id", "INTEGER"},
+	}
+	for _, c := range columns {
+		if err := addColumnIfMissing(tx, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	var count int
+	err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+	_, err = tx.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+// widenAddressColumns rebuilds the tables holding IPs so they fit IPv6 addresses.
+// SQLite doesn't enforce CHAR lengths, but the declared schema should match what's stored.
+func widenAddressColumns(tx *sql.Tx) error {
+	tables := []struct{ name, schema, columns string }{
+		{"servers", `
+		instance_id VARCHAR(20) PRIMARY KEY,
+		public_ip VARCHAR(45),
+		public_dns VARCHAR(255),
+		name VARCHAR(50),
+		server_hostname VARCHAR(100),
+		map VARCHAR(50),
+		players INTEGER,
+		max_players INTEGER,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		query_method VARCHAR(10) NOT NULL DEFAULT 'rcon'`,
+			"instance_id, public_ip, public_dns, name, server_hostname, map, players, max_players, created_at, query_method"},
+		{"player_sessions", `
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		steam_id TEXT NOT NULL,
+		connect_time TEXT NOT NULL,
+		disconnect_time TEXT,
+		duration INTEGER,
+		public_ip VARCHAR(45),
+		end_reason TEXT`,
+			"id, steam_id, connect_time, disconnect_time, duration, public_ip, end_reason"},
+		{"active_connections", `
+		steam_id TEXT NOT NULL,
+		public_ip VARCHAR(45) NOT NULL,
+		connect_time INTEGER NOT NULL,
+		last_seen INTEGER NOT NULL,
+		closed_session_id INTEGER,
+		PRIMARY KEY (public_ip, steam_id)`,
+			"steam_id, public_ip, connect_time, last_seen, closed_session_id"},
+	}
+
+	for _, t := range tables {
+		statements := []string{
+			fmt.Sprintf("CREATE TABLE %s_new (%s\n\t);", t.name, t.schema),
+			fmt.Sprintf("INSERT INTO %s_new (%s) SELECT %s FROM %s;", t.name, t.columns, t.columns, t.name),
+			fmt.Sprintf("DROP TABLE %s;", t.name),
+			fmt.Sprintf("ALTER TABLE %s_new RENAME TO %s;", t.name, t.name),
+		}
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// convertSessionTimestamps rewrites session times stored with time.Time.String(),
+// e.g. "2024-08-01 17:03:12.123 -0700 PDT m=+41.2", as UTC ISO-8601
+func convertSessionTimestamps(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT id, connect_time, COALESCE(disconnect_time, '') FROM player_sessions")
+	if err != nil {
+		return err
+	}
+
+	type converted struct {
+		id                          int64
+		connectTime, disconnectTime string
+	}
+	var updates []converted
+	for rows.Next() {
+		var c converted
+		if err := rows.Scan(&c.id, &c.connectTime, &c.disconnectTime); err != nil {
+			rows.Close()
+			return err
+		}
+		c.connectTime = convertLegacyTime(c.connectTime)
+		c.disconnectTime = convertLegacyTime(c.disconnectTime)
+		updates = append(updates, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range updates {
+		_, err := tx.Exec("UPDATE player_sessions SET connect_time = ?, disconnect_time = NULLIF(?, '') WHERE id = ?;",
+			c.connectTime, c.disconnectTime, c.id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// convertLegacyTime converts a time.Time.String() value, returning anything it can't parse unchanged
+func convertLegacyTime(value string) string {
+	if value == "" {
+		return value
+	}
+
+	// drop the monotonic clock reading time.Now() adds
+	if i := strings.Index(value, " m="); i >= 0 {
+		value = value[:i]
+	}
+
+	t, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", value)
+	if err != nil {
+		log.Printf("Leaving unrecognised session time %q as is", value)
+		return value
+	}
+	return formatTime(t)
+}
+
+// formatTime formats a time the way it is stored in the database
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// parseTime parses a time stored by formatTime
+func parseTime(value string) (time.Time, error) {
+	return time.Parse(time.RFC3339, value)
+}
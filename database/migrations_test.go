@@ -0,0 +1,93 @@
+package database
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/sawatkins/tf2dl-servers/steamid"
+)
+
+// Test upgrading a database created before migrations existed
+func TestMigrateLegacyDatabase(t *testing.T) {
+	var err error
+	db, err = sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(Close)
+
+	ExecuteSQL(`
+	CREATE TABLE servers (
+		instance_id VARCHAR(20) PRIMARY KEY,
+		public_ip CHAR(15),
+		public_dns VARCHAR(100),
+		name VARCHAR(50),
+		server_hostname VARCHAR(100),
+		map VARCHAR(50),
+		players INTEGER,
+		max_players INTEGER,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE player_sessions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		steam_id TEXT NOT NULL,
+		connect_time TEXT NOT NULL,
+		disconnect_time TEXT,
+		duration INTEGER,
+		public_ip CHAR(15)
+	);
+	INSERT INTO servers (instance_id, public_ip, name) VALUES ('i-1', '54.193.198.90', 'us');
+	INSERT INTO player_sessions (steam_id, connect_time, disconnect_time, duration, public_ip) VALUES
+	('U:1:1', '2024-08-01 10:00:00 -0700 PDT', '2024-08-01 10:30:00.123456789 -0700 PDT m=+1800.5', 1800, '54.193.198.90');
+	`)
+
+	if err := migrate(); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+
+	if version, _ := SchemaVersion(); version != len(migrations) {
+		t.Errorf("Expected schema version %d, got %d", len(migrations), version)
+	}
+
+	var connectTime, disconnectTime string
+	err = db.QueryRow("SELECT connect_time, disconnect_time FROM player_sessions WHERE id = 1").Scan(&connectTime, &disconnectTime)
+	if err != nil {
+		t.Fatalf("Failed to read session: %v", err)
+	}
+	if connectTime != "2024-08-01T17:00:00Z" || disconnectTime != "2024-08-01T17:30:00Z" {
+		t.Errorf("Unexpected converted times %q %q", connectTime, disconnectTime)
+	}
+
+	var method string
+	if err := db.QueryRow("SELECT query_method FROM servers WHERE instance_id = 'i-1'").Scan(&method); err != nil || method != QueryRCON {
+		t.Errorf("Expected query_method %q, got %q (%v)", QueryRCON, method, err)
+	}
+
+	var indexes int
+	db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND tbl_name = 'player_sessions' AND name LIKE 'idx_%'").Scan(&indexes)
+	if indexes != 3 {
+		t.Errorf("Expected 3 player_sessions indexes, got %d", indexes)
+	}
+
+	// players are filled in from the existing sessions
+	if player, err := GetPlayer(steamid.FromAccount(1)); err != nil || player.SessionCount != 1 || player.TotalPlaytime != 1800 || player.FirstSeen != "2024-08-01T17:00:00Z" {
+		t.Errorf("Expected the player to be backfilled, got %+v %v", player, err)
+	}
+
+	// new sessions keep getting ids after the copied rows
+	session := newPlayerSession("U:1:2", "2001:db8::1", 0, 60, "")
+	if err := closePlayerSession(&session); err != nil {
+		t.Fatalf("Failed to write session: %v", err)
+	}
+	var id int
+	db.QueryRow("SELECT id FROM player_sessions WHERE steam_id = 'U:1:2'").Scan(&id)
+	if id != 2 {
+		t.Errorf("Expected new session id 2, got %d", id)
+	}
+
+	// running again is a no-op
+	if err := migrate(); err != nil {
+		t.Fatalf("Failed to migrate twice: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/sawatkins/tf2dl-servers/models"
+	"github.com/sawatkins/tf2dl-servers/steamid"
+)
+
+var ErrPlayerNotFound = errors.New("player not found")
+
+// seePlayer records that a player connected at connectTime under name, which may be empty if
+// it isn't known. Names aren't kept in privacy mode.
+func seePlayer(steamID, name string, connectTime int64) error {
+	if HashesPlayerIDs() {
+		name = ""
+	}
+	seen := formatTime(time.Unix(connectTime, 0))
+	_, err := db.Exec(`
+	INSERT INTO players (steam_id, first_seen, last_seen, last_name)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT (steam_id) DO UPDATE SET
+		first_seen = MIN(first_seen, excluded.first_seen),
+		last_seen = MAX(last_seen, excluded.last_seen),
+		last_name = CASE WHEN excluded.last_name = '' THEN last_name ELSE excluded.last_name END;`,
+		steamID, seen, seen, name)
+	return err
+}
+
+// countPlayerSessions updates a player's totals after their sessions changed in tx
+func countPlayerSessions(tx *sql.Tx, steamID string) error {
+	_, err := tx.Exec(`
+	INSERT INTO players (steam_id, first_seen, last_seen, total_playtime, session_count)
+	SELECT steam_id, MIN(connect_time), MAX(COALESCE(disconnect_time, connect_time)), COALESCE(SUM(duration), 0), COUNT(*)
+	FROM player_sessions WHERE steam_id = ? GROUP BY steam_id
+	ON CONFLICT (steam_id) DO UPDATE SET
+		first_seen = MIN(first_seen, excluded.first_seen),
+		last_seen = MAX(last_seen, excluded.last_seen),
+		total_playtime = excluded.total_playtime,
+		session_count = excluded.session_count;`,
+		steamID)
+	if err != nil {
+		return err
+	}
+	// every session was removed, e.g. the one written on shutdown for a resumed connection
+	_, err = tx.Exec(`
+	UPDATE players SET total_playtime = 0, session_count = 0
+	WHERE steam_id = ?1 AND NOT EXISTS (SELECT 1 FROM player_sessions WHERE steam_id = ?1);`,
+		steamID)
+	return err
+}
+
+// GetPlayer returns what is known about a player's account
+func GetPlayer(id steamid.ID) (models.Player, error) {
+	player := models.Player{SteamID64: id.SteamID64(), AccountID: id.Steam3()}
+	err := db.QueryRow(`
+	SELECT first_seen, last_seen, last_name, total_playtime, session_count
+	FROM players WHERE steam_id = ?;`, PlayerKey(id)).Scan(
+		&player.FirstSeen, &player.LastSeen, &player.LastName, &player.TotalPlaytime, &player.SessionCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return player, ErrPlayerNotFound
+	}
+	return player, err
+}
@@ -0,0 +1,472 @@
+package database
+
+import (
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorcon/rcon"
+	"github.com/sawatkins/tf2dl-servers/a2s"
+	"github.com/sawatkins/tf2dl-servers/metrics"
+	"github.com/sawatkins/tf2dl-servers/models"
+	"github.com/sawatkins/tf2dl-servers/notify"
+	"github.com/sawatkins/tf2dl-servers/parser"
+	"github.com/sawatkins/tf2dl-servers/steamid"
+)
+
+// Query methods a server can be polled with. RCON needs the password but also reports SteamIDs,
+// which session tracking depends on; A2S works on any public server.
+const (
+	QueryRCON = "rcon"
+	QueryA2S  = "a2s"
+)
+
+// RCONPort is the port the game servers accept RCON connections on
+var RCONPort = "27015"
+
+// RCONPassword is the RCON password of the game servers
+var RCONPassword string
+
+// ServerRCON returns the RCON port and password of the server at ip. By default every
+// server uses RCONPort and RCONPassword.
+var ServerRCON = func(ip string) (port, password string) {
+	return RCONPort, RCONPassword
+}
+
+// QueryPort is the UDP port the game servers answer A2S queries on, unless they were registered
+// with a game port other than DefaultGamePort
+var QueryPort = "27015"
+
+// Notifier receives an alert when a server goes down and when it's back, nil disables them
+var Notifier *notify.Dispatcher
+
+// DownAfterFailures is how many polls in a row have to fail before a server is reported down
+var DownAfterFailures = 3
+
+// PollInterval is how often UpdateServerInfo is run
+var PollInterval = 30 * time.Second
+
+// PollTimeout bounds the RCON dial and every read/write for a single server
+var PollTimeout = 10 * time.Second
+
+var (
+	pollStatusMu  sync.Mutex
+	pollStatuses  = map[string]*models.PollStatus{}
+	liveStatuses  = map[string]parser.Status{} // last status read from each server
+	lastPollCycle time.Time                    // when UpdateServerInfo last finished
+)
+
+// UpdateServerInfo updates the server information and active player connection in the db for each server IP.
+// Each server is polled in its own goroutine so an unreachable server can't hold up the rest of the fleet.
+func UpdateServerInfo(prevPlayerConnections *map[string]map[string]int64) {
+	methods, ports, err := getQueryMethods()
+	if err != nil {
+		log.Printf("Error updating server info: %v", err)
+		return
+	}
+
+	publishRemovedServers(methods)
+	dropErasedConnections(*prevPlayerConnections)
+
+	// close the sessions of servers that have been deleted since the last poll
+	now := time.Now().Unix()
+	for ip, connections := range *prevPlayerConnections {
+		if _, ok := methods[ip]; ok {
+			continue
+		}
+		for id, connectTime := range connections {
+			session := newPlayerSession(id, ip, connectTime, now, EndReasonRemoved)
+			if err := closePlayerSession(&session); err != nil {
+				log.Printf("Error closing session for SteamID %s on removed server %s: %v", id, ip, err)
+			}
+		}
+		delete(*prevPlayerConnections, ip)
+	}
+
+	// copy every server's connections before any poll starts, the goroutines write their results back
+	copies := make(map[string]map[string]int64, len(methods))
+	for ip := range methods {
+		connections := make(map[string]int64, len((*prevPlayerConnections)[ip]))
+		for id, connectTime := range (*prevPlayerConnections)[ip] {
+			connections[id] = connectTime
+		}
+		copies[ip] = connections
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for ip, method := range methods {
+		connections := copies[ip]
+		wg.Add(1)
+		go func(ip, method string, connections map[string]int64) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := pollServer(ip, ports[ip], method, connections)
+			metrics.PollDuration.WithLabelValues(ip, method).Observe(time.Since(start).Seconds())
+			recordPollResult(ip, start, err)
+			if err != nil {
+				metrics.PollErrors.WithLabelValues(ip, method).Inc()
+				if err := recordSnapshot(ip, time.Now(), nil); err != nil {
+					log.Printf("Error recording snapshot for IP %s: %v", ip, err)
+				}
+			}
+
+			publishServerState(ip)
+
+			mu.Lock()
+			(*prevPlayerConnections)[ip] = connections
+			mu.Unlock()
+		}(ip, method, connections)
+	}
+	wg.Wait()
+
+	pollStatusMu.Lock()
+	lastPollCycle = time.Now()
+	pollStatusMu.Unlock()
+}
+
+// LastPollCycle returns when UpdateServerInfo last finished polling every server,
+// the zero time if it hasn't yet
+func LastPollCycle() time.Time {
+	pollStatusMu.Lock()
+	defer pollStatusMu.Unlock()
+	return lastPollCycle
+}
+
+// GetLiveStatus returns the status read from a server by the last successful poll.
+// ok is false if the server was unreachable at the last poll.
+func GetLiveStatus(ip string) (status parser.Status, ok bool) {
+	pollStatusMu.Lock()
+	defer pollStatusMu.Unlock()
+
+	if pollStatus, polled := pollStatuses[ip]; !polled || pollStatus.ConsecutiveFailures > 0 {
+		return parser.Status{}, false
+	}
+	status, ok = liveStatuses[ip]
+	return status, ok
+}
+
+// GetPollStatuses returns the result of the most recent poll for each server IP
+func GetPollStatuses() map[string]models.PollStatus {
+	pollStatusMu.Lock()
+	defer pollStatusMu.Unlock()
+
+	statuses := make(map[string]models.PollStatus, len(pollStatuses))
+	for ip, status := range pollStatuses {
+		statuses[ip] = *status
+	}
+	return statuses
+}
+
+// FleetState returns the registered servers as reported by /metrics
+func FleetState() ([]metrics.ServerState, error) {
+	rows, err := db.Query(`
+	SELECT instance_id, COALESCE(public_ip, ''), COALESCE(name, ''), COALESCE(map, ''),
+		COALESCE(players, 0), COALESCE(max_players, 0), version
+	FROM servers;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var servers []metrics.ServerState
+	for rows.Next() {
+		var s metrics.ServerState
+		if err := rows.Scan(&s.InstanceID, &s.PublicIP, &s.Name, &s.Map, &s.Players, &s.MaxPlayers, &s.Version); err != nil {
+			return nil, err
+		}
+		servers = append(servers, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	newest, err := newestVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	pollStatusMu.Lock()
+	defer pollStatusMu.Unlock()
+	for i := range servers {
+		status, ok := pollStatuses[servers[i].PublicIP]
+		servers[i].Reachable = ok && status.ConsecutiveFailures == 0
+		servers[i].Outdated = isOutdated(servers[i].PublicIP, servers[i].Version, newest)
+	}
+
+	return servers, nil
+}
+
+// recordPollResult records the result of the poll of ip sent at started
+func recordPollResult(ip string, started time.Time, err error) {
+	pollStatusMu.Lock()
+	defer pollStatusMu.Unlock()
+
+	status, ok := pollStatuses[ip]
+	if !ok {
+		status = &models.PollStatus{PublicIP: ip}
+		pollStatuses[ip] = status
+	}
+
+	status.LastPoll = started.Unix()
+	if err != nil {
+		status.LastError = err.Error()
+		status.ConsecutiveFailures++
+		status.UpSince = 0
+		log.Printf("Poll failed for IP %s (%d in a row): %v", ip, status.ConsecutiveFailures, err)
+		if status.ConsecutiveFailures == DownAfterFailures {
+			Notifier.Send(notify.Alert{Event: notify.EventServerDown, Data: map[string]string{
+				"Server":   serverLabel(ip),
+				"Address":  ip,
+				"Failures": strconv.Itoa(status.ConsecutiveFailures),
+				"Error":    err.Error(),
+			}})
+		}
+		return
+	}
+
+	if status.ConsecutiveFailures >= DownAfterFailures {
+		downtime := "an unknown time"
+		if status.LastSuccess > 0 {
+			downtime = (time.Duration(status.LastPoll-status.LastSuccess) * time.Second).String()
+		}
+		Notifier.Send(notify.Alert{Event: notify.EventServerUp, Data: map[string]string{
+			"Server":   serverLabel(ip),
+			"Address":  ip,
+			"Downtime": downtime,
+		}})
+	}
+
+	status.LastError = ""
+	status.ConsecutiveFailures = 0
+	status.LastSuccess = status.LastPoll
+	if status.UpSince == 0 {
+		status.UpSince = status.LastPoll
+	}
+}
+
+// serverLabel names a server in alerts by the hostname it last reported, or its IP.
+// pollStatusMu must be held.
+func serverLabel(ip string) string {
+	if hostname := liveStatuses[ip].Hostname; hostname != "" {
+		return hostname
+	}
+	return ip
+}
+
+func setLiveStatus(ip string, status parser.Status) {
+	pollStatusMu.Lock()
+	defer pollStatusMu.Unlock()
+	liveStatuses[ip] = status
+}
+
+// getQueryMethods returns how each server is polled and the port it answers A2S queries on.
+// A2S is answered on the game port; servers on the default one use QueryPort, so it still
+// applies to every server that wasn't registered with a port of its own.
+func getQueryMethods() (methods, ports map[string]string, err error) {
+	rows, err := db.Query("SELECT public_ip, COALESCE(query_method, ?), game_port FROM servers", QueryRCON)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	methods, ports = map[string]string{}, map[string]string{}
+	for rows.Next() {
+		var ip, method string
+		var gamePort int
+		if err := rows.Scan(&ip, &method, &gamePort); err != nil {
+			return nil, nil, err
+		}
+		methods[ip] = method
+		ports[ip] = QueryPort
+		if gamePort != 0 && gamePort != DefaultGamePort {
+			ports[ip] = strconv.Itoa(gamePort)
+		}
+	}
+
+	return methods, ports, rows.Err()
+}
+
+// pollServer fetches the status of one server, writes it to the servers table
+// and records sessions for players that have left since the last poll.
+// connections is only modified once the status was fetched successfully, so players on
+// an unreachable server keep their open sessions.
+func pollServer(ip, queryPort, method string, connections map[string]int64) error {
+	var status parser.Status
+	var err error
+	if method == QueryA2S {
+		status, err = queryA2S(ip, queryPort)
+	} else {
+		status, err = queryRCON(ip)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Update the server information in the database
+	serverStatus := models.ServerStatus{
+		PublicIP:   ip,
+		Map:        status.Map,
+		Players:    strconv.Itoa(status.Humans),
+		MaxPlayers: strconv.Itoa(status.MaxPlayers),
+		Hostname:   status.Hostname,
+	}
+
+	updateServerSQL := `
+	UPDATE servers
+	SET map = ?, players = ?, max_players = ?, server_hostname = ?, version = ?
+	WHERE public_ip = ?;`
+
+	_, err = db.Exec(updateServerSQL,
+		serverStatus.Map,
+		serverStatus.Players,
+		serverStatus.MaxPlayers,
+		serverStatus.Hostname,
+		status.Version,
+		serverStatus.PublicIP,
+	)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Server info updated for IP: %s", ip)
+
+	setLiveStatus(ip, status)
+	if err := recordSnapshot(ip, time.Now(), &status); err != nil {
+		log.Printf("Error recording snapshot for IP %s: %v", ip, err)
+	}
+	if err := recordMapChange(ip, status.Map, time.Now()); err != nil {
+		log.Printf("Error recording map change for IP %s: %v", ip, err)
+	}
+	if err := checkVersion(ip, status, time.Now()); err != nil {
+		log.Printf("Error checking version of IP %s: %v", ip, err)
+	}
+
+	// A2S only reports player names, so sessions can't be tracked
+	if method == QueryA2S {
+		return nil
+	}
+
+	// Update active player connections
+	currentPlayerIds := map[string]bool{}
+	names := map[string]string{}
+	for _, player := range status.Players {
+		// bots and ids Steam hasn't confirmed yet, like STEAM_ID_PENDING or STEAM_ID_LAN, can't be tracked
+		steamID, err := steamid.Parse(player.SteamID)
+		if err != nil {
+			continue
+		}
+		id := PlayerKey(steamID)
+		currentPlayerIds[id] = true
+		names[id] = player.Name
+	}
+
+	// get new ids (ids in current players not in prev ids)
+	now := time.Now().Unix()
+	for currID := range currentPlayerIds {
+		if _, exists := connections[currID]; !exists {
+			if err := openConnection(ip, currID, names[currID], now); err != nil {
+				log.Printf("Error recording connection for SteamID %s: %v", currID, err)
+			}
+			metrics.SessionsStarted.WithLabelValues(ip).Inc()
+			connections[currID] = now
+		}
+	}
+
+	if err := touchConnections(ip, now); err != nil {
+		log.Printf("Error updating active connections for IP %s: %v", ip, err)
+	}
+
+	// get disconnected ids (ids in prev ids not in current players)
+	disconnectedIds := []string{}
+	for prevID := range connections {
+		if !currentPlayerIds[prevID] {
+			disconnectedIds = append(disconnectedIds, prevID)
+		}
+	}
+
+	// for disconnectedIds, add player session to the db
+	for _, id := range disconnectedIds {
+		session := newPlayerSession(id, ip, connections[id], now, "")
+		if err := closePlayerSession(&session); err != nil {
+			log.Printf("Error executing SQL statement for player session: %v", err)
+			continue
+		}
+
+		log.Printf("Player session recorded for SteamID: %s", id)
+
+		// delete entry from connections
+		delete(connections, id)
+	}
+
+	return nil
+}
+
+// queryRCON runs `status` over RCON
+func queryRCON(ip string) (parser.Status, error) {
+	response, err := RCON(ip, "status")
+	if err != nil {
+		return parser.Status{}, err
+	}
+
+	return parser.Parse(response)
+}
+
+// RCON runs command on the server at ip and returns its response
+func RCON(ip, command string) (string, error) {
+	client, err := dialRCON(ip)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	return client.Execute(command)
+}
+
+func dialRCON(ip string) (*rcon.Conn, error) {
+	port, password := ServerRCON(ip)
+	return rcon.Dial(net.JoinHostPort(ip, port), password,
+		rcon.SetDialTimeout(PollTimeout),
+		rcon.SetDeadline(PollTimeout),
+	)
+}
+
+// queryA2S builds a status from A2S_INFO and A2S_PLAYER on port. Players have no SteamID.
+func queryA2S(ip, port string) (parser.Status, error) {
+	client := a2s.NewClient(net.JoinHostPort(ip, port), PollTimeout)
+
+	info, err := client.QueryInfo()
+	if err != nil {
+		return parser.Status{}, err
+	}
+
+	players, err := client.QueryPlayers()
+	if err != nil {
+		return parser.Status{}, err
+	}
+
+	status := parser.Status{
+		Hostname:   info.Name,
+		Secure:     info.VAC,
+		Map:        info.Map,
+		Humans:     info.Players - info.Bots,
+		Bots:       info.Bots,
+		MaxPlayers: info.MaxPlayers,
+	}
+	status.Version, _ = strconv.Atoi(info.Version)
+	if info.Keywords != "" {
+		status.Tags = strings.Split(info.Keywords, ",")
+	}
+	for _, player := range players {
+		status.Players = append(status.Players, parser.Player{
+			UserID:    int(player.Index),
+			Name:      player.Name,
+			Connected: player.Duration,
+		})
+	}
+
+	return status, nil
+}
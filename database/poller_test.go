@@ -0,0 +1,207 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorcon/rcon"
+	"github.com/gorcon/rcon/rcontest"
+	"github.com/sawatkins/tf2dl-servers/notify"
+	"github.com/sawatkins/tf2dl-servers/steamid"
+)
+
+const statusResponse = `hostname: simple surf server (us) - servers.tf2dl.net
+version : 9543365/24 9543365 secure
+udp/ip  : 0.0.0.0:27015  (public ip: 54.193.198.90)
+steamid : [G:1:1234567] (85568392921234567)
+account : not logged in  (No account specified)
+map     : surf_utopia_v3 at: 0 x, 0 y, 0 z
+tags    : surf
+players : 1 humans, 0 bots (24 max)
+edicts  : 1045 used of 2048 max
+# userid name                uniqueid            connected ping loss state  adr
+#      3 "Player One"        [U:1:12345678]      12:34       45    0 active 1.2.3.4:27005
+#      4 "Player Two"        STEAM_ID_PENDING    00:05      120    0 spawning 5.6.7.8:27005
+`
+
+func newStatusServer(t *testing.T, password string) *rcontest.Server {
+	server := rcontest.NewServer(
+		rcontest.SetSettings(rcontest.Settings{Password: password}),
+		rcontest.SetCommandHandler(func(c *rcontest.Context) {
+			_, _ = rcon.NewPacket(rcon.SERVERDATA_RESPONSE_VALUE, c.Request().ID, statusResponse).WriteTo(c.Conn())
+		}),
+	)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// An unreachable server must not stop the rest of the fleet from being updated
+func TestUpdateServerInfoIsolatesFailures(t *testing.T) {
+	InitDB(":memory:")
+	t.Cleanup(Close)
+
+	RCONPassword = "password"
+	t.Cleanup(func() { RCONPassword = "" })
+	server := newStatusServer(t, "password")
+	_, port, _ := net.SplitHostPort(server.Addr())
+	RCONPort = port
+
+	// the dead server is listed first, which used to abort the whole update
+	ExecuteSQL(`
+		INSERT INTO servers (instance_id, public_ip, name) VALUES
+		('i-dead', '127.0.0.2', 'dead'),
+		('i-live', '127.0.0.1', 'live')
+	`)
+
+	connections := map[string]map[string]int64{}
+	UpdateServerInfo(&connections)
+
+	info, err := GetServerInfo("127.0.0.1")
+	if err != nil {
+		t.Fatalf("Failed to get server info: %v", err)
+	}
+	if info.Map != "surf_utopia_v3" || info.Players != "1" || info.MaxPlayers != "24" {
+		t.Errorf("Live server was not updated, got %+v", info)
+	}
+
+	if _, ok := connections["127.0.0.1"]["U:1:12345678"]; !ok || len(connections["127.0.0.1"]) != 1 {
+		t.Errorf("Expected only a connection for U:1:12345678, got %v", connections)
+	}
+	if player, err := GetPlayer(steamid.FromAccount(12345678)); err != nil || player.LastName != "Player One" {
+		t.Errorf("Expected the player to be recorded with their name, got %+v %v", player, err)
+	}
+
+	statuses := GetPollStatuses()
+	if status := statuses["127.0.0.2"]; status.ConsecutiveFailures != 1 || status.LastError == "" {
+		t.Errorf("Expected one recorded failure for dead server, got %+v", status)
+	}
+	if status := statuses["127.0.0.1"]; status.ConsecutiveFailures != 0 || status.LastSuccess == 0 {
+		t.Errorf("Expected success for live server, got %+v", status)
+	}
+
+	UpdateServerInfo(&connections)
+	if status := GetPollStatuses()["127.0.0.2"]; status.ConsecutiveFailures != 2 {
+		t.Errorf("Expected two consecutive failures, got %d", status.ConsecutiveFailures)
+	}
+}
+
+// A2S is queried on a server's own game port, and on QueryPort for servers on the default one
+func TestQueryPorts(t *testing.T) {
+	InitDB(":memory:")
+	t.Cleanup(Close)
+	QueryPort = "27020"
+	t.Cleanup(func() { QueryPort = "27015" })
+
+	ExecuteSQL(`
+		INSERT INTO servers (instance_id, public_ip, name, query_method) VALUES ('i-1', '10.0.0.1', 'default', 'a2s');
+		INSERT INTO servers (instance_id, public_ip, name, query_method, game_port) VALUES ('i-2', '10.0.0.2', 'custom', 'a2s', 27115);
+	`)
+
+	methods, ports, err := getQueryMethods()
+	if err != nil {
+		t.Fatalf("Failed to get query methods: %v", err)
+	}
+	if methods["10.0.0.2"] != QueryA2S || ports["10.0.0.1"] != "27020" || ports["10.0.0.2"] != "27115" {
+		t.Errorf("Unexpected query ports %v for methods %v", ports, methods)
+	}
+}
+
+type alertRecorder struct {
+	mu       sync.Mutex
+	messages []notify.Message
+}
+
+func (r *alertRecorder) Name() string { return "recorder" }
+
+func (r *alertRecorder) Notify(ctx context.Context, msg notify.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, msg)
+	return nil
+}
+
+// A server is reported down once after DownAfterFailures failed polls, and up when it answers again
+func TestPollAlerts(t *testing.T) {
+	recorder := &alertRecorder{}
+	Notifier = notify.NewDispatcher(recorder)
+	t.Cleanup(func() { Notifier = nil })
+
+	ip := "127.0.0.3"
+	for i := 0; i < DownAfterFailures+2; i++ {
+		recordPollResult(ip, time.Now(), errors.New("connection refused"))
+	}
+	Notifier.Wait() // alerts are delivered concurrently
+	recordPollResult(ip, time.Now(), nil)
+	recordPollResult(ip, time.Now(), nil)
+	Notifier.Wait()
+
+	if len(recorder.messages) != 2 {
+		t.Fatalf("Expected a down and an up alert, got %+v", recorder.messages)
+	}
+	down, up := recorder.messages[0], recorder.messages[1]
+	if down.Event != notify.EventServerDown || down.Text != ip+" ("+ip+") failed 3 polls in a row: connection refused" {
+		t.Errorf("Unexpected down alert %+v", down)
+	}
+	if up.Event != notify.EventServerUp || up.Title != ip+" is back" {
+		t.Errorf("Unexpected up alert %+v", up)
+	}
+}
+
+// LastPoll reports when a poll was sent, so a poll in flight when an update quits the server
+// isn't mistaken for the server answering again
+func TestLastPollSentAt(t *testing.T) {
+	ip := "127.0.0.4"
+	sent := time.Now().Add(-2 * time.Second)
+	quit := time.Now().Add(-time.Second)
+	recordPollResult(ip, sent, nil)
+
+	var fleet GameServers
+	if poll := fleet.LastPoll(ip); !poll.Reachable || poll.At.After(quit) {
+		t.Errorf("Expected the poll to be reported at %s, got %+v", sent, poll)
+	}
+}
+
+// A server behind the newest version seen on the fleet is flagged and reported once
+func TestOutdatedServer(t *testing.T) {
+	InitDB(":memory:")
+	t.Cleanup(Close)
+	recorder := &alertRecorder{}
+	Notifier = notify.NewDispatcher(recorder)
+	t.Cleanup(func() { Notifier = nil })
+
+	RCONPassword = "password"
+	t.Cleanup(func() { RCONPassword = "" })
+	server := newStatusServer(t, "password")
+	_, port, _ := net.SplitHostPort(server.Addr())
+	RCONPort = port
+
+	ExecuteSQL(`INSERT INTO servers (instance_id, public_ip, name) VALUES ('i-live', '127.0.0.1', 'live')`)
+	connections := map[string]map[string]int64{}
+	UpdateServerInfo(&connections)
+
+	if version, outdated, err := ServerVersion("127.0.0.1"); err != nil || version != 9543365 || outdated {
+		t.Errorf("Expected an up to date server on 9543365, got %d %v %v", version, outdated, err)
+	}
+
+	// another server has been seen on a newer build
+	ExecuteSQL(`INSERT INTO game_versions (version, first_seen, first_server) VALUES (9600000, '2026-10-18T00:00:00Z', '127.0.0.9')`)
+	UpdateServerInfo(&connections)
+	UpdateServerInfo(&connections)
+	Notifier.Wait()
+
+	states, err := GetServerStates()
+	if err != nil || len(states) != 1 || !states[0].Outdated || states[0].Version != 9543365 {
+		t.Errorf("Expected the server to be outdated, got %+v %v", states, err)
+	}
+	fleet, _ := FleetState()
+	if len(fleet) != 1 || !fleet[0].Outdated {
+		t.Errorf("Expected the fleet metrics to show the server outdated, got %+v", fleet)
+	}
+	if len(recorder.messages) != 1 || recorder.messages[0].Event != notify.EventServerOutdated {
+		t.Errorf("Expected one outdated alert, got %+v", recorder.messages)
+	}
+}
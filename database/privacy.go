@@ -0,0 +1,115 @@
+package database
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strconv"
+
+	"github.com/sawatkins/tf2dl-servers/steamid"
+)
+
+// PrivacySecret keys the hashes stored in place of Steam ids in privacy mode. While it is
+// empty the U:1:n ids themselves are stored. Set it before InitDB, which hashes the ids
+// already stored; hashed ids can't be turned back into Steam ids.
+var PrivacySecret []byte
+
+// HashesPlayerIDs reports whether privacy mode is on
+func HashesPlayerIDs() bool {
+	return len(PrivacySecret) > 0
+}
+
+// PlayerKey returns the id an account's sessions are stored under: the hex HMAC-SHA256 of
+// its account number in privacy mode, otherwise U:1:n
+func PlayerKey(id steamid.ID) string {
+	if !HashesPlayerIDs() {
+		return id.Steam3()
+	}
+	return hashPlayerKey(strconv.FormatUint(uint64(id.Account()), 10))
+}
+
+// playerKey converts an id as game servers report it, U:1:n, to the id it is stored under.
+// An id that doesn't parse is kept as it is, or hashed whole in privacy mode.
+func playerKey(accountID string) string {
+	id, err := steamid.Parse(accountID)
+	if err == nil {
+		return PlayerKey(id)
+	}
+	if !HashesPlayerIDs() {
+		return accountID
+	}
+	return hashPlayerKey(accountID)
+}
+
+func hashPlayerKey(value string) string {
+	mac := hmac.New(sha256.New, PrivacySecret)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// hashPlayerIDs rewrites the Steam ids stored before privacy mode was turned on as hashes.
+// Once done there are no U:1:n ids left, so it only does any work the first time.
+func hashPlayerIDs() (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+	SELECT steam_id FROM player_sessions WHERE steam_id LIKE 'U:1:%'
+	UNION SELECT steam_id FROM active_connections WHERE steam_id LIKE 'U:1:%'
+	UNION SELECT steam_id FROM players WHERE steam_id LIKE 'U:1:%'
+	UNION SELECT steam_id FROM leaderboard_opt_outs WHERE steam_id LIKE 'U:1:%'
+	UNION SELECT steam_id FROM users WHERE steam_id LIKE 'U:1:%';`)
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		if err := hashPlayerID(tx, id, playerKey(id)); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), tx.Commit()
+}
+
+// hashPlayerID moves everything stored under id to key. A player may already have rows under
+// key if privacy mode was turned off for a while, so their totals are merged.
+func hashPlayerID(tx *sql.Tx, id, key string) error {
+	statements := []string{
+		"UPDATE player_sessions SET steam_id = ?2 WHERE steam_id = ?1;",
+		"UPDATE OR REPLACE active_connections SET steam_id = ?2 WHERE steam_id = ?1;",
+		"UPDATE OR REPLACE leaderboard_opt_outs SET steam_id = ?2 WHERE steam_id = ?1;",
+		"UPDATE OR REPLACE users SET steam_id = ?2 WHERE steam_id = ?1;",
+		// names are dropped along with the ids, they often identify a player just as well
+		`INSERT INTO players (steam_id, first_seen, last_seen, total_playtime, session_count)
+		SELECT ?2, first_seen, last_seen, total_playtime, session_count FROM players WHERE steam_id = ?1
+		ON CONFLICT (steam_id) DO UPDATE SET
+			first_seen = MIN(first_seen, excluded.first_seen),
+			last_seen = MAX(last_seen, excluded.last_seen),
+			total_playtime = total_playtime + excluded.total_playtime,
+			session_count = session_count + excluded.session_count;`,
+		"DELETE FROM players WHERE steam_id = ?1;",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, id, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sawatkins/tf2dl-servers/logs"
+	"github.com/sawatkins/tf2dl-servers/steamid"
+)
+
+// Ids stored before privacy mode are hashed once, and stats keep adding up afterwards
+func TestPrivacyMode(t *testing.T) {
+	InitDB(":memory:")
+	t.Cleanup(Close)
+
+	ExecuteSQL(`
+	INSERT INTO servers (instance_id, public_ip, name) VALUES ('i-1', '192.168.1.1', 'us');
+	INSERT INTO player_sessions (steam_id, connect_time, disconnect_time, duration, public_ip) VALUES
+	('U:1:12345678', '2024-08-01T17:00:00Z', '2024-08-01T17:30:00Z', 1800, '192.168.1.1'),
+	('U:1:not-a-number', '2024-08-01T17:00:00Z', '2024-08-01T17:30:00Z', 1800, '192.168.1.1');
+	INSERT INTO players (steam_id, first_seen, last_seen, last_name, total_playtime, session_count) VALUES
+	('U:1:12345678', '2024-08-01T17:00:00Z', '2024-08-01T17:30:00Z', 'Player One', 1800, 1);
+	INSERT INTO active_connections (steam_id, public_ip, connect_time, last_seen) VALUES ('U:1:12345678', '192.168.1.1', 0, 0);
+	INSERT INTO leaderboard_opt_outs (steam_id, opted_out_at) VALUES ('U:1:12345678', '2024-08-01T17:00:00Z');
+	INSERT INTO users (steam_id, created_at, last_login) VALUES ('U:1:12345678', '2024-08-01T17:00:00Z', '2024-08-01T17:00:00Z');
+	`)
+
+	PrivacySecret = []byte("privacy-secret")
+	t.Cleanup(func() { PrivacySecret = nil })
+
+	// ids that don't parse are hashed whole rather than kept
+	if hashed, err := hashPlayerIDs(); err != nil || hashed != 2 {
+		t.Fatalf("Expected 2 ids to be hashed, got %d %v", hashed, err)
+	}
+	if hashed, err := hashPlayerIDs(); err != nil || hashed != 0 {
+		t.Errorf("Expected nothing left to hash, got %d %v", hashed, err)
+	}
+
+	var raw int
+	db.QueryRow(`
+	SELECT (SELECT COUNT(*) FROM player_sessions WHERE steam_id LIKE 'U:1:%') +
+		(SELECT COUNT(*) FROM active_connections WHERE steam_id LIKE 'U:1:%') +
+		(SELECT COUNT(*) FROM leaderboard_opt_outs WHERE steam_id LIKE 'U:1:%') +
+		(SELECT COUNT(*) FROM users WHERE steam_id LIKE 'U:1:%') +
+		(SELECT COUNT(*) FROM players WHERE steam_id LIKE 'U:1:%' OR last_name != '')`).Scan(&raw)
+	if raw != 0 {
+		t.Errorf("Expected no Steam ids or names left, found %d rows", raw)
+	}
+
+	id := steamid.FromAccount(12345678)
+	key := PlayerKey(id)
+	if len(key) != 64 || key == PlayerKey(steamid.FromAccount(12345679)) {
+		t.Errorf("Expected a distinct hex HMAC per account, got %s", key)
+	}
+
+	if user, err := GetUser(id); err != nil || user.SteamID64 != id.SteamID64() {
+		t.Errorf("Expected the user to still be found by their Steam id, got %+v %v", user, err)
+	}
+	if optedOut, err := LeaderboardOptedOut(id); err != nil || !optedOut {
+		t.Errorf("Expected the leaderboard opt out to be kept, got %v %v", optedOut, err)
+	}
+
+	// new sessions are stored under the same hash
+	connections := map[string]map[string]int64{}
+	player := logs.Player{Name: "Player One", UserID: 3, SteamID: "[U:1:12345678]"}
+	connected := time.Now().Add(-time.Minute).Truncate(time.Second)
+	ApplyLogEvent(&connections, logs.Event{Server: "192.168.1.1", Time: connected, Type: logs.EventConnect, Player: player})
+	ApplyLogEvent(&connections, logs.Event{Server: "192.168.1.1", Time: connected.Add(time.Minute), Type: logs.EventDisconnect, Player: player})
+	pending := logs.Player{Name: "Player Two", UserID: 4, SteamID: "STEAM_ID_PENDING"}
+	ApplyLogEvent(&connections, logs.Event{Server: "192.168.1.1", Time: connected, Type: logs.EventConnect, Player: pending})
+	if len(connections["192.168.1.1"]) != 0 {
+		t.Errorf("Expected unconfirmed ids not to be tracked, got %v", connections)
+	}
+	if _, ok := connections["192.168.1.1"][key]; ok {
+		t.Errorf("Expected the connection to be closed, got %v", connections)
+	}
+
+	stats, err := GetPlayerStats(id)
+	if err != nil || stats.Sessions != 2 || stats.TotalTime != 1860 {
+		t.Errorf("Expected both sessions in the stats, got %+v %v", stats, err)
+	}
+	if recorded, err := GetPlayer(id); err != nil || recorded.SessionCount != 2 || recorded.TotalPlaytime != 1860 || recorded.LastName != "" {
+		t.Errorf("Expected the player's totals without a name, got %+v %v", recorded, err)
+	}
+}
@@ -0,0 +1,239 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/sawatkins/tf2dl-servers/models"
+	"github.com/sawatkins/tf2dl-servers/parser"
+)
+
+// snapshotLevel is one resolution snapshots are kept at. Raw snapshots are written by every
+// poll, each coarser level is rolled up from the one before it and kept for longer.
+type snapshotLevel struct {
+	resolution int64 // seconds covered by one row, 0 for raw polls
+	retention  time.Duration
+}
+
+// SnapshotLevels lists the snapshot resolutions from finest to coarsest
+var SnapshotLevels = []snapshotLevel{
+	{0, 2 * 24 * time.Hour},
+	{5 * 60, 30 * 24 * time.Hour},
+	{60 * 60, 2 * 365 * 24 * time.Hour},
+}
+
+// recordSnapshot stores the result of one poll. status is nil if the server was unreachable.
+func recordSnapshot(ip string, at time.Time, status *parser.Status) error {
+	snapshot := models.Snapshot{Time: at.Unix()}
+	if status != nil {
+		snapshot = models.Snapshot{
+			Time:        at.Unix(),
+			Map:         status.Map,
+			Players:     float64(status.Humans),
+			PeakPlayers: status.Humans,
+			MaxPlayers:  status.MaxPlayers,
+			Bots:        float64(status.Bots),
+			Reachable:   1,
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertSnapshot(tx, ip, 0, snapshot); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertSnapshot(tx *sql.Tx, ip string, resolution int64, s models.Snapshot) error {
+	_, err := tx.Exec(`
+	INSERT OR REPLACE INTO server_snapshots (
+		public_ip, resolution, taken_at, map, players, peak_players, max_players, bots, reachable
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+		ip, resolution, s.Time, s.Map, s.Players, s.PeakPlayers, s.MaxPlayers, s.Bots, s.Reachable)
+	return err
+}
+
+// RollupSnapshots rolls complete buckets up into each coarser level and deletes snapshots
+// past their level's retention. It only reads buckets newer than the last one rolled up,
+// so it is cheap to run often.
+func RollupSnapshots(now time.Time) {
+	for i := 1; i < len(SnapshotLevels); i++ {
+		if err := rollupSnapshots(SnapshotLevels[i-1].resolution, SnapshotLevels[i].resolution, now); err != nil {
+			log.Printf("Error rolling up %ds snapshots: %v", SnapshotLevels[i].resolution, err)
+		}
+	}
+
+	for _, level := range SnapshotLevels {
+		cutoff := now.Add(-level.retention).Unix()
+		_, err := db.Exec("DELETE FROM server_snapshots WHERE resolution = ? AND taken_at < ?;", level.resolution, cutoff)
+		if err != nil {
+			log.Printf("Error deleting expired %ds snapshots: %v", level.resolution, err)
+		}
+	}
+}
+
+func rollupSnapshots(source, target int64, now time.Time) error {
+	var last sql.NullInt64
+	err := db.QueryRow("SELECT MAX(taken_at) FROM server_snapshots WHERE resolution = ?", target).Scan(&last)
+	if err != nil {
+		return err
+	}
+	from := int64(0)
+	if last.Valid {
+		from = last.Int64 + target
+	}
+	until := now.Unix() / target * target // only buckets that are over
+
+	byIP, err := querySnapshots("", source, from, until)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for ip, snapshots := range byIP {
+		for _, bucket := range bucketSnapshots(snapshots, target) {
+			if err := insertSnapshot(tx, ip, target, bucket); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// querySnapshots returns the snapshots at a resolution taken in [from, until), oldest first
+// and grouped by server IP. An empty ip returns every server.
+func querySnapshots(ip string, resolution, from, until int64) (map[string][]models.Snapshot, error) {
+	rows, err := db.Query(`
+	SELECT public_ip, taken_at, map, players, peak_players, max_players, bots, reachable
+	FROM server_snapshots
+	WHERE resolution = ? AND taken_at >= ? AND taken_at < ? AND (? = '' OR public_ip = ?)
+	ORDER BY taken_at;`, resolution, from, until, ip, ip)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byIP := map[string][]models.Snapshot{}
+	for rows.Next() {
+		var ip string
+		var s models.Snapshot
+		if err := rows.Scan(&ip, &s.Time, &s.Map, &s.Players, &s.PeakPlayers, &s.MaxPlayers, &s.Bots, &s.Reachable); err != nil {
+			return nil, err
+		}
+		byIP[ip] = append(byIP[ip], s)
+	}
+
+	return byIP, rows.Err()
+}
+
+// bucketSnapshots merges snapshots sorted by time into one per step. Averages are
+// taken over the snapshots in the bucket and the map is the last one played.
+func bucketSnapshots(snapshots []models.Snapshot, step int64) []models.Snapshot {
+	var buckets []models.Snapshot
+	var count float64
+	for _, s := range snapshots {
+		start := s.Time / step * step
+		if len(buckets) == 0 || buckets[len(buckets)-1].Time != start {
+			buckets = append(buckets, models.Snapshot{Time: start})
+			count = 0
+		}
+
+		b := &buckets[len(buckets)-1]
+		count++
+		b.Players += (s.Players - b.Players) / count
+		b.Bots += (s.Bots - b.Bots) / count
+		b.Reachable += (s.Reachable - b.Reachable) / count
+		b.PeakPlayers = max(b.PeakPlayers, s.PeakPlayers)
+		b.MaxPlayers = max(b.MaxPlayers, s.MaxPlayers)
+		if s.Map != "" {
+			b.Map = s.Map
+		}
+	}
+	return buckets
+}
+
+// GetServerHistory returns a server's snapshots between from and to merged into one per step.
+// Snapshots are read from the coarsest level that is no coarser than step and still covers
+// from, falling back to finer levels for the time that hasn't been rolled up yet.
+// Buckets without any snapshot are left out.
+func GetServerHistory(ip string, from, to time.Time, step time.Duration) ([]models.Snapshot, error) {
+	stepSeconds := max(int64(step/time.Second), 1)
+	now := time.Now()
+
+	chosen := 0
+	for i, level := range SnapshotLevels {
+		if level.resolution > stepSeconds && now.Sub(from) <= SnapshotLevels[chosen].retention {
+			break
+		}
+		chosen = i
+	}
+
+	var snapshots []models.Snapshot
+	start, end := from.Unix(), to.Unix()
+	for i := chosen; i >= 0 && start < end; i-- {
+		resolution := SnapshotLevels[i].resolution
+
+		until := end
+		if i > 0 {
+			// rows at this level stop at the last bucket rolled up, finer levels cover the rest
+			var last sql.NullInt64
+			err := db.QueryRow("SELECT MAX(taken_at) FROM server_snapshots WHERE resolution = ? AND public_ip = ?", resolution, ip).Scan(&last)
+			if err != nil {
+				log.Printf("Error querying snapshots for IP %s: %v", ip, err)
+				return nil, err
+			}
+			until = start
+			if last.Valid {
+				until = min(max(last.Int64+resolution, start), end)
+			}
+		}
+
+		byIP, err := querySnapshots(ip, resolution, start, until)
+		if err != nil {
+			log.Printf("Error querying snapshots for IP %s: %v", ip, err)
+			return nil, err
+		}
+		snapshots = append(snapshots, byIP[ip]...)
+		start = until
+	}
+
+	return bucketSnapshots(snapshots, stepSeconds), nil
+}
+
+// GetLastSeen returns the time of the last successful poll of each server IP, in unix seconds.
+// Once raw snapshots have expired it is the start of the last rolled up bucket the server was seen in.
+func GetLastSeen() (map[string]int64, error) {
+	rows, err := db.Query(`
+	SELECT public_ip, MAX(taken_at)
+	FROM server_snapshots
+	WHERE reachable > 0
+	GROUP BY public_ip;`)
+	if err != nil {
+		log.Printf("Error querying last seen times: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	lastSeen := map[string]int64{}
+	for rows.Next() {
+		var ip string
+		var seen int64
+		if err := rows.Scan(&ip, &seen); err != nil {
+			return nil, err
+		}
+		lastSeen[ip] = seen
+	}
+
+	return lastSeen, rows.Err()
+}
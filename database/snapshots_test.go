@@ -0,0 +1,103 @@
+package database
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/sawatkins/tf2dl-servers/parser"
+)
+
+// Raw snapshots are rolled up into 5 minute and hourly buckets and expire after their retention
+func TestRollupSnapshots(t *testing.T) {
+	InitDB(":memory:")
+	t.Cleanup(Close)
+
+	hour := time.Now().UTC().Truncate(time.Hour).Add(-3 * time.Hour)
+	for i := 0; i < 120; i++ { // an hour of polls every 30s
+		status := &parser.Status{Map: "cp_badlands", Humans: i % 10, MaxPlayers: 24}
+		if i == 119 {
+			status.Map = "pl_upward"
+		}
+		if i%20 == 0 {
+			status = nil // unreachable
+		}
+		if err := recordSnapshot("192.168.1.1", hour.Add(time.Duration(i)*30*time.Second), status); err != nil {
+			t.Fatalf("Failed to record snapshot: %v", err)
+		}
+	}
+	// old enough to be past the raw retention, it is still rolled up before it expires
+	if err := recordSnapshot("192.168.1.1", time.Now().Add(-3*24*time.Hour), &parser.Status{Humans: 1}); err != nil {
+		t.Fatalf("Failed to record snapshot: %v", err)
+	}
+
+	RollupSnapshots(time.Now())
+	RollupSnapshots(time.Now()) // a second run mustn't change anything
+
+	count := func(resolution int64) int {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM server_snapshots WHERE resolution = ?", resolution).Scan(&n); err != nil {
+			t.Fatalf("Failed to count snapshots: %v", err)
+		}
+		return n
+	}
+	if n := count(0); n != 120 {
+		t.Errorf("Expected 120 raw snapshots after expiry, got %d", n)
+	}
+	if n := count(300); n != 13 {
+		t.Errorf("Expected 13 five minute snapshots, got %d", n)
+	}
+	if n := count(3600); n != 2 {
+		t.Errorf("Expected 2 hourly snapshots, got %d", n)
+	}
+
+	byIP, err := querySnapshots("192.168.1.1", 3600, hour.Unix(), time.Now().Unix())
+	if err != nil {
+		t.Fatalf("Failed to query snapshots: %v", err)
+	}
+	hourly := byIP["192.168.1.1"][0]
+	if hourly.Time != hour.Unix() || hourly.PeakPlayers != 9 || hourly.MaxPlayers != 24 || hourly.Map != "pl_upward" {
+		t.Errorf("Unexpected hourly snapshot %+v", hourly)
+	}
+	if math.Abs(hourly.Reachable-0.95) > 1e-9 {
+		t.Errorf("Expected the server to be reachable for 95%% of polls, got %v", hourly.Reachable)
+	}
+}
+
+// History stitches rolled up buckets together with raw snapshots that haven't been rolled up yet
+func TestGetServerHistory(t *testing.T) {
+	InitDB(":memory:")
+	t.Cleanup(Close)
+
+	now := time.Now().UTC().Truncate(time.Hour).Add(-30 * time.Minute)
+	start := now.Truncate(time.Hour).Add(-2 * time.Hour)
+	for at := start; at.Before(now); at = at.Add(time.Minute) {
+		if err := recordSnapshot("192.168.1.1", at, &parser.Status{Map: "cp_badlands", Humans: 4, MaxPlayers: 24}); err != nil {
+			t.Fatalf("Failed to record snapshot: %v", err)
+		}
+	}
+	RollupSnapshots(now)
+
+	// drop raw snapshots that were rolled up so the hourly rows are the only record of them
+	if _, err := db.Exec("DELETE FROM server_snapshots WHERE resolution = 0 AND taken_at < ?", now.Truncate(time.Hour).Unix()); err != nil {
+		t.Fatalf("Failed to delete raw snapshots: %v", err)
+	}
+
+	history, err := GetServerHistory("192.168.1.1", start, now, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("Expected 3 hourly points, got %+v", history)
+	}
+	for _, point := range history {
+		if point.PeakPlayers != 4 || point.Players != 4 {
+			t.Errorf("Unexpected point %+v", point)
+		}
+	}
+
+	history, err = GetServerHistory("192.168.1.2", start, now, time.Hour)
+	if err != nil || len(history) != 0 {
+		t.Errorf("Expected no history for unknown server, got %+v, %v", history, err)
+	}
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/sawatkins/tf2dl-servers/updater"
+)
+
+// GameServers gives the update orchestrator the registered servers, the poller's view
+// of them and RCON access
+type GameServers struct{}
+
+func (GameServers) Servers() ([]string, error) {
+	return GetServerIPs()
+}
+
+func (GameServers) LastPoll(ip string) updater.Poll {
+	pollStatusMu.Lock()
+	defer pollStatusMu.Unlock()
+
+	status, ok := pollStatuses[ip]
+	if !ok {
+		return updater.Poll{}
+	}
+	return updater.Poll{
+		Players:   liveStatuses[ip].Humans,
+		Reachable: status.ConsecutiveFailures == 0,
+		At:        time.Unix(status.LastPoll, 0),
+	}
+}
+
+func (GameServers) Say(ip, message string) error {
+	_, err := RCON(ip, "say "+message)
+	return err
+}
+
+// Quit sends RCON `quit`. The server usually exits without answering, so only failing
+// to connect or to send the command is an error.
+func (GameServers) Quit(ip string) error {
+	client, err := dialRCON(ip)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	_, err = client.Execute("quit")
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+		return nil
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return nil
+	}
+	return err
+}
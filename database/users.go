@@ -0,0 +1,90 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/sawatkins/tf2dl-servers/models"
+	"github.com/sawatkins/tf2dl-servers/steamid"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+// SaveUser records a sign in, creating the user the first time
+func SaveUser(id steamid.ID) (models.User, error) {
+	now := formatTime(time.Now())
+	_, err := db.Exec(`
+	INSERT INTO users (steam_id, created_at, last_login)
+	VALUES (?, ?, ?)
+	ON CONFLICT(steam_id) DO UPDATE SET last_login = excluded.last_login;`,
+		PlayerKey(id), now, now)
+	if err != nil {
+		log.Printf("Error saving user %d: %v", id, err)
+		return models.User{}, err
+	}
+	return GetUser(id)
+}
+
+// GetUser returns the user who signed in as an account. Only PlayerKey(id) is stored, so the
+// ids of the returned user come from id.
+func GetUser(id steamid.ID) (models.User, error) {
+	user := models.User{SteamID64: id.SteamID64(), AccountID: id.Steam3()}
+	err := db.QueryRow("SELECT created_at, last_login FROM users WHERE steam_id = ?;", PlayerKey(id)).Scan(
+		&user.CreatedAt, &user.LastLogin)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, ErrUserNotFound
+	}
+	return user, err
+}
+
+// GetPlayerStats totals the sessions of an account
+func GetPlayerStats(id steamid.ID) (models.PlayerStats, error) {
+	var stats models.PlayerStats
+	err := db.QueryRow(`
+	SELECT COUNT(s.id), COALESCE(SUM(s.duration), 0), COALESCE(MAX(s.duration), 0),
+		COALESCE(MIN(s.connect_time), ''), COALESCE(MAX(s.connect_time), '')
+	FROM player_sessions s
+	WHERE s.steam_id = ?;`, PlayerKey(id)).Scan(
+		&stats.Sessions, &stats.TotalTime, &stats.LongestSession, &stats.FirstSeen, &stats.LastSeen)
+	if err != nil {
+		log.Printf("Error querying player stats for %d: %v", id, err)
+	}
+	return stats, err
+}
+
+// GetPlayerSessions returns the last sessions of an account, newest first, all of them if limit is negative
+func GetPlayerSessions(id steamid.ID, limit int) ([]models.PlayerSession, error) {
+	rows, err := db.Query(`
+	SELECT s.steam_id, s.connect_time, COALESCE(s.disconnect_time, ''), COALESCE(s.duration, 0),
+		COALESCE(s.public_ip, ''), COALESCE(s.end_reason, '')
+	FROM player_sessions s
+	WHERE s.steam_id = ?
+	ORDER BY s.connect_time DESC
+	LIMIT ?;`, PlayerKey(id), limit)
+	if err != nil {
+		log.Printf("Error querying player sessions for %d: %v", id, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.PlayerSession
+	for rows.Next() {
+		var session models.PlayerSession
+		err := rows.Scan(
+			&session.SteamID,
+			&session.ConnectTime,
+			&session.DisconnectTime,
+			&session.Duration,
+			&session.PublicIP,
+			&session.EndReason,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
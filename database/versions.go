@@ -0,0 +1,85 @@
+package database
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/sawatkins/tf2dl-servers/notify"
+	"github.com/sawatkins/tf2dl-servers/parser"
+)
+
+// outdatedServers is whether each server IP was outdated at its last poll, guarded by pollStatusMu
+var outdatedServers = map[string]bool{}
+
+// checkVersion remembers the game version a server reported if it hasn't been seen before,
+// and sends an alert when the server falls behind the newest version seen on the fleet
+func checkVersion(ip string, status parser.Status, at time.Time) error {
+	if status.Version > 0 {
+		result, err := db.Exec(`
+		INSERT OR IGNORE INTO game_versions (version, first_seen, first_server) VALUES (?, ?, ?);`,
+			status.Version, formatTime(at), ip)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			log.Printf("Game version %d first seen on %s", status.Version, ip)
+		}
+	}
+
+	newest, err := newestVersion()
+	if err != nil {
+		return err
+	}
+
+	pollStatusMu.Lock()
+	defer pollStatusMu.Unlock()
+
+	outdated := isOutdated(ip, status.Version, newest)
+	if outdated && !outdatedServers[ip] {
+		log.Printf("Server %s is outdated on version %d, newest is %d", ip, status.Version, newest)
+		Notifier.Send(notify.Alert{Event: notify.EventServerOutdated, Data: map[string]string{
+			"Server":  serverLabel(ip),
+			"Address": ip,
+			"Version": strconv.Itoa(status.Version),
+			"Newest":  strconv.Itoa(newest),
+		}})
+	} else if !outdated && outdatedServers[ip] {
+		log.Printf("Server %s is up to date on version %d", ip, status.Version)
+	}
+	outdatedServers[ip] = outdated
+
+	return nil
+}
+
+// newestVersion returns the newest game version any server has reported, 0 if none has
+func newestVersion() (int, error) {
+	var newest int
+	err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM game_versions;").Scan(&newest)
+	return newest, err
+}
+
+// isOutdated reports whether the server at ip is behind the newest version, or said it is
+// outdated at its last poll. pollStatusMu must be held.
+func isOutdated(ip string, version, newest int) bool {
+	return liveStatuses[ip].Outdated || (version > 0 && version < newest)
+}
+
+// ServerVersion returns the game version a server reported at its last successful poll,
+// 0 if unknown, and whether it is outdated
+func ServerVersion(ip string) (version int, outdated bool, err error) {
+	err = db.QueryRow("SELECT version FROM servers WHERE public_ip = ?;", ip).Scan(&version)
+	if err != nil {
+		log.Printf("Error querying version of IP %s: %v", ip, err)
+		return 0, false, err
+	}
+	newest, err := newestVersion()
+	if err != nil {
+		log.Printf("Error querying newest version: %v", err)
+		return 0, false, err
+	}
+
+	pollStatusMu.Lock()
+	defer pollStatusMu.Unlock()
+	return version, isOutdated(ip, version, newest), nil
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/sawatkins/tf2dl-servers/auth"
+	"github.com/sawatkins/tf2dl-servers/database"
+	"github.com/sawatkins/tf2dl-servers/models"
+	"github.com/sawatkins/tf2dl-servers/steamid"
+)
+
+const sessionCookie = "tf2dl_session"
+
+var (
+	OpenID    *auth.OpenID
+	Sessions  *auth.Sessions
+	PublicURL string // the site's URL players sign in to, taken from the request if empty
+)
+
+// LoadUser makes the signed in player, if any, available to later handlers and templates as "User"
+func LoadUser(c *fiber.Ctx) error {
+	if Sessions == nil {
+		return c.Next()
+	}
+	value := c.Cookies(sessionCookie)
+	if value == "" {
+		return c.Next()
+	}
+	id, err := Sessions.Decode(value)
+	if err != nil {
+		clearSession(c)
+		return c.Next()
+	}
+	user, err := database.GetUser(id)
+	if err != nil {
+		if !errors.Is(err, database.ErrUserNotFound) {
+			log.Printf("Error getting user %d: %v", id, err)
+		}
+		return c.Next()
+	}
+	c.Locals("User", user)
+	return c.Next()
+}
+
+func currentUser(c *fiber.Ctx) (models.User, bool) {
+	user, ok := c.Locals("User").(models.User)
+	return user, ok
+}
+
+// Login sends the player to Steam to sign in
+func Login(c *fiber.Ctx) error {
+	if OpenID == nil {
+		return c.Status(503).SendString("Sign in is disabled")
+	}
+	realm := siteURL(c)
+	return c.Redirect(OpenID.AuthURL(realm, realm+"/login/callback"))
+}
+
+// LoginCallback verifies the assertion Steam sends the player back with and starts their session
+func LoginCallback(c *fiber.Ctx) error {
+	if OpenID == nil || Sessions == nil {
+		return c.Status(503).SendString("Sign in is disabled")
+	}
+
+	params := url.Values{}
+	c.Request().URI().QueryArgs().VisitAll(func(key, value []byte) {
+		params.Add(string(key), string(value))
+	})
+	id, err := OpenID.Verify(c.UserContext(), siteURL(c)+"/login/callback", params)
+	if errors.Is(err, auth.ErrCancelled) {
+		return c.Redirect("/")
+	}
+	if err != nil {
+		log.Printf("Error verifying Steam sign in: %v", err)
+		return c.Status(401).SendString("Steam sign in could not be verified")
+	}
+
+	if _, err := database.SaveUser(id); err != nil {
+		return c.Status(500).SendString("Error saving user")
+	}
+	c.Cookie(&fiber.Cookie{
+		Name:     sessionCookie,
+		Value:    Sessions.Encode(id),
+		Path:     "/",
+		Expires:  time.Now().Add(Sessions.MaxAge),
+		Secure:   strings.HasPrefix(siteURL(c), "https://"),
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+	return c.Redirect("/me")
+}
+
+func Logout(c *fiber.Ctx) error {
+	clearSession(c)
+	return c.Redirect("/")
+}
+
+func clearSession(c *fiber.Ctx) {
+	c.Cookie(&fiber.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+// siteURL is the scheme and host players reach the site on, without a trailing slash
+func siteURL(c *fiber.Ctx) string {
+	if PublicURL != "" {
+		return strings.TrimSuffix(PublicURL, "/")
+	}
+	return c.BaseURL()
+}
+
+// MyStats renders the signed in player's sessions
+func MyStats(c *fiber.Ctx) error {
+	user, ok := currentUser(c)
+	if !ok {
+		return c.Redirect("/login")
+	}
+
+	id := steamid.ID(user.SteamID64)
+	stats, err := database.GetPlayerStats(id)
+	if err != nil {
+		return c.Status(500).SendString("Error getting player stats")
+	}
+	player, err := database.GetPlayer(id)
+	if err != nil && !errors.Is(err, database.ErrPlayerNotFound) {
+		return c.Status(500).SendString("Error getting player")
+	}
+	recentSessions, err := database.GetPlayerSessions(id, 20)
+	if err != nil {
+		return c.Status(500).SendString("Error getting player sessions")
+	}
+	optedOut, err := database.LeaderboardOptedOut(id)
+	if err != nil {
+		return c.Status(500).SendString("Error getting leaderboard opt out")
+	}
+	states, err := database.GetServerStates()
+	if err != nil {
+		return c.Status(500).SendString("Error getting servers")
+	}
+	serverNames := map[string]string{}
+	for _, state := range states {
+		serverNames[state.PublicIP], _ = database.DisplayDefaults(state.Region, state.DisplayName, state.Flag)
+	}
+
+	type playerSessionRow struct {
+		sessionRow
+		Server string
+	}
+	var sessions []playerSessionRow
+	for _, session := range recentSessions {
+		server := serverNames[session.PublicIP]
+		if server == "" {
+			server = "a removed server"
+		}
+		sessions = append(sessions, playerSessionRow{
+			sessionRow: sessionRow{
+				Connected: formatTimestamp(session.ConnectTime),
+				Duration:  formatDuration(time.Duration(session.Duration) * time.Second),
+				EndReason: session.EndReason,
+			},
+			Server: server,
+		})
+	}
+
+	return c.Render("stats", fiber.Map{
+		"Title":          "My stats - servers.tf2dl.net",
+		"Canonical":      "https://servers.tf2dl.net/me",
+		"Robots":         "noindex, nofollow",
+		"Description":    "Your playtime on servers.tf2dl.net",
+		"Keywords":       "servers.tf2dl.net, tf2, servers, stats",
+		"User":           user,
+		"Name":           player.LastName,
+		"SteamID":        id,
+		"ProfileURL":     id.ProfileURL(),
+		"Stats":          stats,
+		"TotalTime":      formatDuration(time.Duration(stats.TotalTime) * time.Second),
+		"LongestSession": formatDuration(time.Duration(stats.LongestSession) * time.Second),
+		"FirstSeen":      formatTimestamp(stats.FirstSeen),
+		"LastSeen":       formatTimestamp(stats.LastSeen),
+		"Sessions":       sessions,
+		"OptedOut":       optedOut,
+	}, "layouts/main")
+}
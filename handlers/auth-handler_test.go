@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/template/html/v2"
+
+	"github.com/sawatkins/tf2dl-servers/auth"
+	"github.com/sawatkins/tf2dl-servers/auth/authtest"
+	"github.com/sawatkins/tf2dl-servers/database"
+)
+
+// Test signing in through a stand-in Steam provider and viewing the player's stats
+func TestSteamLogin(t *testing.T) {
+	database.InitDB(":memory:")
+	t.Cleanup(database.Close)
+
+	provider := authtest.NewProvider(t)
+	OpenID = auth.NewOpenID(provider.Endpoint())
+	Sessions = auth.NewSessions([]byte("test-secret"))
+	PublicURL = "http://servers.example.com"
+	t.Cleanup(func() {
+		OpenID, Sessions, PublicURL = nil, nil, ""
+	})
+
+	database.ExecuteSQL(`
+		INSERT INTO servers (instance_id, public_ip, name, region, display_name) VALUES ('i-1', '10.0.0.1', 'tf2_server_us', 'us-west', 'Oregon');
+		INSERT INTO player_sessions (steam_id, connect_time, disconnect_time, duration, public_ip)
+		VALUES ('U:1:22202', '2024-08-01T17:00:00Z', '2024-08-01T18:30:00Z', 5400, '10.0.0.1'),
+			('U:1:22203', '2024-08-01T17:00:00Z', '2024-08-01T17:10:00Z', 600, '10.0.0.1');
+		INSERT INTO players (steam_id, first_seen, last_seen, last_name) VALUES ('U:1:22202', '2024-08-01T17:00:00Z', '2024-08-01T18:30:00Z', 'Player One');
+	`)
+
+	engine := html.New("../templates", ".html")
+	app := fiber.New(fiber.Config{Views: engine, PassLocalsToViews: true})
+	app.Use(LoadUser)
+	app.Get("/login", Login)
+	app.Get("/login/callback", LoginCallback)
+	app.Post("/logout", Logout)
+	app.Get("/me", MyStats)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/me", nil))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/login" {
+		t.Errorf("Expected a redirect to sign in, got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/login", nil))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if location := resp.Header.Get("Location"); !strings.HasPrefix(location, provider.Endpoint()+"?") ||
+		!strings.Contains(location, "openid.return_to=http%3A%2F%2Fservers.example.com%2Flogin%2Fcallback") {
+		t.Errorf("Expected a redirect to the provider, got %s", location)
+	}
+
+	returnTo := "http://servers.example.com/login/callback"
+	forged := provider.Assert(returnTo, 76561197960287930)
+	forged.Set("openid.sig", "forged")
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/login/callback?"+forged.Encode(), nil))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized || len(resp.Cookies()) != 0 {
+		t.Errorf("Expected a forged assertion to be rejected, got %d", resp.StatusCode)
+	}
+
+	params := provider.Assert(returnTo, 76561197960287930)
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/login/callback?"+params.Encode(), nil))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	var session *http.Cookie
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == sessionCookie {
+			session = cookie
+		}
+	}
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/me" || session == nil || !session.HttpOnly {
+		t.Fatalf("Expected a session and a redirect to the stats page, got %d %v", resp.StatusCode, resp.Cookies())
+	}
+	if user, err := database.GetUser(76561197960287930); err != nil || user.AccountID != "U:1:22202" {
+		t.Errorf("Expected the user to be saved, got %+v %v", user, err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.AddCookie(session)
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", resp.StatusCode)
+	}
+	for _, expected := range []string{"[U:1:22202]", "STEAM_0:0:11101", "Sessions: <strong>1</strong>", "1h 30m", "on Oregon", "Player One", `action="/logout"`} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Expected the stats page to contain %q", expected)
+		}
+	}
+
+	session.Value = "76561197960287931" + session.Value[17:]
+	req = httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.AddCookie(session)
+	if resp, _ := app.Test(req); resp.StatusCode != http.StatusFound {
+		t.Errorf("Expected a tampered session to be signed out, got %d", resp.StatusCode)
+	}
+}
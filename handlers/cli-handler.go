@@ -1,7 +1,9 @@
 package handlers
 
 import (
-	"os"
+	"errors"
+	"net"
+	"regexp"
 
 	"github.com/gofiber/fiber/v2"
 
@@ -9,22 +11,151 @@ import (
 	"github.com/sawatkins/tf2dl-servers/models"
 )
 
-func PostCurrentServer(c *fiber.Ctx) error {
-	expectedKey := os.Getenv("CLI_AUTH_KEY")
+// CLIAuthKey is the key manage.py sends in the Authorization header, empty rejects every request
+var CLIAuthKey string
+
+// RequireCLIAuth rejects requests that don't carry CLIAuthKey
+func RequireCLIAuth(c *fiber.Ctx) error {
 	requestKey := c.Get("Authorization")
 
-	if expectedKey != requestKey {
+	if CLIAuthKey == "" || CLIAuthKey != requestKey {
 		return c.Status(401).SendString("Unauthorized")
 	}
 
+	return c.Next()
+}
+
+// PostCurrentServer registers the server in the request body. It's kept for copies of manage.py
+// from before PUT /api/servers/:instance_id and needs RequireCLIAuth in front of it.
+func PostCurrentServer(c *fiber.Ctx) error {
 	var newServer models.Server
 	if err := c.BodyParser(&newServer); err != nil {
 		return c.Status(400).SendString("Bad Request: " + err.Error())
 	}
 
+	if newServer.InstanceID == "" {
+		return c.Status(400).SendString("Bad Request: " + errMissingInstanceID.Error())
+	}
+	if err := validateServer(&newServer); err != nil {
+		return c.Status(400).SendString("Bad Request: " + err.Error())
+	}
+
 	if err := database.WriteServerToDB(&newServer); err != nil {
 		return c.Status(500).SendString("Error writing to db: " + err.Error())
 	}
 
 	return c.Status(200).SendString("Success. Server saved to db")
 }
+
+// PutServer registers the server with the instance ID in the path, replacing any existing registration
+func PutServer(c *fiber.Ctx) error {
+	var server models.Server
+	if err := c.BodyParser(&server); err != nil {
+		return c.Status(400).SendString("Bad Request: " + err.Error())
+	}
+	server.InstanceID = c.Params("instance_id")
+
+	if err := validateServer(&server); err != nil {
+		return c.Status(400).SendString("Bad Request: " + err.Error())
+	}
+
+	if err := database.WriteServerToDB(&server); err != nil {
+		return c.Status(500).SendString("Error writing to db: " + err.Error())
+	}
+
+	return sendServer(c, server.InstanceID)
+}
+
+// PatchServer updates only the fields present in the request body
+func PatchServer(c *fiber.Ctx) error {
+	var update models.ServerUpdate
+	if err := c.BodyParser(&update); err != nil {
+		return c.Status(400).SendString("Bad Request: " + err.Error())
+	}
+
+	if err := validateUpdate(&update); err != nil {
+		return c.Status(400).SendString("Bad Request: " + err.Error())
+	}
+
+	err := database.UpdateServer(c.Params("instance_id"), &update)
+	if errors.Is(err, database.ErrServerNotFound) {
+		return c.Status(404).SendString("Server not found")
+	}
+	if err != nil {
+		return c.Status(500).SendString("Error writing to db: " + err.Error())
+	}
+
+	return sendServer(c, c.Params("instance_id"))
+}
+
+// DeleteServer removes the registration of a destroyed instance
+func DeleteServer(c *fiber.Ctx) error {
+	err := database.DeleteServer(c.Params("instance_id"))
+	if errors.Is(err, database.ErrServerNotFound) {
+		return c.Status(404).SendString("Server not found")
+	}
+	if err != nil {
+		return c.Status(500).SendString("Error deleting from db: " + err.Error())
+	}
+
+	return c.Status(200).SendString("Success. Server deleted from db")
+}
+
+func sendServer(c *fiber.Ctx, instanceID string) error {
+	server, err := database.GetServer(instanceID)
+	if err != nil {
+		return c.Status(500).SendString("Error reading from db: " + err.Error())
+	}
+	return c.Status(200).JSON(server)
+}
+
+var (
+	errMissingInstanceID  = errors.New("instance_id is required")
+	errInvalidIP          = errors.New("public_ip is not a valid IP address")
+	errInvalidQueryMethod = errors.New("query_method must be rcon or a2s")
+	errInvalidRegion      = errors.New("region may only contain lowercase letters, digits and hyphens")
+	errInvalidGamePort    = errors.New("game_port must be between 1 and 65535")
+)
+
+// regionPattern matches region codes, which are used in CSS classes and flag paths
+var regionPattern = regexp.MustCompile(`^[a-z0-9-]*$`)
+
+func validateServer(server *models.Server) error {
+	if net.ParseIP(server.PublicIP) == nil {
+		return errInvalidIP
+	}
+	if server.QueryMethod != "" && !validQueryMethod(server.QueryMethod) {
+		return errInvalidQueryMethod
+	}
+	if !regionPattern.MatchString(server.Region) {
+		return errInvalidRegion
+	}
+	if server.GamePort != 0 && !validPort(server.GamePort) {
+		return errInvalidGamePort
+	}
+	return nil
+}
+
+func validateUpdate(update *models.ServerUpdate) error {
+	if update.PublicIP != nil && net.ParseIP(*update.PublicIP) == nil {
+		return errInvalidIP
+	}
+	if update.QueryMethod != nil && !validQueryMethod(*update.QueryMethod) {
+		return errInvalidQueryMethod
+	}
+	if update.Region != nil && !regionPattern.MatchString(*update.Region) {
+		return errInvalidRegion
+	}
+	if update.GamePort != nil && !validPort(*update.GamePort) {
+		return errInvalidGamePort
+	}
+	return nil
+}
+
+func validQueryMethod(queryMethod string) bool {
+	return queryMethod == database.QueryRCON || queryMethod == database.QueryA2S
+}
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sawatkins/tf2dl-servers/database"
+	"github.com/sawatkins/tf2dl-servers/models"
+)
+
+func newServerCRUDApp(t *testing.T) *fiber.App {
+	database.InitDB(":memory:")
+	t.Cleanup(database.Close)
+	CLIAuthKey = "test-key"
+	t.Cleanup(func() { CLIAuthKey = "" })
+
+	app := fiber.New()
+	app.Post("/api/current-servers", RequireCLIAuth, PostCurrentServer)
+	app.Put("/api/servers/:instance_id", RequireCLIAuth, PutServer)
+	app.Patch("/api/servers/:instance_id", RequireCLIAuth, PatchServer)
+	app.Delete("/api/servers/:instance_id", RequireCLIAuth, DeleteServer)
+	return app
+}
+
+func sendJSON(t *testing.T, app *fiber.App, method, url, key, body string) *http.Response {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set("Authorization", key)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	return resp
+}
+
+// Test that registering the same instance twice updates it instead of failing
+func TestPutServer(t *testing.T) {
+	app := newServerCRUDApp(t)
+	body := `{"public_ip": "54.193.198.90", "public_dns": "ec2-1.compute.amazonaws.com", "name": "tf2_server_us", "server_hostname": "surf (us)"}`
+
+	resp := sendJSON(t, app, http.MethodPut, "/api/servers/i-1234567890", "", body)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status code 401 without key, got %d", resp.StatusCode)
+	}
+
+	for i := 0; i < 2; i++ {
+		resp = sendJSON(t, app, http.MethodPut, "/api/servers/i-1234567890", "test-key", body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status code 200 on attempt %d, got %d", i+1, resp.StatusCode)
+		}
+	}
+
+	var server models.Server
+	if err := json.NewDecoder(resp.Body).Decode(&server); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if server.InstanceID != "i-1234567890" || server.PublicIP != "54.193.198.90" || server.QueryMethod != database.QueryRCON {
+		t.Errorf("Unexpected server %+v", server)
+	}
+
+	resp = sendJSON(t, app, http.MethodPut, "/api/servers/i-1234567890", "test-key", `{"public_ip": "not-an-ip"}`)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status code 400 for invalid IP, got %d", resp.StatusCode)
+	}
+}
+
+// Test that the legacy route checks the key and the server like PUT does
+func TestPostCurrentServer(t *testing.T) {
+	app := newServerCRUDApp(t)
+	body := `{"instance_id": "i-1", "public_ip": "54.193.198.90", "name": "tf2_server_us"}`
+
+	CLIAuthKey = ""
+	if resp := sendJSON(t, app, http.MethodPost, "/api/current-servers", "", body); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status code 401 without a configured key, got %d", resp.StatusCode)
+	}
+	CLIAuthKey = "test-key"
+
+	for _, invalid := range []string{
+		`{"public_ip": "54.193.198.90"}`,
+		`{"instance_id": "i-1", "public_ip": "not-an-ip"}`,
+		`{"instance_id": "i-1", "public_ip": "54.193.198.90", "query_method": "telnet"}`,
+	} {
+		if resp := sendJSON(t, app, http.MethodPost, "/api/current-servers", "test-key", invalid); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status code 400 for %s, got %d", invalid, resp.StatusCode)
+		}
+	}
+
+	if resp := sendJSON(t, app, http.MethodPost, "/api/current-servers", "test-key", body); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", resp.StatusCode)
+	}
+	if server, err := database.GetServer("i-1"); err != nil || server.PublicIP != "54.193.198.90" {
+		t.Errorf("Expected the server to be saved, got %+v %v", server, err)
+	}
+}
+
+// Test partial updates and deletion
+func TestPatchAndDeleteServer(t *testing.T) {
+	app := newServerCRUDApp(t)
+	sendJSON(t, app, http.MethodPut, "/api/servers/i-1", "test-key", `{"public_ip": "54.193.198.90", "name": "tf2_server_us"}`)
+
+	resp := sendJSON(t, app, http.MethodPatch, "/api/servers/i-1", "test-key", `{"query_method": "a2s"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", resp.StatusCode)
+	}
+	server, err := database.GetServer("i-1")
+	if err != nil {
+		t.Fatalf("Failed to get server: %v", err)
+	}
+	if server.QueryMethod != database.QueryA2S || server.Name != "tf2_server_us" {
+		t.Errorf("Expected only query_method to change, got %+v", server)
+	}
+
+	resp = sendJSON(t, app, http.MethodPatch, "/api/servers/i-1", "test-key", `{"query_method": "telnet"}`)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status code 400 for invalid query method, got %d", resp.StatusCode)
+	}
+
+	resp = sendJSON(t, app, http.MethodPatch, "/api/servers/i-missing", "test-key", `{"name": "x"}`)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status code 404, got %d", resp.StatusCode)
+	}
+
+	resp = sendJSON(t, app, http.MethodDelete, "/api/servers/i-1", "test-key", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code 200, got %d", resp.StatusCode)
+	}
+	if _, err := database.GetServer("i-1"); err != database.ErrServerNotFound {
+		t.Errorf("Expected server to be deleted, got %v", err)
+	}
+
+	resp = sendJSON(t, app, http.MethodDelete, "/api/servers/i-1", "test-key", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status code 404 for second delete, got %d", resp.StatusCode)
+	}
+}
+
+// Test that display metadata is stored on registration and can be changed on its own
+func TestServerMetadata(t *testing.T) {
+	app := newServerCRUDApp(t)
+	body := `{"public_ip": "10.0.0.1", "name": "tf2_server_ap", "region": "ap-south", "display_name": "Mumbai",
+		"game_port": 27016, "sort_order": 3}`
+
+	resp := sendJSON(t, app, http.MethodPut, "/api/servers/i-1", "test-key", body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", resp.StatusCode)
+	}
+	server, err := database.GetServer("i-1")
+	if err != nil {
+		t.Fatalf("Failed to get server: %v", err)
+	}
+	if server.Region != "ap-south" || server.DisplayName != "Mumbai" || server.GamePort != 27016 || server.SortOrder != 3 || server.Hidden {
+		t.Errorf("Unexpected server %+v", server)
+	}
+
+	resp = sendJSON(t, app, http.MethodPatch, "/api/servers/i-1", "test-key", `{"hidden": true, "sort_order": 0}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", resp.StatusCode)
+	}
+	server, _ = database.GetServer("i-1")
+	if !server.Hidden || server.SortOrder != 0 || server.Region != "ap-south" {
+		t.Errorf("Expected only hidden and sort_order to change, got %+v", server)
+	}
+
+	for _, body := range []string{
+		`{"public_ip": "10.0.0.1", "region": "AP South"}`,
+		`{"public_ip": "10.0.0.1", "game_port": 70000}`,
+	} {
+		resp := sendJSON(t, app, http.MethodPut, "/api/servers/i-1", "test-key", body)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status code 400 for %s, got %d", body, resp.StatusCode)
+		}
+	}
+	resp = sendJSON(t, app, http.MethodPatch, "/api/servers/i-1", "test-key", `{"game_port": 0}`)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status code 400 for game port 0, got %d", resp.StatusCode)
+	}
+}
@@ -1,8 +1,12 @@
 package handlers
 
 import (
+	"errors"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/sawatkins/tf2dl-servers/database"
+	"github.com/sawatkins/tf2dl-servers/models"
+	"github.com/sawatkins/tf2dl-servers/updater"
 )
 
 func NotFound(c *fiber.Ctx) error {
@@ -18,6 +22,10 @@ func Index(c *fiber.Ctx) error {
 	lastPlayerTimeTotal := database.GetLastPlayerTime()
 	lastPlayerHrs := lastPlayerTimeTotal / 60
 	lastPlayerMin := lastPlayerTimeTotal % 60
+	servers, err := database.GetServerStates()
+	if err != nil {
+		return c.Status(500).SendString("Error getting servers")
+	}
 
 	return c.Render("index", fiber.Map{
 		"Title":               "Simple TF2 Surf Servers - servers.tf2dl.net",
@@ -30,6 +38,7 @@ func Index(c *fiber.Ctx) error {
 		"TotalTimePlayedMins": timePlayedMin,
 		"LastPlayerTimeHrs":   lastPlayerHrs,
 		"LastPlayerTimeMin":   lastPlayerMin,
+		"Servers":             servers,
 	}, "layouts/main")
 }
 
@@ -40,6 +49,7 @@ func About(c *fiber.Ctx) error {
 		"Robots":      "index, follow",
 		"Description": "About servers.tf2dl.net",
 		"Keywords":    "servers.tf2dl.net, tf2, servers, hosting, game, server, hosting",
+		"HashedIDs":   database.HashesPlayerIDs(),
 	}, "layouts/main")
 }
 
@@ -58,9 +68,68 @@ func GetServerInfo(c *fiber.Ctx) error {
 	}
 
 	serverInfo, err := database.GetServerInfo(ip)
+	if errors.Is(err, database.ErrServerNotFound) {
+		return c.Status(404).SendString("Server not found")
+	}
 	if err != nil {
 		return c.Status(500).SendString("Error getting server info")
 	}
 
 	return c.Status(200).JSON(serverInfo)
 }
+
+type serverListing struct {
+	models.ServerState
+	Status   string          `json:"status"`           // online or offline
+	LastSeen int64           `json:"last_seen"`        // unix seconds of the last successful poll, 0 if never reached
+	Update   *updater.Status `json:"update,omitempty"` // latest game update, nil if there hasn't been one
+}
+
+// GetServers returns every registered server in one response
+func GetServers(c *fiber.Ctx) error {
+	listings, err := getServerListings()
+	if err != nil {
+		return c.Status(500).SendString("Error getting servers")
+	}
+	return c.Status(200).JSON(listings)
+}
+
+// GetServer returns one registered server by instance ID
+func GetServer(c *fiber.Ctx) error {
+	listings, err := getServerListings()
+	if err != nil {
+		return c.Status(500).SendString("Error getting servers")
+	}
+	for _, listing := range listings {
+		if listing.InstanceID == c.Params("instance_id") {
+			return c.Status(200).JSON(listing)
+		}
+	}
+	return c.Status(404).SendString("Server not found")
+}
+
+func getServerListings() ([]serverListing, error) {
+	states, err := database.GetServerStates()
+	if err != nil {
+		return nil, err
+	}
+	lastSeen, err := database.GetLastSeen()
+	if err != nil {
+		return nil, err
+	}
+
+	listings := make([]serverListing, 0, len(states))
+	for _, state := range states {
+		status := "offline"
+		if state.Online {
+			status = "online"
+		}
+		listings = append(listings, serverListing{
+			ServerState: state,
+			Status:      status,
+			LastSeen:    lastSeen[state.PublicIP],
+			Update:      updateStatus(state.PublicIP),
+		})
+	}
+	return listings, nil
+}
@@ -1,11 +1,15 @@
 package handlers
 
 import (
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/etag"
 	"github.com/gofiber/template/html/v2"
 	"github.com/sawatkins/tf2dl-servers/database"
 )
@@ -37,7 +41,6 @@ func TestNotFound(t *testing.T) {
 // Test Index route existance, status code, and content-type
 func TestIndex(t *testing.T) {
 	database.InitDB(":memory:")
-	database.InitPlayerSessionTable()
 
 	engine := html.New("../templates", ".html")
 	app := fiber.New(fiber.Config{Views: engine})
@@ -59,6 +62,22 @@ func TestIndex(t *testing.T) {
 	if contentType := resp.Header.Get("Content-Type"); contentType != "text/html; charset=utf-8" {
 		t.Errorf("Expected content type 'text/html; charset=utf-8', got '%s'", contentType)
 	}
+
+	// Servers are rendered into the table with their display metadata
+	database.ExecuteSQL(`
+		INSERT INTO servers (instance_id, public_ip, name, map, players, max_players, region, display_name, game_port)
+		VALUES ('i-1234567890', '10.0.0.1', 'tf2_server_ap', 'cp_badlands', 3, 24, 'ap-south', 'Mumbai', 27016);
+	`)
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	for _, expected := range []string{`class="region ap-south"`, `src="/img/ap-south.svg"`, "Mumbai", "steam://connect/10.0.0.1:27016", "3/24"} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Expected page to contain %q", expected)
+		}
+	}
 }
 
 // Test About route existance, status code, and content-type
@@ -83,12 +102,22 @@ func TestAbout(t *testing.T) {
 	if contentType := resp.Header.Get("Content-Type"); contentType != "text/html; charset=utf-8" {
 		t.Errorf("Expected content type 'text/html; charset=utf-8', got '%s'", contentType)
 	}
+	// the privacy section only claims ids are hashed in privacy mode
+	database.PrivacySecret = []byte("privacy-secret")
+	t.Cleanup(func() { database.PrivacySecret = nil })
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/about", nil))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "hashed Steam IDs of connected users are recorded") {
+		t.Errorf("Expected the about page to say ids are hashed")
+	}
 }
 
 // Test server ip list endpoint
 func TestGetServerIPs(t *testing.T) {
 	database.InitDB(":memory:")
-	database.InitServerTable()
 
 	engine := html.New("../templates", ".html")
 	app := fiber.New(fiber.Config{Views: engine})
@@ -153,7 +182,6 @@ func TestGetServerIPs(t *testing.T) {
 // Test single server info endpoint
 func TestGetServerInfo(t *testing.T) {
 	database.InitDB(":memory:")
-	database.InitServerTable()
 
 	engine := html.New("../templates", ".html")
 	app := fiber.New(fiber.Config{Views: engine})
@@ -204,8 +232,8 @@ func TestGetServerInfo(t *testing.T) {
 		t.Fatalf("Failed to send request: %v", err)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		t.Errorf("Expected status code 200, got %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status code 404, got %d", resp.StatusCode)
 	}
 
 	// Test database error scenario
@@ -222,3 +250,95 @@ func TestGetServerInfo(t *testing.T) {
 		t.Errorf("Expected status code 500, got %d", resp.StatusCode)
 	}
 }
+
+// Test the batch server list, ETag revalidation and lookup of single servers
+func TestGetServers(t *testing.T) {
+	database.InitDB(":memory:")
+	defer database.Close()
+
+	app := fiber.New()
+	app.Get("/api/servers", etag.New(), GetServers)
+	app.Get("/api/servers/:instance_id", etag.New(), GetServer)
+
+	database.ExecuteSQL(`
+		INSERT INTO servers (
+			instance_id, public_ip, public_dns, name, server_hostname, map, players, max_players, region, game_port, sort_order, hidden
+		) VALUES
+		('i-1234567890', '192.168.1.1', 'ec2-1.compute.amazonaws.com', 'Server1', 'TF2 Server 1', 'cp_badlands', 3, 24, 'eu-central', 27015, 2, 0),
+		('i-0987654321', '54.193.198.90', 'ec2-2.compute.amazonaws.com', 'Server2', '', 'pl_upward', 0, 24, 'us-west', 27016, 1, 0),
+		('i-1111111111', '192.168.1.3', 'ec2-3.compute.amazonaws.com', 'Server3', '', 'cp_dustbowl', 0, 24, 'ap-south', 27015, 0, 1);
+		INSERT INTO server_snapshots (public_ip, resolution, taken_at, map, players, peak_players, max_players, bots, reachable)
+		VALUES ('192.168.1.1', 0, 1792000000, 'cp_badlands', 3, 3, 24, 0, 1);
+	`)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/servers", nil)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", resp.StatusCode)
+	}
+
+	var listings []serverListing
+	if err := json.NewDecoder(resp.Body).Decode(&listings); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(listings) != 2 {
+		t.Fatalf("Expected the 2 visible servers, got %d", len(listings))
+	}
+	if listings[0].InstanceID != "i-0987654321" {
+		t.Errorf("Expected servers in sort order, got %s first", listings[0].InstanceID)
+	}
+	for _, listing := range listings {
+		switch listing.InstanceID {
+		case "i-1234567890":
+			if listing.Hostname != "TF2 Server 1" || listing.Players != 3 || listing.LastSeen != 1792000000 ||
+				listing.Connect != "192.168.1.1:27015" || listing.Status != "offline" || listing.Region != "eu-central" {
+				t.Errorf("Unexpected listing %+v", listing)
+			}
+		case "i-0987654321":
+			if listing.Hostname != "Server2" || listing.LastSeen != 0 || listing.Connect != "54.193.198.90:27016" ||
+				listing.DisplayName != "us-west" || listing.Flag != "/img/us-west.svg" {
+				t.Errorf("Unexpected listing %+v", listing)
+			}
+		}
+	}
+
+	etagValue := resp.Header.Get("ETag")
+	if etagValue == "" {
+		t.Fatalf("Expected an ETag header")
+	}
+	req = httptest.NewRequest(http.MethodGet, "/api/servers", nil)
+	req.Header.Set("If-None-Match", etagValue)
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected status code 304, got %d", resp.StatusCode)
+	}
+
+	database.ExecuteSQL("UPDATE servers SET players = 4 WHERE instance_id = 'i-1234567890';")
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code 200 after a change, got %d", resp.StatusCode)
+	}
+
+	for path, expected := range map[string]int{
+		"/api/servers/i-1234567890": http.StatusOK,
+		"/api/servers/i-missing":    http.StatusNotFound,
+		"/api/servers/i-1111111111": http.StatusNotFound,
+	} {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil))
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if resp.StatusCode != expected {
+			t.Errorf("Expected status code %d for %s, got %d", expected, path, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/sawatkins/tf2dl-servers/database"
+)
+
+// ReadyPollIntervals is how many poll intervals may pass without a finished
+// UpdateServerInfo cycle before the backend stops reporting ready
+var ReadyPollIntervals = 3
+
+const (
+	checkOK   = "ok"
+	checkFail = "fail"
+)
+
+type componentCheck struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type readiness struct {
+	Status     string                    `json:"status"`
+	Components map[string]componentCheck `json:"components"`
+}
+
+// Healthz reports that the process is up and serving requests
+func Healthz(c *fiber.Ctx) error {
+	return c.Status(200).JSON(fiber.Map{"status": checkOK})
+}
+
+// Readyz reports whether the database, the poller and the game servers are working,
+// with 503 if any of them isn't
+func Readyz(c *fiber.Ctx) error {
+	result := readiness{
+		Status: checkOK,
+		Components: map[string]componentCheck{
+			"database": checkDatabase(),
+			"poller":   checkPoller(),
+			"servers":  checkServers(),
+		},
+	}
+
+	status := 200
+	for _, check := range result.Components {
+		if check.Status != checkOK {
+			result.Status = checkFail
+			status = 503
+		}
+	}
+
+	return c.Status(status).JSON(result)
+}
+
+func checkDatabase() componentCheck {
+	if err := database.Ping(); err != nil {
+		return componentCheck{Status: checkFail, Detail: err.Error()}
+	}
+	return componentCheck{Status: checkOK}
+}
+
+func checkPoller() componentCheck {
+	last := database.LastPollCycle()
+	if last.IsZero() {
+		return componentCheck{Status: checkFail, Detail: "no poll cycle has finished yet"}
+	}
+
+	age := time.Since(last).Truncate(time.Second)
+	detail := fmt.Sprintf("last poll cycle finished %s ago", age)
+	if age > time.Duration(ReadyPollIntervals)*database.PollInterval {
+		return componentCheck{Status: checkFail, Detail: detail}
+	}
+	return componentCheck{Status: checkOK, Detail: detail}
+}
+
+func checkServers() componentCheck {
+	servers, err := database.FleetState()
+	if err != nil {
+		return componentCheck{Status: checkFail, Detail: err.Error()}
+	}
+
+	reachable := 0
+	for _, server := range servers {
+		if server.Reachable {
+			reachable++
+		}
+	}
+
+	detail := fmt.Sprintf("%d of %d servers reachable", reachable, len(servers))
+	if reachable == 0 {
+		return componentCheck{Status: checkFail, Detail: detail}
+	}
+	return componentCheck{Status: checkOK, Detail: detail}
+}
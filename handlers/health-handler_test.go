@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sawatkins/tf2dl-servers/database"
+)
+
+func getReadiness(t *testing.T, app *fiber.App) (int, readiness) {
+	t.Helper()
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	var result readiness
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return resp.StatusCode, result
+}
+
+// Test readiness per component as the poller and database change state
+func TestReadyz(t *testing.T) {
+	database.InitDB(":memory:")
+	t.Cleanup(database.Close)
+
+	app := fiber.New()
+	app.Get("/healthz", Healthz)
+	app.Get("/readyz", Readyz)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code 200 from healthz, got %d", resp.StatusCode)
+	}
+
+	status, result := getReadiness(t, app)
+	if status != http.StatusServiceUnavailable || result.Status != checkFail {
+		t.Errorf("Expected not ready before the first poll, got %d %+v", status, result)
+	}
+	if result.Components["database"].Status != checkOK || result.Components["poller"].Status != checkFail {
+		t.Errorf("Unexpected components %+v", result.Components)
+	}
+
+	connections := map[string]map[string]int64{}
+	database.UpdateServerInfo(&connections)
+
+	status, result = getReadiness(t, app)
+	if result.Components["poller"].Status != checkOK {
+		t.Errorf("Expected poller to be ok after a poll cycle, got %+v", result.Components["poller"])
+	}
+	// no servers are registered, so none can be reachable
+	if status != http.StatusServiceUnavailable || result.Components["servers"].Detail != "0 of 0 servers reachable" {
+		t.Errorf("Expected servers check to fail, got %d %+v", status, result.Components["servers"])
+	}
+
+	database.Close()
+	_, result = getReadiness(t, app)
+	if result.Components["database"].Status != checkFail {
+		t.Errorf("Expected database check to fail once closed, got %+v", result.Components["database"])
+	}
+}
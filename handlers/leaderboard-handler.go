@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/sawatkins/tf2dl-servers/database"
+	"github.com/sawatkins/tf2dl-servers/models"
+	"github.com/sawatkins/tf2dl-servers/steamid"
+)
+
+const (
+	defaultLeaderboardLimit = 25
+	maxLeaderboardLimit     = 100
+)
+
+var leaderboardMetrics = []struct{ Metric, Label string }{
+	{database.MetricPlaytime, "Playtime"},
+	{database.MetricSessions, "Sessions"},
+	{database.MetricLongestSession, "Longest session"},
+	{database.MetricStreak, "Streak"},
+}
+
+var leaderboardPeriods = []struct {
+	Period string
+	Label  string
+	Span   time.Duration // 0 for all time
+}{
+	{"all", "All time", 0},
+	{"30d", "30 days", 30 * 24 * time.Hour},
+	{"7d", "7 days", 7 * 24 * time.Hour},
+}
+
+type leaderboardQuery struct {
+	Metric string
+	Period string
+	Server string // instance ID, empty for every server
+	ip     string
+	since  time.Time
+	limit  int
+}
+
+// parseLeaderboardQuery reads the metric, period, server and limit query parameters
+func parseLeaderboardQuery(c *fiber.Ctx) (leaderboardQuery, *fiber.Error) {
+	query := leaderboardQuery{
+		Metric: c.Query("metric", database.MetricPlaytime),
+		Period: c.Query("period", "all"),
+		Server: c.Query("server"),
+		limit:  defaultLeaderboardLimit,
+	}
+
+	known := false
+	for _, metric := range leaderboardMetrics {
+		known = known || metric.Metric == query.Metric
+	}
+	if !known {
+		return query, fiber.NewError(400, "Invalid metric, must be playtime, sessions, longest_session or streak")
+	}
+
+	known = false
+	for _, period := range leaderboardPeriods {
+		if period.Period == query.Period {
+			known = true
+			if period.Span > 0 {
+				query.since = time.Now().UTC().Add(-period.Span)
+			}
+		}
+	}
+	if !known {
+		return query, fiber.NewError(400, "Invalid period, must be all, 30d or 7d")
+	}
+
+	if query.Server != "" {
+		server, err := database.GetServer(query.Server)
+		if errors.Is(err, database.ErrServerNotFound) || (err == nil && server.Hidden) {
+			return query, fiber.NewError(404, "Server not found")
+		}
+		if err != nil {
+			return query, fiber.NewError(500, "Error getting server")
+		}
+		query.ip = server.PublicIP
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxLeaderboardLimit {
+			return query, fiber.NewError(400, fmt.Sprintf("Invalid limit, must be between 1 and %d", maxLeaderboardLimit))
+		}
+		query.limit = limit
+	}
+	return query, nil
+}
+
+type leaderboard struct {
+	Metric  string                    `json:"metric"`
+	Period  string                    `json:"period"`
+	Server  string                    `json:"server,omitempty"` // instance ID
+	Entries []models.LeaderboardEntry `json:"entries"`
+}
+
+// GetLeaderboard ranks players by ?metric= over ?period=, optionally on one ?server=
+func GetLeaderboard(c *fiber.Ctx) error {
+	query, ferr := parseLeaderboardQuery(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).SendString(ferr.Message)
+	}
+	entries, err := database.GetLeaderboard(query.Metric, query.ip, query.since, query.limit)
+	if err != nil {
+		return c.Status(500).SendString("Error getting leaderboard")
+	}
+	if entries == nil {
+		entries = []models.LeaderboardEntry{}
+	}
+	return c.Status(200).JSON(leaderboard{
+		Metric:  query.Metric,
+		Period:  query.Period,
+		Server:  query.Server,
+		Entries: entries,
+	})
+}
+
+type leaderboardLink struct {
+	Label  string
+	URL    string
+	Active bool
+}
+
+type leaderboardRow struct {
+	Rank       int
+	Name       string
+	ProfileURL string // empty in privacy mode
+	Value      string
+}
+
+// Leaderboard renders the leaderboard page, which takes the same query parameters as GetLeaderboard
+func Leaderboard(c *fiber.Ctx) error {
+	query, ferr := parseLeaderboardQuery(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).SendString(ferr.Message)
+	}
+	entries, err := database.GetLeaderboard(query.Metric, query.ip, query.since, query.limit)
+	if err != nil {
+		return c.Status(500).SendString("Error getting leaderboard")
+	}
+	states, err := database.GetServerStates()
+	if err != nil {
+		return c.Status(500).SendString("Error getting servers")
+	}
+
+	link := func(label string, metric, period, server string) leaderboardLink {
+		params := url.Values{"metric": {metric}, "period": {period}}
+		if server != "" {
+			params.Set("server", server)
+		}
+		return leaderboardLink{
+			Label:  label,
+			URL:    "/leaderboard?" + params.Encode(),
+			Active: metric == query.Metric && period == query.Period && server == query.Server,
+		}
+	}
+	var metrics, periods, servers []leaderboardLink
+	metricLabel := ""
+	for _, metric := range leaderboardMetrics {
+		metrics = append(metrics, link(metric.Label, metric.Metric, query.Period, query.Server))
+		if metric.Metric == query.Metric {
+			metricLabel = metric.Label
+		}
+	}
+	for _, period := range leaderboardPeriods {
+		periods = append(periods, link(period.Label, query.Metric, period.Period, query.Server))
+	}
+	servers = append(servers, link("All servers", query.Metric, query.Period, ""))
+	for _, state := range states {
+		servers = append(servers, link(state.DisplayName, query.Metric, query.Period, state.InstanceID))
+	}
+
+	var rows []leaderboardRow
+	for _, entry := range entries {
+		row := leaderboardRow{Rank: entry.Rank, Name: entry.Name, Value: formatLeaderboardValue(query.Metric, entry.Value)}
+		if entry.SteamID64 != 0 {
+			row.ProfileURL = steamid.ID(entry.SteamID64).ProfileURL()
+		}
+		if row.Name == "" {
+			row.Name = "Anonymous player"
+		}
+		rows = append(rows, row)
+	}
+
+	return c.Render("leaderboard", fiber.Map{
+		"Title":       "Leaderboard - servers.tf2dl.net",
+		"Canonical":   "https://servers.tf2dl.net/leaderboard",
+		"Robots":      "index, follow",
+		"Description": "Top players on servers.tf2dl.net by playtime, sessions and streaks",
+		"Keywords":    "servers.tf2dl.net, tf2, servers, leaderboard, stats",
+		"Metrics":     metrics,
+		"MetricLabel": metricLabel,
+		"Periods":     periods,
+		"Servers":     servers,
+		"Rows":        rows,
+	}, "layouts/main")
+}
+
+func formatLeaderboardValue(metric string, value int) string {
+	switch metric {
+	case database.MetricPlaytime, database.MetricLongestSession:
+		return formatDuration(time.Duration(value) * time.Second)
+	case database.MetricStreak:
+		if value == 1 {
+			return "1 day"
+		}
+		return fmt.Sprintf("%d days", value)
+	}
+	return strconv.Itoa(value)
+}
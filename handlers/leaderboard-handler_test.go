@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/template/html/v2"
+
+	"github.com/sawatkins/tf2dl-servers/auth"
+	"github.com/sawatkins/tf2dl-servers/database"
+)
+
+// Test the leaderboard API and page, and a player hiding themselves from it
+func TestLeaderboard(t *testing.T) {
+	database.InitDB(":memory:")
+	t.Cleanup(database.Close)
+
+	Sessions = auth.NewSessions([]byte("test-secret"))
+	t.Cleanup(func() { Sessions = nil })
+
+	database.ExecuteSQL(`
+		INSERT INTO servers (instance_id, public_ip, name, region, display_name) VALUES
+		('i-1', '10.0.0.1', 'tf2_server_us', 'us-west', 'Oregon'),
+		('i-2', '10.0.0.2', 'tf2_server_eu', 'eu-central', 'Frankfurt');
+		INSERT INTO servers (instance_id, public_ip, name, hidden) VALUES ('i-3', '10.0.0.3', 'tf2_server_test', 1);
+		INSERT INTO player_sessions (steam_id, connect_time, disconnect_time, duration, public_ip)
+		VALUES ('U:1:22202', '2024-08-01T17:00:00Z', '2024-08-01T18:30:00Z', 5400, '10.0.0.1'),
+			('U:1:22203', '2024-08-01T17:00:00Z', '2024-08-01T17:10:00Z', 600, '10.0.0.1'),
+			('U:1:22203', '2024-08-02T17:00:00Z', '2024-08-02T17:10:00Z', 600, '10.0.0.2');
+		INSERT INTO players (steam_id, first_seen, last_seen, last_name) VALUES ('U:1:22202', '2024-08-01T17:00:00Z', '2024-08-01T18:30:00Z', 'Player One');
+	`)
+	database.SaveUser(76561197960287930)
+
+	app := fiber.New(fiber.Config{Views: html.New("../templates", ".html"), PassLocalsToViews: true})
+	app.Use(LoadUser)
+	app.Get("/api/leaderboard", GetLeaderboard)
+	app.Get("/leaderboard", Leaderboard)
+	app.Put("/api/players/:steam_id/leaderboard-opt-out", RequirePlayerOrAdmin, OptOutPlayer)
+
+	getLeaderboard := func(query string) leaderboard {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/leaderboard"+query, nil))
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		var board leaderboard
+		if err := json.NewDecoder(resp.Body).Decode(&board); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected a leaderboard for %q, got %d %v", query, resp.StatusCode, err)
+		}
+		return board
+	}
+
+	board := getLeaderboard("")
+	if board.Metric != "playtime" || board.Period != "all" || len(board.Entries) != 2 ||
+		board.Entries[0].SteamID64 != 76561197960287930 || board.Entries[0].Name != "Player One" || board.Entries[0].Value != 5400 {
+		t.Errorf("Expected players ranked by playtime, got %+v", board)
+	}
+	if board := getLeaderboard("?metric=sessions&server=i-2"); len(board.Entries) != 1 || board.Entries[0].SteamID64 != 76561197960287931 {
+		t.Errorf("Expected only the sessions on i-2, got %+v", board)
+	}
+	if board := getLeaderboard("?metric=streak&period=7d"); len(board.Entries) != 0 {
+		t.Errorf("Expected no sessions in the last 7 days, got %+v", board)
+	}
+
+	for query, status := range map[string]int{
+		"?metric=kills":       http.StatusBadRequest,
+		"?period=1y":          http.StatusBadRequest,
+		"?limit=1000":         http.StatusBadRequest,
+		"?server=i-9":         http.StatusNotFound,
+		"?server=i-3":         http.StatusNotFound,
+		"?period=30d":         http.StatusOK,
+		"?limit=1&server=i-1": http.StatusOK,
+	} {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/leaderboard"+query, nil))
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if resp.StatusCode != status {
+			t.Errorf("%s: expected status code %d, got %d", query, status, resp.StatusCode)
+		}
+	}
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/leaderboard?metric=longest_session", nil))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", resp.StatusCode)
+	}
+	for _, expected := range []string{"Player One", "1h 30m", "Anonymous player", "https://steamcommunity.com/profiles/76561197960287930",
+		"Longest session</th>", "metric=longest_session&amp;period=30d", "server=i-2", `class="active">Longest session`} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Expected the leaderboard page to contain %q", expected)
+		}
+	}
+	if strings.Contains(string(body), "i-3") {
+		t.Errorf("Expected hidden servers to be left out of the filters")
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/api/players/76561197960287930/leaderboard-opt-out", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: Sessions.Encode(76561197960287930)})
+	if resp, err := app.Test(req); err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected the player to opt out, got %v %v", resp, err)
+	}
+	if board := getLeaderboard(""); len(board.Entries) != 1 || board.Entries[0].SteamID64 == 76561197960287930 {
+		t.Errorf("Expected the opted out player to be hidden, got %+v", board)
+	}
+}
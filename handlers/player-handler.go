@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/sawatkins/tf2dl-servers/database"
+	"github.com/sawatkins/tf2dl-servers/models"
+	"github.com/sawatkins/tf2dl-servers/steamid"
+)
+
+// RequirePlayerOrAdmin lets through the signed in player whose data is asked for, and requests
+// carrying CLIAuthKey. The account is stored in Locals as "SteamID" for the next handler.
+func RequirePlayerOrAdmin(c *fiber.Ctx) error {
+	id, err := steamid.Parse(c.Params("steam_id"))
+	if err != nil {
+		return c.Status(400).SendString("Invalid Steam ID")
+	}
+	c.Locals("SteamID", id)
+
+	if key := c.Get("Authorization"); key != "" {
+		if CLIAuthKey == "" || key != CLIAuthKey {
+			return c.Status(401).SendString("Unauthorized")
+		}
+		c.Locals("RequestedBy", "admin")
+		return c.Next()
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return c.Status(401).SendString("Unauthorized")
+	}
+	if user.SteamID64 != id.SteamID64() {
+		return c.Status(403).SendString("Forbidden")
+	}
+	c.Locals("RequestedBy", "player")
+	return c.Next()
+}
+
+type playerExport struct {
+	SteamID64 string                 `json:"steam_id64"`
+	SteamID   string                 `json:"steam_id"`
+	Legacy    string                 `json:"steam_id_legacy"`
+	Player    *models.Player         `json:"player"` // nil if they haven't played
+	User      *models.User           `json:"user"`   // nil if they haven't signed in
+	Stats     models.PlayerStats     `json:"stats"`
+	Sessions  []models.PlayerSession `json:"sessions"`
+	OptedOut  bool                   `json:"leaderboard_opt_out"`
+}
+
+// ExportPlayer returns everything stored about an account, as JSON or with ?format=csv its sessions as CSV
+func ExportPlayer(c *fiber.Ctx) error {
+	id := c.Locals("SteamID").(steamid.ID)
+
+	sessions, err := database.GetPlayerSessions(id, -1)
+	if err != nil {
+		return c.Status(500).SendString("Error getting player sessions")
+	}
+	if sessions == nil {
+		sessions = []models.PlayerSession{}
+	}
+	filename := "tf2dl-" + strconv.FormatUint(id.SteamID64(), 10)
+
+	switch c.Query("format", "json") {
+	case "csv":
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+		c.Attachment(filename + ".csv")
+		w := csv.NewWriter(c)
+		w.Write([]string{"steam_id", "connect_time", "disconnect_time", "duration", "public_ip", "end_reason"})
+		for _, session := range sessions {
+			w.Write([]string{
+				id.Steam3(),
+				session.ConnectTime,
+				session.DisconnectTime,
+				strconv.Itoa(session.Duration),
+				session.PublicIP,
+				session.EndReason,
+			})
+		}
+		w.Flush()
+		return w.Error()
+
+	case "json":
+	default:
+		return c.Status(400).SendString("Invalid format, must be json or csv")
+	}
+
+	export := playerExport{
+		SteamID64: strconv.FormatUint(id.SteamID64(), 10),
+		SteamID:   id.String(),
+		Legacy:    id.Legacy(),
+		Sessions:  sessions,
+	}
+	// in privacy mode sessions are stored under a hash, show the id they belong to instead
+	for i := range export.Sessions {
+		export.Sessions[i].SteamID = id.Steam3()
+	}
+
+	player, err := database.GetPlayer(id)
+	if err == nil {
+		export.Player = &player
+	} else if !errors.Is(err, database.ErrPlayerNotFound) {
+		return c.Status(500).SendString("Error getting player")
+	}
+	user, err := database.GetUser(id)
+	if err == nil {
+		export.User = &user
+	} else if !errors.Is(err, database.ErrUserNotFound) {
+		return c.Status(500).SendString("Error getting user")
+	}
+	if export.Stats, err = database.GetPlayerStats(id); err != nil {
+		return c.Status(500).SendString("Error getting player stats")
+	}
+	if export.OptedOut, err = database.LeaderboardOptedOut(id); err != nil {
+		return c.Status(500).SendString("Error getting leaderboard opt out")
+	}
+
+	c.Attachment(filename + ".json")
+	return c.Status(200).JSON(export)
+}
+
+// ErasePlayer deletes an account's sessions, or with ?mode=anonymize keeps them without the id.
+// A player erasing their own data is signed out.
+func ErasePlayer(c *fiber.Ctx) error {
+	id := c.Locals("SteamID").(steamid.ID)
+	mode := c.Query("mode", database.ErasureErase)
+	if mode != database.ErasureErase && mode != database.ErasureAnonymize {
+		return c.Status(400).SendString("Invalid mode, must be erase or anonymize")
+	}
+
+	erasure, err := database.ErasePlayer(id, mode, c.Locals("RequestedBy").(string))
+	if err != nil {
+		return c.Status(500).SendString("Error erasing player data")
+	}
+	if c.Locals("RequestedBy") == "player" {
+		clearSession(c)
+	}
+	return c.Status(200).JSON(erasure)
+}
+
+// OptOutPlayer hides an account from the leaderboards, or with DELETE shows it again
+func OptOutPlayer(c *fiber.Ctx) error {
+	id := c.Locals("SteamID").(steamid.ID)
+	optOut := c.Method() != fiber.MethodDelete
+	if err := database.SetLeaderboardOptOut(id, optOut); err != nil {
+		return c.Status(500).SendString("Error updating leaderboard opt out")
+	}
+	return c.SendStatus(204)
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/sawatkins/tf2dl-servers/auth"
+	"github.com/sawatkins/tf2dl-servers/database"
+	"github.com/sawatkins/tf2dl-servers/models"
+)
+
+// Test that players can export and erase only their own data, and admins anyone's
+func TestPlayerData(t *testing.T) {
+	database.InitDB(":memory:")
+	t.Cleanup(database.Close)
+
+	Sessions = auth.NewSessions([]byte("test-secret"))
+	CLIAuthKey = "test-key"
+	t.Cleanup(func() {
+		Sessions, CLIAuthKey = nil, ""
+	})
+
+	database.ExecuteSQL(`
+		INSERT INTO player_sessions (steam_id, connect_time, disconnect_time, duration, public_ip)
+		VALUES ('U:1:22202', '2024-08-01T17:00:00Z', '2024-08-01T18:30:00Z', 5400, '10.0.0.1'),
+			('U:1:22203', '2024-08-01T17:00:00Z', '2024-08-01T17:10:00Z', 600, '10.0.0.1');
+		INSERT INTO players (steam_id, first_seen, last_seen, last_name) VALUES ('U:1:22202', '2024-08-01T17:00:00Z', '2024-08-01T18:30:00Z', 'Player One');
+	`)
+	database.SaveUser(76561197960287930)
+
+	app := fiber.New()
+	app.Use(LoadUser)
+	app.Get("/api/players/:steam_id/export", RequirePlayerOrAdmin, ExportPlayer)
+	app.Delete("/api/players/:steam_id", RequirePlayerOrAdmin, ErasePlayer)
+
+	session := &http.Cookie{Name: sessionCookie, Value: Sessions.Encode(76561197960287930)}
+	request := func(method, target string, cookie *http.Cookie, key string) *http.Response {
+		req := httptest.NewRequest(method, target, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		if key != "" {
+			req.Header.Set("Authorization", key)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		return resp
+	}
+
+	for _, tc := range []struct {
+		method, target string
+		cookie         *http.Cookie
+		key            string
+		status         int
+	}{
+		{http.MethodGet, "/api/players/76561197960287930/export", nil, "", http.StatusUnauthorized},
+		{http.MethodGet, "/api/players/76561197960287930/export", nil, "wrong-key", http.StatusUnauthorized},
+		{http.MethodGet, "/api/players/76561197960287931/export", session, "", http.StatusForbidden},
+		{http.MethodGet, "/api/players/not-an-id/export", session, "", http.StatusBadRequest},
+		{http.MethodGet, "/api/players/76561197960287930/export?format=xml", session, "", http.StatusBadRequest},
+		{http.MethodDelete, "/api/players/76561197960287930?mode=shred", session, "", http.StatusBadRequest},
+		{http.MethodDelete, "/api/players/76561197960287931", session, "", http.StatusForbidden},
+	} {
+		if resp := request(tc.method, tc.target, tc.cookie, tc.key); resp.StatusCode != tc.status {
+			t.Errorf("%s %s: expected status code %d, got %d", tc.method, tc.target, tc.status, resp.StatusCode)
+		}
+	}
+
+	resp := request(http.MethodGet, "/api/players/[U:1:22202]/export", session, "")
+	var export playerExport
+	if err := json.NewDecoder(resp.Body).Decode(&export); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected a JSON export, got %d %v", resp.StatusCode, err)
+	}
+	if export.SteamID64 != "76561197960287930" || export.Player == nil || export.Player.LastName != "Player One" ||
+		export.User == nil || len(export.Sessions) != 1 || export.Stats.TotalTime != 5400 {
+		t.Errorf("Expected the player's data in the export, got %+v", export)
+	}
+
+	resp = request(http.MethodGet, "/api/players/76561197960287931/export?format=csv", nil, "test-key")
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/csv") ||
+		!strings.Contains(string(body), "U:1:22203,2024-08-01T17:00:00Z,2024-08-01T17:10:00Z,600,10.0.0.1") {
+		t.Errorf("Expected the admin to get a CSV export, got %d %s", resp.StatusCode, body)
+	}
+
+	resp = request(http.MethodDelete, "/api/players/76561197960287930", session, "")
+	var erasure models.Erasure
+	if err := json.NewDecoder(resp.Body).Decode(&erasure); err != nil || resp.StatusCode != http.StatusOK ||
+		erasure.Sessions != 1 || erasure.RequestedBy != "player" {
+		t.Fatalf("Expected the player's data to be erased, got %d %+v %v", resp.StatusCode, erasure, err)
+	}
+	signedOut := false
+	for _, cookie := range resp.Cookies() {
+		signedOut = signedOut || (cookie.Name == sessionCookie && cookie.Value == "")
+	}
+	if !signedOut {
+		t.Errorf("Expected the player to be signed out, got %v", resp.Cookies())
+	}
+	if _, err := database.GetUser(76561197960287930); err != database.ErrUserNotFound {
+		t.Errorf("Expected the user to be removed, got %v", err)
+	}
+	if sessions := database.GetTotalPlayerSessions(); sessions != 2 {
+		t.Errorf("Expected the erased session to still count, got %d sessions", sessions)
+	}
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/sawatkins/tf2dl-servers/database"
+	"github.com/sawatkins/tf2dl-servers/models"
+)
+
+type historyBar struct {
+	Label   string
+	Players int
+	Height  int // percent of the tallest bar
+}
+
+type playerRow struct {
+	Name      string
+	Connected string
+	Ping      int
+}
+
+type sessionRow struct {
+	Connected string
+	Duration  string
+	EndReason string
+}
+
+type mapRow struct {
+	Map     string
+	Started string
+}
+
+// ServerDetail renders the page for one registered server
+func ServerDetail(c *fiber.Ctx) error {
+	server, err := database.GetServer(c.Params("id"))
+	if errors.Is(err, database.ErrServerNotFound) || server.Hidden {
+		return NotFound(c)
+	}
+	if err != nil {
+		return c.Status(500).SendString("Error getting server")
+	}
+
+	ip := server.PublicIP
+	now := time.Now().UTC()
+
+	dayStart := now.Truncate(time.Hour).Add(-23 * time.Hour)
+	day, err := database.GetServerHistory(ip, dayStart, now, time.Hour)
+	if err != nil {
+		return c.Status(500).SendString("Error getting player history")
+	}
+	weekStart := now.Truncate(6 * time.Hour).Add(-27 * 6 * time.Hour)
+	week, err := database.GetServerHistory(ip, weekStart, now, 6*time.Hour)
+	if err != nil {
+		return c.Status(500).SendString("Error getting player history")
+	}
+
+	recentMaps, err := database.GetRecentMaps(ip, 10)
+	if err != nil {
+		return c.Status(500).SendString("Error getting recent maps")
+	}
+	var maps []mapRow
+	for _, change := range recentMaps {
+		maps = append(maps, mapRow{Map: change.Map, Started: formatTimestamp(change.StartedAt)})
+	}
+
+	recentSessions, err := database.GetRecentSessions(ip, 10)
+	if err != nil {
+		return c.Status(500).SendString("Error getting recent sessions")
+	}
+	var sessions []sessionRow
+	for _, session := range recentSessions {
+		sessions = append(sessions, sessionRow{
+			Connected: formatTimestamp(session.ConnectTime),
+			Duration:  formatDuration(time.Duration(session.Duration) * time.Second),
+			EndReason: session.EndReason,
+		})
+	}
+
+	live, online := database.GetLiveStatus(ip)
+	var players []playerRow
+	for _, player := range live.Players {
+		if player.IsBot() {
+			continue
+		}
+		players = append(players, playerRow{
+			Name:      player.Name,
+			Connected: formatDuration(player.Connected),
+			Ping:      player.Ping,
+		})
+	}
+
+	version, outdated, err := database.ServerVersion(ip)
+	if err != nil {
+		return c.Status(500).SendString("Error getting server version")
+	}
+
+	uptime := ""
+	if pollStatus, ok := database.GetPollStatuses()[ip]; ok && pollStatus.UpSince > 0 {
+		uptime = formatDuration(time.Since(time.Unix(pollStatus.UpSince, 0)))
+	}
+
+	hostname := server.ServerHostname
+	if hostname == "" {
+		hostname = server.Name
+	}
+	displayName, flag := database.DisplayDefaults(server.Region, server.DisplayName, server.Flag)
+
+	return c.Render("server", fiber.Map{
+		"Title":       hostname + " - servers.tf2dl.net",
+		"Canonical":   "https://servers.tf2dl.net/servers/" + server.InstanceID,
+		"Robots":      "index, follow",
+		"Description": "Status, players and history of " + hostname,
+		"Keywords":    "servers.tf2dl.net, tf2, servers, " + server.Map,
+		"Server":      server,
+		"Hostname":    hostname,
+		"Region":      server.Region,
+		"DisplayName": displayName,
+		"Flag":        flag,
+		"Connect":     database.ConnectAddress(server.PublicIP, server.GamePort),
+		"Online":      online,
+		"Uptime":      uptime,
+		"Version":     version,
+		"Outdated":    outdated,
+		"Players":     players,
+		"DayHistory":  historyBars(day, dayStart, now, time.Hour, "15h"),
+		"WeekHistory": historyBars(week, weekStart, now, 6*time.Hour, "Mon 15h"),
+		"RecentMaps":  maps,
+		"Sessions":    sessions,
+	}, "layouts/main")
+}
+
+// maxHistoryPoints bounds the number of buckets one history request can ask for
+const maxHistoryPoints = 1000
+
+type serverHistory struct {
+	InstanceID string            `json:"instance_id"`
+	From       int64             `json:"from"`
+	To         int64             `json:"to"`
+	Step       int64             `json:"step"` // seconds
+	Points     []models.Snapshot `json:"points"`
+}
+
+// ServerHistory returns a server's player counts over time for charts.
+// from and to are unix seconds or RFC 3339 times and default to the last 24 hours,
+// step is a duration like 5m or a number of seconds.
+func ServerHistory(c *fiber.Ctx) error {
+	server, err := database.GetServer(c.Params("instance_id"))
+	if errors.Is(err, database.ErrServerNotFound) || (err == nil && server.Hidden) {
+		return c.Status(404).SendString("Server not found")
+	}
+	if err != nil {
+		return c.Status(500).SendString("Error getting server")
+	}
+
+	to := time.Now().UTC()
+	if value := c.Query("to"); value != "" {
+		if to, err = parseQueryTime(value); err != nil {
+			return c.Status(400).SendString("Invalid to")
+		}
+	}
+	from := to.Add(-24 * time.Hour)
+	if value := c.Query("from"); value != "" {
+		if from, err = parseQueryTime(value); err != nil {
+			return c.Status(400).SendString("Invalid from")
+		}
+	}
+	if !from.Before(to) {
+		return c.Status(400).SendString("from must be before to")
+	}
+
+	step := defaultHistoryStep(to.Sub(from))
+	if value := c.Query("step"); value != "" {
+		if step, err = parseQueryDuration(value); err != nil || step < time.Minute {
+			return c.Status(400).SendString("Invalid step, must be at least 1m")
+		}
+	}
+	if to.Sub(from)/step > maxHistoryPoints {
+		return c.Status(400).SendString(fmt.Sprintf("Too many points, at most %d per request", maxHistoryPoints))
+	}
+
+	points, err := database.GetServerHistory(server.PublicIP, from, to, step)
+	if err != nil {
+		return c.Status(500).SendString("Error getting server history")
+	}
+	if points == nil {
+		points = []models.Snapshot{}
+	}
+
+	return c.JSON(serverHistory{
+		InstanceID: server.InstanceID,
+		From:       from.Unix(),
+		To:         to.Unix(),
+		Step:       int64(step / time.Second),
+		Points:     points,
+	})
+}
+
+// defaultHistoryStep picks the finest of the rolled up resolutions that fits the span
+func defaultHistoryStep(span time.Duration) time.Duration {
+	for _, step := range []time.Duration{5 * time.Minute, time.Hour} {
+		if span/step <= maxHistoryPoints {
+			return step
+		}
+	}
+	return 24 * time.Hour
+}
+
+func parseQueryTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func parseQueryDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// historyBars turns snapshots into one bar per step from start to end showing the peak player count
+func historyBars(snapshots []models.Snapshot, start, end time.Time, step time.Duration, layout string) []historyBar {
+	peaks := map[int64]int{}
+	highest := 1
+	for _, snapshot := range snapshots {
+		peaks[snapshot.Time] = snapshot.PeakPlayers
+		highest = max(highest, snapshot.PeakPlayers)
+	}
+
+	var bars []historyBar
+	for bucket := start; bucket.Before(end); bucket = bucket.Add(step) {
+		players := peaks[bucket.Unix()]
+		bars = append(bars, historyBar{
+			Label:   bucket.UTC().Format(layout),
+			Players: players,
+			Height:  players * 100 / highest,
+		})
+	}
+	return bars
+}
+
+// formatTimestamp formats a time stored in the database for display, in UTC
+func formatTimestamp(value string) string {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return value
+	}
+	return t.UTC().Format("Jan 2 15:04 UTC")
+}
+
+func formatDuration(d time.Duration) string {
+	minutes := int(d.Minutes())
+	if minutes < 60 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	if minutes < 24*60 {
+		return fmt.Sprintf("%dh %dm", minutes/60, minutes%60)
+	}
+	return fmt.Sprintf("%dd %dh", minutes/(24*60), minutes%(24*60)/60)
+}
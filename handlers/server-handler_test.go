@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/template/html/v2"
+	"github.com/sawatkins/tf2dl-servers/database"
+)
+
+// Test server detail page for registered and unknown servers
+func TestServerDetail(t *testing.T) {
+	database.InitDB(":memory:")
+	t.Cleanup(database.Close)
+
+	database.ExecuteSQL(`
+		INSERT INTO servers (
+			instance_id, public_ip, public_dns, name, server_hostname, map, players, max_players
+		) VALUES
+		('i-1234567890', '192.168.1.1', 'ec2-1.compute.amazonaws.com', 'Server1', 'TF2 Server 1', 'cp_badlands', 0, 24);
+		INSERT INTO player_sessions (steam_id, connect_time, disconnect_time, duration, public_ip) VALUES
+		('U:1:1', '2026-10-18T10:00:00Z', '2026-10-18T10:30:00Z', 1800, '192.168.1.1');
+	`)
+
+	engine := html.New("../templates", ".html")
+	app := fiber.New(fiber.Config{Views: engine})
+	app.Get("/servers/:id", ServerDetail)
+
+	req := httptest.NewRequest(http.MethodGet, "/servers/i-1234567890", nil)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code 200, got %d", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	for _, expected := range []string{"TF2 Server 1", "cp_badlands", "Oct 18 10:00 UTC", "30m"} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Expected page to contain %q", expected)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/servers/i-missing", nil)
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status code 404, got %d", resp.StatusCode)
+	}
+}
+
+// Test server history for valid, invalid and unknown requests
+func TestServerHistory(t *testing.T) {
+	database.InitDB(":memory:")
+	t.Cleanup(database.Close)
+
+	hour := time.Now().UTC().Truncate(time.Hour).Add(-time.Hour).Unix()
+	database.ExecuteSQL(fmt.Sprintf(`
+		INSERT INTO servers (instance_id, public_ip, name) VALUES ('i-1234567890', '192.168.1.1', 'Server1');
+		INSERT INTO servers (instance_id, public_ip, name, hidden) VALUES ('i-hidden', '192.168.1.2', 'Hidden', 1);
+		INSERT INTO server_snapshots (public_ip, resolution, taken_at, map, players, peak_players, max_players, bots, reachable) VALUES
+		('192.168.1.1', 0, %d, 'cp_badlands', 2, 2, 24, 0, 1),
+		('192.168.1.1', 0, %d, 'cp_badlands', 6, 6, 24, 0, 1);
+	`, hour+60, hour+120))
+
+	app := fiber.New()
+	app.Get("/api/servers/:instance_id/history", ServerHistory)
+
+	url := fmt.Sprintf("/api/servers/i-1234567890/history?from=%d&to=%d&step=1h", hour, hour+3600)
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, url, nil))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", resp.StatusCode)
+	}
+
+	var history serverHistory
+	if err := json.NewDecoder(resp.Body).Decode(&history); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if history.Step != 3600 || len(history.Points) != 1 {
+		t.Fatalf("Unexpected history %+v", history)
+	}
+	if point := history.Points[0]; point.Time != hour || point.Players != 4 || point.PeakPlayers != 6 {
+		t.Errorf("Unexpected point %+v", point)
+	}
+
+	for url, expected := range map[string]int{
+		"/api/servers/i-1234567890/history?step=10s":                          http.StatusBadRequest,
+		"/api/servers/i-1234567890/history?from=yesterday":                    http.StatusBadRequest,
+		"/api/servers/i-1234567890/history?from=2026-01-01T00:00:00Z&step=1m": http.StatusBadRequest,
+		"/api/servers/i-1234567890/history?from=2026-10-18T00:00:00Z&to=1000": http.StatusBadRequest,
+		"/api/servers/i-missing/history":                                      http.StatusNotFound,
+		"/api/servers/i-hidden/history":                                       http.StatusNotFound,
+	} {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, url, nil))
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if resp.StatusCode != expected {
+			t.Errorf("Expected status code %d for %s, got %d", expected, url, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"bufio"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/sawatkins/tf2dl-servers/database"
+	"github.com/sawatkins/tf2dl-servers/hub"
+)
+
+// StreamHeartbeat is how often an idle event stream gets a comment line, which keeps
+// proxies from timing it out and notices browsers that have gone away
+var StreamHeartbeat = 20 * time.Second
+
+// Stream sends the state of every server as server-sent events, then an event each time
+// a server changes. Clients that fall behind are disconnected and reconnect on their own.
+func Stream(c *fiber.Ctx) error {
+	// subscribe before reading the current states so no change in between is missed
+	events, unsubscribe := database.ServerEvents.Subscribe()
+
+	states, err := database.GetServerStates()
+	if err != nil {
+		unsubscribe()
+		return c.Status(500).SendString("Error getting server states")
+	}
+	var initial [][]byte
+	for _, state := range states {
+		msg, err := hub.Event(database.EventServer, state)
+		if err != nil {
+			unsubscribe()
+			return c.Status(500).SendString("Error getting server states")
+		}
+		initial = append(initial, msg)
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer unsubscribe()
+
+		w.WriteString("retry: 5000\n\n")
+		for _, msg := range initial {
+			w.Write(msg)
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		heartbeat := time.NewTicker(StreamHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case msg, ok := <-events:
+				if !ok {
+					return
+				}
+				w.Write(msg)
+			case <-heartbeat.C:
+				w.WriteString(": ping\n\n")
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sawatkins/tf2dl-servers/database"
+)
+
+// readEvent reads the next event from the stream, skipping comments and retry lines
+func readEvent(t *testing.T, reader *bufio.Reader) (event, data string) {
+	t.Helper()
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && event != "":
+			return event, data
+		}
+	}
+}
+
+// Test that the stream sends current states, then changes seen by the poller
+func TestStream(t *testing.T) {
+	database.InitDB(":memory:")
+	t.Cleanup(database.Close)
+
+	database.ExecuteSQL(`
+		INSERT INTO servers (instance_id, public_ip, name, map, players, max_players)
+		VALUES ('i-1234567890', '127.0.0.1', 'Server1', 'cp_badlands', 3, 24);
+	`)
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/api/stream", Stream)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() { app.ShutdownWithTimeout(time.Second) })
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/api/stream")
+	if err != nil {
+		t.Fatalf("Failed to connect to stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Expected event stream, got %q", resp.Header.Get("Content-Type"))
+	}
+	reader := bufio.NewReader(resp.Body)
+
+	event, data := readEvent(t, reader)
+	if event != database.EventServer || !strings.Contains(data, `"map":"cp_badlands"`) || !strings.Contains(data, `"online":false`) {
+		t.Fatalf("Unexpected initial event %s %s", event, data)
+	}
+
+	// nothing answers RCON on this port, so the poll fails and the server is published as offline
+	port := database.RCONPort
+	database.RCONPort = "1"
+	t.Cleanup(func() { database.RCONPort = port })
+	connections := map[string]map[string]int64{}
+	database.UpdateServerInfo(&connections)
+
+	event, data = readEvent(t, reader)
+	if event != database.EventServer || !strings.Contains(data, `"instance_id":"i-1234567890"`) {
+		t.Errorf("Unexpected change event %s %s", event, data)
+	}
+
+	database.ExecuteSQL("DELETE FROM servers;")
+	database.UpdateServerInfo(&connections)
+
+	event, data = readEvent(t, reader)
+	if event != database.EventServerRemoved || data != `{"public_ip":"127.0.0.1"}` {
+		t.Errorf("Unexpected removal event %s %s", event, data)
+	}
+}
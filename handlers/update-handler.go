@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/sawatkins/tf2dl-servers/updater"
+)
+
+// Updater runs game server updates, nil disables them
+var Updater *updater.Orchestrator
+
+// GetUpdates returns the latest update of every server that has had one
+func GetUpdates(c *fiber.Ctx) error {
+	if Updater == nil {
+		return c.Status(200).JSON([]updater.Status{})
+	}
+	return c.Status(200).JSON(Updater.Statuses())
+}
+
+// PostUpdate starts updating every server that isn't already being updated
+func PostUpdate(c *fiber.Ctx) error {
+	if Updater == nil {
+		return c.Status(503).SendString("Updates are disabled")
+	}
+
+	reason := c.Query("reason", "Started from the API")
+	started, err := Updater.Start(reason)
+	if err != nil {
+		return c.Status(500).SendString("Error starting update")
+	}
+	if started == nil {
+		started = []string{}
+	}
+	return c.Status(202).JSON(fiber.Map{"started": started})
+}
+
+// updateStatus returns the latest update of a server, nil if it hasn't had one
+func updateStatus(ip string) *updater.Status {
+	if Updater == nil {
+		return nil
+	}
+	if status, ok := Updater.Status(ip); ok {
+		return &status
+	}
+	return nil
+}
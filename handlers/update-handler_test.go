@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sawatkins/tf2dl-servers/database"
+	"github.com/sawatkins/tf2dl-servers/updater"
+)
+
+// Test starting an update and reading its state back from the API
+func TestUpdates(t *testing.T) {
+	database.InitDB(":memory:")
+	t.Cleanup(database.Close)
+	CLIAuthKey = "test-key"
+	t.Cleanup(func() { CLIAuthKey = "" })
+
+	// the server has never been polled, so the update waits for it to be empty
+	database.ExecuteSQL(`INSERT INTO servers (instance_id, public_ip, name) VALUES ('i-1', '127.0.0.1', 'tf2_server_us')`)
+
+	app := fiber.New()
+	app.Get("/api/servers", GetServers)
+	app.Get("/api/updates", GetUpdates)
+	app.Post("/api/updates", RequireCLIAuth, PostUpdate)
+
+	resp := sendJSON(t, app, http.MethodPost, "/api/updates", "test-key", "")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code 503 with updates disabled, got %d", resp.StatusCode)
+	}
+
+	Updater = updater.New(database.GameServers{})
+	t.Cleanup(func() {
+		Updater.Close()
+		Updater = nil
+	})
+
+	resp = sendJSON(t, app, http.MethodPost, "/api/updates", "", "")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status code 401 without key, got %d", resp.StatusCode)
+	}
+
+	resp = sendJSON(t, app, http.MethodPost, "/api/updates?reason=patch", "test-key", "")
+	var result struct{ Started []string }
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.StatusCode != http.StatusAccepted || len(result.Started) != 1 || result.Started[0] != "127.0.0.1" {
+		t.Errorf("Expected the update to start on 127.0.0.1, got %d %+v", resp.StatusCode, result)
+	}
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/updates", nil))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	var statuses []updater.Status
+	if err := json.NewDecoder(resp.Body).Decode(&statuses); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(statuses) != 1 || statuses[0].State != updater.StateWaiting || statuses[0].Reason != "patch" {
+		t.Errorf("Expected one waiting update, got %+v", statuses)
+	}
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/api/servers", nil))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	var listings []serverListing
+	if err := json.NewDecoder(resp.Body).Decode(&listings); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(listings) != 1 || listings[0].Update == nil || listings[0].Update.State != updater.StateWaiting {
+		t.Errorf("Expected the server listing to include the update, got %+v", listings)
+	}
+}
@@ -0,0 +1,102 @@
+// Package hub fans messages out to any number of subscribers, such as the browsers
+// connected to the server-sent event stream.
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+// SubscriberBuffer is how many messages a subscriber can fall behind by before it is dropped
+var SubscriberBuffer = 16
+
+// Hub delivers every published message to all current subscribers. Publishing never blocks:
+// a subscriber that can't keep up has its channel closed and is removed, and is expected
+// to subscribe again.
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[chan []byte]struct{}
+	closed      bool
+}
+
+func New() *Hub {
+	return &Hub{subscribers: map[chan []byte]struct{}{}}
+}
+
+// Subscribe returns a channel receiving every message published from now on, and a function
+// to stop receiving them. The channel is closed when the subscriber is dropped or the hub closed.
+func (h *Hub) Subscribe() (<-chan []byte, func()) {
+	ch := make(chan []byte, SubscriberBuffer)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	h.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.remove(ch)
+	}
+}
+
+// Publish sends msg to every subscriber. The same slice is shared between them, so it
+// must not be modified afterwards.
+func (h *Hub) Publish(msg []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- msg:
+		default:
+			h.remove(ch)
+		}
+	}
+}
+
+// Subscribers returns the number of current subscribers
+func (h *Hub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers)
+}
+
+// Close drops every subscriber and makes later subscriptions return a closed channel
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers {
+		h.remove(ch)
+	}
+	h.closed = true
+}
+
+func (h *Hub) remove(ch chan []byte) {
+	if _, ok := h.subscribers[ch]; ok {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Event formats data as JSON in a server-sent event of the given type, ready to be
+// written to the stream as is
+func Event(event string, data any) ([]byte, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("event: ")
+	buf.WriteString(event)
+	buf.WriteString("\ndata: ")
+	buf.Write(encoded)
+	buf.WriteString("\n\n")
+	return buf.Bytes(), nil
+}
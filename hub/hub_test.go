@@ -0,0 +1,63 @@
+package hub
+
+import (
+	"testing"
+)
+
+// Every subscriber gets each message, and one that falls behind is dropped without blocking the rest
+func TestPublish(t *testing.T) {
+	h := New()
+	fast, unsubscribeFast := h.Subscribe()
+	defer unsubscribeFast()
+	slow, _ := h.Subscribe()
+
+	for i := 0; i <= SubscriberBuffer; i++ {
+		h.Publish([]byte{byte(i)})
+		if msg := <-fast; msg[0] != byte(i) {
+			t.Fatalf("Expected message %d, got %v", i, msg)
+		}
+	}
+
+	if h.Subscribers() != 1 {
+		t.Errorf("Expected the slow subscriber to be dropped, got %d subscribers", h.Subscribers())
+	}
+	count := 0
+	for range slow {
+		count++
+	}
+	if count != SubscriberBuffer {
+		t.Errorf("Expected the slow subscriber to keep %d buffered messages, got %d", SubscriberBuffer, count)
+	}
+}
+
+// Unsubscribing and closing the hub close subscriber channels
+func TestClose(t *testing.T) {
+	h := New()
+	first, unsubscribe := h.Subscribe()
+	second, _ := h.Subscribe()
+
+	unsubscribe()
+	unsubscribe() // must be safe to call twice
+	if _, ok := <-first; ok {
+		t.Errorf("Expected channel to be closed after unsubscribing")
+	}
+
+	h.Close()
+	if _, ok := <-second; ok {
+		t.Errorf("Expected channel to be closed with the hub")
+	}
+	late, _ := h.Subscribe()
+	if _, ok := <-late; ok {
+		t.Errorf("Expected subscriptions to a closed hub to be closed")
+	}
+}
+
+func TestEvent(t *testing.T) {
+	msg, err := Event("server", map[string]int{"players": 3})
+	if err != nil {
+		t.Fatalf("Failed to format event: %v", err)
+	}
+	if expected := "event: server\ndata: {\"players\":3}\n\n"; string(msg) != expected {
+		t.Errorf("Expected %q, got %q", expected, msg)
+	}
+}
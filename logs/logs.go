@@ -0,0 +1,218 @@
+// Package logs receives Source engine server logs sent over UDP with `logaddress_add`
+// and parses the lines relevant to player sessions and server activity.
+package logs
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sawatkins/tf2dl-servers/metrics"
+)
+
+type EventType string
+
+const (
+	EventConnect    EventType = "connect"
+	EventEnter      EventType = "enter"
+	EventDisconnect EventType = "disconnect"
+	EventMapChange  EventType = "map_change"
+	EventChat       EventType = "chat"
+	EventKill       EventType = "kill"
+	EventTeam       EventType = "team"
+)
+
+var (
+	ErrBadPacket = errors.New("logs: not a log packet")
+	ErrSecret    = errors.New("logs: log secret does not match")
+	ErrUnknown   = errors.New("logs: unrecognised log line")
+)
+
+type Player struct {
+	Name    string `json:"name"`
+	UserID  int    `json:"userid"`
+	SteamID string `json:"steam_id"` // [U:1:n], BOT or Console
+	Team    string `json:"team,omitempty"`
+}
+
+type Event struct {
+	Server  string    `json:"server"` // IP the log packet came from
+	Time    time.Time `json:"time"`
+	Type    EventType `json:"type"`
+	Player  Player    `json:"player"`
+	Target  Player    `json:"target,omitempty"`  // victim of a kill
+	Map     string    `json:"map,omitempty"`     // map change
+	Team    string    `json:"team,omitempty"`    // team joined
+	Message string    `json:"message,omitempty"` // chat text
+	TeamMsg bool      `json:"team_msg,omitempty"`
+	Weapon  string    `json:"weapon,omitempty"`
+	Reason  string    `json:"reason,omitempty"`  // disconnect reason
+	Address string    `json:"address,omitempty"` // player address on connect
+}
+
+const playerPattern = `"(.+?)<(-?\d+)><([^>]*)><([^>]*)>"`
+
+var (
+	lineRe       = regexp.MustCompile(`^L (\d{2}/\d{2}/\d{4} - \d{2}:\d{2}:\d{2}): (.*)$`)
+	connectRe    = regexp.MustCompile(`^` + playerPattern + ` connected, address "([^"]*)"`)
+	enterRe      = regexp.MustCompile(`^` + playerPattern + ` entered the game`)
+	disconnectRe = regexp.MustCompile(`^` + playerPattern + ` disconnected(?: \(reason "(.*)"\))?`)
+	chatRe       = regexp.MustCompile(`^` + playerPattern + ` (say|say_team) "(.*)"`)
+	killRe       = regexp.MustCompile(`^` + playerPattern + ` killed ` + playerPattern + ` with "([^"]*)"`)
+	teamRe       = regexp.MustCompile(`^` + playerPattern + ` joined team "([^"]*)"`)
+	mapRe        = regexp.MustCompile(`^Started map "([^"]*)"`)
+)
+
+// ParseLine parses a single log line, e.g.
+//
+//	L 10/18/2026 - 12:34:56: "Player One<3><[U:1:12345678]><>" connected, address "203.0.113.7:27005"
+//
+// Timestamps are read in loc, the game server's time zone. Lines for events
+// this package doesn't handle return ErrUnknown.
+func ParseLine(line string, loc *time.Location) (Event, error) {
+	match := lineRe.FindStringSubmatch(strings.TrimSpace(line))
+	if match == nil {
+		return Event{}, ErrUnknown
+	}
+
+	timestamp, err := time.ParseInLocation("01/02/2006 - 15:04:05", match[1], loc)
+	if err != nil {
+		return Event{}, err
+	}
+	event := Event{Time: timestamp}
+	body := match[2]
+
+	if m := connectRe.FindStringSubmatch(body); m != nil {
+		event.Type = EventConnect
+		event.Player = player(m[1:5])
+		event.Address = m[5]
+	} else if m := enterRe.FindStringSubmatch(body); m != nil {
+		event.Type = EventEnter
+		event.Player = player(m[1:5])
+	} else if m := disconnectRe.FindStringSubmatch(body); m != nil {
+		event.Type = EventDisconnect
+		event.Player = player(m[1:5])
+		event.Reason = m[5]
+	} else if m := chatRe.FindStringSubmatch(body); m != nil {
+		event.Type = EventChat
+		event.Player = player(m[1:5])
+		event.TeamMsg = m[5] == "say_team"
+		event.Message = m[6]
+	} else if m := killRe.FindStringSubmatch(body); m != nil {
+		event.Type = EventKill
+		event.Player = player(m[1:5])
+		event.Target = player(m[5:9])
+		event.Weapon = m[9]
+	} else if m := teamRe.FindStringSubmatch(body); m != nil {
+		event.Type = EventTeam
+		event.Player = player(m[1:5])
+		event.Team = m[5]
+	} else if m := mapRe.FindStringSubmatch(body); m != nil {
+		event.Type = EventMapChange
+		event.Map = m[1]
+	} else {
+		return Event{}, ErrUnknown
+	}
+
+	return event, nil
+}
+
+func player(fields []string) Player {
+	userID, _ := strconv.Atoi(fields[1])
+	return Player{Name: fields[0], UserID: userID, SteamID: fields[2], Team: fields[3]}
+}
+
+// parsePacket strips the packet header and checks the secret. Servers with sv_logsecret set
+// send 'S' followed by the secret, servers without send 'R'.
+func parsePacket(packet []byte, secret string) (string, error) {
+	if len(packet) < 5 || !bytes.Equal(packet[:4], []byte{0xFF, 0xFF, 0xFF, 0xFF}) {
+		return "", ErrBadPacket
+	}
+
+	body := string(bytes.TrimRight(packet[5:], "\x00\n"))
+	switch packet[4] {
+	case 'R':
+		if secret != "" {
+			return "", ErrSecret
+		}
+	case 'S':
+		n := len(secret)
+		if secret == "" || len(body) < n+2 || subtle.ConstantTimeCompare([]byte(body[:n]), []byte(secret)) != 1 || body[n:n+2] != "L " {
+			return "", ErrSecret
+		}
+		body = body[n:]
+	default:
+		return "", ErrBadPacket
+	}
+
+	return body, nil
+}
+
+type Listener struct {
+	Secret   string         // sv_logsecret of the game servers, empty to accept unsigned logs
+	Location *time.Location // time zone of the game servers' log timestamps
+	conn     *net.UDPConn
+}
+
+// Listen opens a UDP socket for game servers to send their logs to with `logaddress_add`.
+// Log timestamps are read in loc, nil for UTC.
+func Listen(addr, secret string, loc *time.Location) (*Listener, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Listener{Secret: secret, Location: loc, conn: conn}, nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// Serve calls handler for every recognised event until the listener is closed
+func (l *Listener) Serve(handler func(Event)) error {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		line, err := parsePacket(buf[:n], l.Secret)
+		if errors.Is(err, ErrSecret) {
+			metrics.LogPacketsDropped.WithLabelValues(metrics.DroppedSecret).Inc()
+			continue
+		} else if err != nil {
+			metrics.LogPacketsDropped.WithLabelValues(metrics.DroppedBadPacket).Inc()
+			continue
+		}
+
+		event, err := ParseLine(line, l.Location)
+		if err != nil {
+			continue
+		}
+		event.Server = addr.IP.String()
+		handler(event)
+	}
+}
+
+func (l *Listener) Close() error {
+	return l.conn.Close()
+}
@@ -0,0 +1,134 @@
+package logs
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sawatkins/tf2dl-servers/metrics"
+)
+
+func TestParseLine(t *testing.T) {
+	at := time.Date(2026, 10, 18, 12, 34, 56, 0, time.UTC)
+	one := Player{Name: "Player One", UserID: 3, SteamID: "[U:1:12345678]"}
+	red := Player{Name: "Player One", UserID: 3, SteamID: "[U:1:12345678]", Team: "Red"}
+	blue := Player{Name: "<b>two</b>", UserID: 4, SteamID: "[U:1:42]", Team: "Blue"}
+
+	tests := []struct {
+		line     string
+		expected Event
+	}{
+		{
+			`L 10/18/2026 - 12:34:56: "Player One<3><[U:1:12345678]><>" connected, address "203.0.113.7:27005"`,
+			Event{Time: at, Type: EventConnect, Player: one, Address: "203.0.113.7:27005"},
+		},
+		{
+			`L 10/18/2026 - 12:34:56: "Player One<3><[U:1:12345678]><>" entered the game`,
+			Event{Time: at, Type: EventEnter, Player: one},
+		},
+		{
+			`L 10/18/2026 - 12:34:56: "Player One<3><[U:1:12345678]><Red>" disconnected (reason "Disconnect by user.")`,
+			Event{Time: at, Type: EventDisconnect, Player: red, Reason: "Disconnect by user."},
+		},
+		{
+			`L 10/18/2026 - 12:34:56: "Player One<3><[U:1:12345678]><Red>" say "gg "ez""`,
+			Event{Time: at, Type: EventChat, Player: red, Message: `gg "ez"`},
+		},
+		{
+			`L 10/18/2026 - 12:34:56: "Player One<3><[U:1:12345678]><Red>" say_team "push"`,
+			Event{Time: at, Type: EventChat, Player: red, Message: "push", TeamMsg: true},
+		},
+		{
+			`L 10/18/2026 - 12:34:56: "Player One<3><[U:1:12345678]><Red>" killed "<b>two</b><4><[U:1:42]><Blue>" with "scattergun" (attacker_position "1 2 3") (victim_position "4 5 6")`,
+			Event{Time: at, Type: EventKill, Player: red, Target: blue, Weapon: "scattergun"},
+		},
+		{
+			`L 10/18/2026 - 12:34:56: "Player One<3><[U:1:12345678]><Unassigned>" joined team "Red"`,
+			Event{Time: at, Type: EventTeam, Player: Player{Name: "Player One", UserID: 3, SteamID: "[U:1:12345678]", Team: "Unassigned"}, Team: "Red"},
+		},
+		{
+			`L 10/18/2026 - 12:34:56: Started map "surf_utopia_v3" (CRC "-1734207574")`,
+			Event{Time: at, Type: EventMapChange, Map: "surf_utopia_v3"},
+		},
+	}
+
+	for _, test := range tests {
+		event, err := ParseLine(test.line, time.UTC)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(event, test.expected) {
+			t.Errorf("Unexpected event for %q\n got: %+v\nwant: %+v", test.line, event, test.expected)
+		}
+	}
+
+	if _, err := ParseLine(`L 10/18/2026 - 12:34:56: World triggered "Round_Start"`, time.UTC); err != ErrUnknown {
+		t.Errorf("Expected ErrUnknown, got %v", err)
+	}
+}
+
+func TestParsePacket(t *testing.T) {
+	line := `L 10/18/2026 - 12:34:56: Started map "surf_utopia_v3"`
+	unsigned := append([]byte("\xff\xff\xff\xffR"), line+"\n\x00"...)
+	signed := append([]byte("\xff\xff\xff\xffS12345"), line+"\n\x00"...)
+
+	if got, err := parsePacket(unsigned, ""); err != nil || got != line {
+		t.Errorf("Unsigned packet: got %q, %v", got, err)
+	}
+	if got, err := parsePacket(signed, "12345"); err != nil || got != line {
+		t.Errorf("Signed packet: got %q, %v", got, err)
+	}
+	if _, err := parsePacket(signed, "54321"); err != ErrSecret {
+		t.Errorf("Expected ErrSecret for wrong secret, got %v", err)
+	}
+	if _, err := parsePacket(signed, "1234"); err != ErrSecret {
+		t.Errorf("Expected ErrSecret for a prefix of the secret, got %v", err)
+	}
+	if _, err := parsePacket(unsigned, "12345"); err != ErrSecret {
+		t.Errorf("Expected ErrSecret for unsigned packet, got %v", err)
+	}
+	if _, err := parsePacket([]byte(line), ""); err != ErrBadPacket {
+		t.Errorf("Expected ErrBadPacket, got %v", err)
+	}
+}
+
+// Test that events sent to the listener reach the handler tagged with the sending server
+func TestListener(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("Failed to load time zone: %v", err)
+	}
+	listener, err := Listen("127.0.0.1:0", "secret", berlin)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	events := make(chan Event, 2)
+	go listener.Serve(func(event Event) { events <- event })
+	defer listener.Close()
+
+	conn, err := net.Dial("udp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial listener: %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("\xff\xff\xff\xffSwrongL 10/18/2026 - 12:34:56: Started map \"cp_badlands\"\n\x00"))
+	conn.Write([]byte("\xff\xff\xff\xffSsecretL 10/18/2026 - 12:34:56: Started map \"surf_utopia_v3\"\n\x00"))
+
+	select {
+	case event := <-events:
+		if event.Type != EventMapChange || event.Map != "surf_utopia_v3" || event.Server != "127.0.0.1" ||
+			!event.Time.Equal(time.Date(2026, 10, 18, 10, 34, 56, 0, time.UTC)) {
+			t.Errorf("Unexpected event %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for event")
+	}
+	if dropped := testutil.ToFloat64(metrics.LogPacketsDropped.WithLabelValues(metrics.DroppedSecret)); dropped != 1 {
+		t.Errorf("Expected the packet with the wrong secret to be counted, got %v", dropped)
+	}
+}
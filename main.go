@@ -1,115 +1,250 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
-	"net/http"
 	"os"
-	"strings"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
 	"time"
+	_ "time/tzdata" // log_timezone works on hosts without a zoneinfo database
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/etag"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/template/html/v2"
-	"github.com/joho/godotenv"
 	"github.com/mmcdole/gofeed"
 
+	"github.com/sawatkins/tf2dl-servers/auth"
+	"github.com/sawatkins/tf2dl-servers/config"
 	"github.com/sawatkins/tf2dl-servers/database"
 	"github.com/sawatkins/tf2dl-servers/handlers"
+	"github.com/sawatkins/tf2dl-servers/logs"
+	"github.com/sawatkins/tf2dl-servers/metrics"
+	"github.com/sawatkins/tf2dl-servers/notify"
+	"github.com/sawatkins/tf2dl-servers/rss"
+	"github.com/sawatkins/tf2dl-servers/updater"
 )
 
 func main() {
-	port := flag.String("port", ":8080", "Port to listen on")
-	dev := flag.Bool("dev", true, "Enable development mode")
-	flag.Parse()
+	cfg, printConfig, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	if printConfig {
+		fmt.Print(cfg)
+		os.Exit(0)
+	}
+
+	database.PollInterval = cfg.Poll.Interval
+	database.PollTimeout = cfg.Poll.Timeout
+	database.DownAfterFailures = cfg.Poll.DownAfterFailures
+	database.QueryPort = strconv.Itoa(cfg.Poll.QueryPort)
+	database.ServerRCON = cfg.RCON
+	handlers.CLIAuthKey = cfg.CLIAuthKey
+	handlers.PublicURL = cfg.Auth.PublicURL
+	handlers.OpenID = auth.NewOpenID(cfg.Auth.OpenIDEndpoint)
+	sessionSecret := []byte(cfg.Auth.SessionSecret)
+	if len(sessionSecret) == 0 {
+		log.Println("No session secret configured, players are signed out on restart")
+		sessionSecret = auth.RandomSecret()
+	}
+	handlers.Sessions = auth.NewSessions(sessionSecret)
 
-	if err := godotenv.Load("./cli/.env"); err != nil {
-		log.Fatalln("Did not load .env file")
+	if cfg.Privacy.HashIDs {
+		database.PrivacySecret = []byte(cfg.Privacy.Secret)
+	}
+	database.InitDB(cfg.Database)
+
+	targets, err := cfg.NotifyTargets()
+	if err != nil {
+		log.Fatalf("Error configuring notifications: %v", err)
+	}
+	if len(targets) == 0 {
+		log.Println("No notify targets configured, alerts are disabled")
+	}
+	templates, err := cfg.NotifyTemplates()
+	if err != nil {
+		log.Fatalf("Error configuring notifications: %v", err)
 	}
+	notifier := notify.NewDispatcher(targets...)
+	notifier.Templates = templates
+	database.Notifier = notifier
+
+	updates := updater.New(database.GameServers{})
+	updates.WaitForEmpty = cfg.Updates.Wait
+	updates.Countdown = cfg.Updates.Countdown
+	updates.Hook = cfg.Updates.Hook
+	updates.CheckInterval = cfg.Poll.Interval
+	handlers.Updater = updates
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var logEvents chan logs.Event
+	if cfg.LogAddr != "" {
+		loc, err := cfg.LogLocation()
+		if err != nil {
+			log.Fatalf("Error loading log time zone: %v", err)
+		}
+		listener, err := logs.Listen(cfg.LogAddr, cfg.LogSecret, loc)
+		if err != nil {
+			log.Fatalf("Error starting log listener: %v", err)
+		}
+		logEvents = make(chan logs.Event, 256)
+		go func() {
+			<-ctx.Done()
+			listener.Close()
+		}()
+		go func() {
+			err := listener.Serve(func(event logs.Event) {
+				select {
+				case logEvents <- event:
+				default:
+					log.Printf("Log event queue full, dropped %s event from %s", event.Type, event.Server)
+				}
+			})
+			if err != nil {
+				log.Printf("Error receiving game server logs: %v", err)
+			}
+		}()
+		log.Println("Receiving game server logs on", listener.Addr())
+	}
+
+	var workers sync.WaitGroup
+	workers.Add(2)
+	go func() {
+		defer workers.Done()
+		startServerInfoUpdater(ctx, cfg.ReconnectGrace, logEvents)
+	}()
+	go func() {
+		defer workers.Done()
+		checker := rss.NewChecker(cfg.RSS.URL, database.FeedStore{}, func(item *gofeed.Item) {
+			notifier.Send(notify.Alert{
+				Event: notify.EventUpdateReleased,
+				Data:  map[string]string{"Title": item.Title},
+				URL:   item.Link,
+			})
+			if cfg.Updates.Auto {
+				if _, err := updates.Start(item.Title); err != nil {
+					log.Printf("Error starting game server updates: %v", err)
+				}
+			}
+		})
+		checker.Interval = cfg.RSS.Interval
+		checker.OnCheck = func(outcome string) { metrics.RSSChecks.WithLabelValues(outcome).Inc() }
+		checker.Run(ctx)
+	}()
 
-	database.InitDB("./data/upfast.db")
-	database.InitServerTable()
-	database.InitPlayerSessionTable()
-	go startServerInfoUpdater()
-	go checkForGameUpdate()
+	metrics.RegisterFleet(database.FleetState)
 
 	engine := html.New("./templates", ".html")
-	if *dev {
+	if cfg.Dev {
 		engine.Reload(true)
 		engine.Debug(true)
 	}
 
 	app := fiber.New(fiber.Config{
-		Views: engine,
+		Views:             engine,
+		PassLocalsToViews: true, // the signed in player for the navbar
 	})
 
 	app.Use(recover.New())
+	app.Use(metrics.Middleware())
 	app.Use(logger.New())
 	app.Static("/", "./static")
+	app.Use(handlers.LoadUser)
 
-	app.Post("/api/current-servers", handlers.PostCurrentServer)
+	app.Post("/api/current-servers", handlers.RequireCLIAuth, handlers.PostCurrentServer)
+	app.Put("/api/servers/:instance_id", handlers.RequireCLIAuth, handlers.PutServer)
+	app.Patch("/api/servers/:instance_id", handlers.RequireCLIAuth, handlers.PatchServer)
+	app.Delete("/api/servers/:instance_id", handlers.RequireCLIAuth, handlers.DeleteServer)
 	app.Get("/api/server-ips", handlers.GetServerIPs)
 	app.Get("/api/server-info", handlers.GetServerInfo)
+	app.Get("/api/servers", etag.New(), handlers.GetServers)
+	app.Get("/api/servers/:instance_id", etag.New(), handlers.GetServer)
+	app.Get("/api/servers/:instance_id/history", handlers.ServerHistory)
+	app.Get("/api/stream", handlers.Stream)
+	app.Get("/api/updates", handlers.GetUpdates)
+	app.Post("/api/updates", handlers.RequireCLIAuth, handlers.PostUpdate)
+	app.Get("/api/players/:steam_id/export", handlers.RequirePlayerOrAdmin, handlers.ExportPlayer)
+	app.Delete("/api/players/:steam_id", handlers.RequirePlayerOrAdmin, handlers.ErasePlayer)
+	app.Put("/api/players/:steam_id/leaderboard-opt-out", handlers.RequirePlayerOrAdmin, handlers.OptOutPlayer)
+	app.Delete("/api/players/:steam_id/leaderboard-opt-out", handlers.RequirePlayerOrAdmin, handlers.OptOutPlayer)
+	app.Get("/api/leaderboard", etag.New(), handlers.GetLeaderboard)
+
+	app.Get("/metrics", metrics.Handler())
+	app.Get("/healthz", handlers.Healthz)
+	app.Get("/readyz", handlers.Readyz)
 
 	app.Get("/", handlers.Index)
 	app.Get("/about", handlers.About)
+	app.Get("/leaderboard", handlers.Leaderboard)
+	app.Get("/servers/:id", handlers.ServerDetail)
+	app.Get("/login", handlers.Login)
+	app.Get("/login/callback", handlers.LoginCallback)
+	app.Post("/logout", handlers.Logout)
+	app.Get("/me", handlers.MyStats)
 	app.Use(handlers.NotFound)
 
-	log.Println("Server starting on port", *port)
-	log.Fatal(app.Listen(*port)) // default port: 8080
-}
+	log.Println("Server starting on port", cfg.Listen)
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- app.Listen(cfg.Listen) // default port: 8080
+	}()
+
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+		log.Println("Shutting down")
+	case err := <-listenErr:
+		log.Printf("Error running server: %v", err)
+		exitCode = 1
+	}
+	stop()
 
-func startServerInfoUpdater() {
-	prevPlayerConnections := map[string]map[string]int64{} // map[ip]map[playerID]timestamp{}
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-	for range ticker.C {
-		database.UpdateServerInfo(&prevPlayerConnections)
+	// end the event streams, or shutdown would wait on them until the timeout
+	database.ServerEvents.Close()
+	if err := app.ShutdownWithTimeout(10 * time.Second); err != nil {
+		log.Printf("Error shutting down server: %v", err)
 	}
+	workers.Wait()
+	updates.Close()
+	notifier.Close()
+	database.Close()
+	log.Println("Shutdown complete")
+	os.Exit(exitCode)
 }
 
-func checkForGameUpdate() {
-	prevItemDate := time.Time{}
-	ticker := time.NewTicker(3 * time.Hour)
+// startServerInfoUpdater polls the game servers and applies log events as they arrive. Both update
+// the same player connections, so they are handled on this one goroutine. logEvents may be nil.
+func startServerInfoUpdater(ctx context.Context, reconnectGrace time.Duration, logEvents <-chan logs.Event) {
+	prevPlayerConnections := database.LoadActiveConnections(reconnectGrace) // map[ip]map[playerID]timestamp{}
+	database.UpdateServerInfo(&prevPlayerConnections)
+	ticker := time.NewTicker(database.PollInterval)
 	defer ticker.Stop()
-	for range ticker.C {
-		resp, err := http.Get("https://www.teamfortress.com/rss.xml")
-		log.Println("Fetching rss feed")
-		if err != nil {
-			log.Printf("Error fetching TF2 RSS feed: %v", err)
-			continue
-		}
-
-		feed, err := gofeed.NewParser().Parse(resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			log.Printf("Error parsing TF2 RSS feed: %v", err)
-			continue
-		}
-
-		if len(feed.Items) == 0 {
-			continue
-		}
-
-		latestItem := feed.Items[0]
-		newItemDate := *latestItem.PublishedParsed
-		if !newItemDate.After(prevItemDate) {
-			continue
-		}
-
-		prevItemDate = newItemDate
-		if strings.Contains(latestItem.Title, "Team Fortress 2 Update Released") {
-			log.Printf("New TF2 update")
-			resp, err := http.Post(os.Getenv("NOTIFY_URL"), "text/plain", strings.NewReader("New TF2 Update Released!"))
-			if err != nil {
-				log.Printf("Error sending update notification: %v", err)
-			} else if resp.StatusCode != 200 {
-				log.Printf("Status code was not 200, it was %d", resp.StatusCode)
-			} else {
-				log.Println("Successfully send update notification")
-			}
-
+	rollupTicker := time.NewTicker(5 * time.Minute)
+	defer rollupTicker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			database.FlushConnections(prevPlayerConnections, database.EndReasonShutdown)
+			return
+		case <-ticker.C:
+			database.UpdateServerInfo(&prevPlayerConnections)
+		case <-rollupTicker.C:
+			database.RollupSnapshots(time.Now())
+		case event := <-logEvents:
+			database.ApplyLogEvent(&prevPlayerConnections, event)
 		}
 	}
 }
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ServerState is what the fleet gauges report for one registered server
+type ServerState struct {
+	InstanceID string
+	PublicIP   string
+	Name       string
+	Map        string
+	Players    int
+	MaxPlayers int
+	Reachable  bool
+	Version    int
+	Outdated   bool
+}
+
+var (
+	serverLabels = []string{"server", "instance_id", "name"}
+
+	playersDesc = prometheus.NewDesc(namespace+"_server_players",
+		"Human players on the server at the last poll.", serverLabels, nil)
+	maxPlayersDesc = prometheus.NewDesc(namespace+"_server_max_players",
+		"Player slots on the server.", serverLabels, nil)
+	reachableDesc = prometheus.NewDesc(namespace+"_server_reachable",
+		"1 if the last poll of the server succeeded.", serverLabels, nil)
+	mapDesc = prometheus.NewDesc(namespace+"_server_map",
+		"Always 1, labelled with the map the server is running.", append(serverLabels, "map"), nil)
+	versionDesc = prometheus.NewDesc(namespace+"_server_version",
+		"Game version the server reported at its last successful poll.", serverLabels, nil)
+	outdatedDesc = prometheus.NewDesc(namespace+"_server_outdated",
+		"1 if the server is behind the newest game version seen on the fleet.", serverLabels, nil)
+)
+
+// fleetCollector reads the fleet gauges from source on every scrape, so servers that are
+// removed or change maps don't leave stale series behind
+type fleetCollector struct {
+	source func() ([]ServerState, error)
+}
+
+// RegisterFleet exports per-server gauges read from source
+func RegisterFleet(source func() ([]ServerState, error)) {
+	prometheus.MustRegister(&fleetCollector{source})
+}
+
+func (f *fleetCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- playersDesc
+	ch <- maxPlayersDesc
+	ch <- reachableDesc
+	ch <- mapDesc
+	ch <- versionDesc
+	ch <- outdatedDesc
+}
+
+func (f *fleetCollector) Collect(ch chan<- prometheus.Metric) {
+	servers, err := f.source()
+	if err != nil {
+		log.Printf("Error collecting fleet metrics: %v", err)
+		return
+	}
+
+	for _, s := range servers {
+		labels := []string{s.PublicIP, s.InstanceID, s.Name}
+		reachable, outdated := 0.0, 0.0
+		if s.Reachable {
+			reachable = 1
+		}
+		if s.Outdated {
+			outdated = 1
+		}
+
+		ch <- prometheus.MustNewConstMetric(playersDesc, prometheus.GaugeValue, float64(s.Players), labels...)
+		ch <- prometheus.MustNewConstMetric(maxPlayersDesc, prometheus.GaugeValue, float64(s.MaxPlayers), labels...)
+		ch <- prometheus.MustNewConstMetric(reachableDesc, prometheus.GaugeValue, reachable, labels...)
+		if s.Version > 0 {
+			ch <- prometheus.MustNewConstMetric(versionDesc, prometheus.GaugeValue, float64(s.Version), labels...)
+		}
+		ch <- prometheus.MustNewConstMetric(outdatedDesc, prometheus.GaugeValue, outdated, labels...)
+		if s.Map != "" {
+			ch <- prometheus.MustNewConstMetric(mapDesc, prometheus.GaugeValue, 1, append(labels, s.Map)...)
+		}
+	}
+}
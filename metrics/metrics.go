@@ -0,0 +1,115 @@
+// Package metrics exports what the backend knows about the fleet in the Prometheus text format,
+// so Grafana can chart the same data the website shows.
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "tf2dl"
+
+var (
+	// PollDuration is how long polling a server took, labelled by server IP and query method
+	PollDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "poll_duration_seconds",
+		Help:      "Time taken to poll a game server.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}, []string{"server", "method"})
+
+	// PollErrors counts failed RCON and A2S polls
+	PollErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "poll_errors_total",
+		Help:      "Polls of a game server that failed.",
+	}, []string{"server", "method"})
+
+	// SessionsStarted counts players seen joining a server
+	SessionsStarted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sessions_started_total",
+		Help:      "Player sessions opened.",
+	}, []string{"server"})
+
+	// SessionsEnded counts sessions written to the database, labelled by end reason
+	SessionsEnded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sessions_ended_total",
+		Help:      "Player sessions closed.",
+	}, []string{"server", "reason"})
+
+	// SessionDuration is the length of closed sessions
+	SessionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "session_duration_seconds",
+		Help:      "Length of closed player sessions.",
+		Buckets:   []float64{60, 300, 900, 1800, 3600, 2 * 3600, 4 * 3600, 8 * 3600},
+	}, []string{"server"})
+
+	// RSSChecks counts TF2 RSS feed checks by outcome, see rss.Checker.OnCheck
+	RSSChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rss_checks_total",
+		Help:      "Checks of the TF2 RSS feed for updates, by outcome.",
+	}, []string{"outcome"})
+
+	// LogPacketsDropped counts UDP log packets that weren't read, by reason. Anyone can send
+	// them, so they are counted rather than logged.
+	LogPacketsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "log_packets_dropped_total",
+		Help:      "UDP log packets dropped, by reason.",
+	}, []string{"reason"})
+
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+)
+
+// Reasons for dropping a log packet
+const (
+	DroppedBadPacket = "bad_packet"
+	DroppedSecret    = "secret"
+)
+
+// Handler serves the registered metrics
+func Handler() fiber.Handler {
+	return adaptor.HTTPHandler(promhttp.Handler())
+}
+
+// Middleware counts and times requests. Requests are labelled with the route pattern
+// they matched rather than their path, so /servers/:id is a single series.
+func Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if fiberErr, ok := err.(*fiber.Error); ok {
+			status = fiberErr.Code
+		} else if err != nil {
+			status = fiber.StatusInternalServerError
+		}
+
+		route := c.Route().Path
+		httpRequests.WithLabelValues(c.Method(), route, strconv.Itoa(status)).Inc()
+		httpDuration.WithLabelValues(c.Method(), route).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
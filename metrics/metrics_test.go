@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Test that fleet gauges and HTTP metrics are served in the text format
+func TestHandler(t *testing.T) {
+	RegisterFleet(func() ([]ServerState, error) {
+		return []ServerState{
+			{InstanceID: "i-1", PublicIP: "192.168.1.1", Name: "Server1", Map: "cp_badlands", Players: 5, MaxPlayers: 24, Reachable: true, Version: 9543365},
+			{InstanceID: "i-2", PublicIP: "192.168.1.2", Name: "Server2", Version: 9500000, Outdated: true},
+		}, nil
+	})
+
+	app := fiber.New()
+	app.Use(Middleware())
+	app.Get("/servers/:id", func(c *fiber.Ctx) error { return c.SendStatus(404) })
+	app.Get("/metrics", Handler())
+
+	for _, path := range []string{"/servers/a", "/servers/b"} {
+		if _, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil)); err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+	}
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+
+	for _, expected := range []string{
+		`tf2dl_server_players{instance_id="i-1",name="Server1",server="192.168.1.1"} 5`,
+		`tf2dl_server_max_players{instance_id="i-1",name="Server1",server="192.168.1.1"} 24`,
+		`tf2dl_server_reachable{instance_id="i-1",name="Server1",server="192.168.1.1"} 1`,
+		`tf2dl_server_reachable{instance_id="i-2",name="Server2",server="192.168.1.2"} 0`,
+		`tf2dl_server_map{instance_id="i-1",map="cp_badlands",name="Server1",server="192.168.1.1"} 1`,
+		`tf2dl_server_version{instance_id="i-1",name="Server1",server="192.168.1.1"} 9.543365e+06`,
+		`tf2dl_server_outdated{instance_id="i-1",name="Server1",server="192.168.1.1"} 0`,
+		`tf2dl_server_outdated{instance_id="i-2",name="Server2",server="192.168.1.2"} 1`,
+		`tf2dl_http_requests_total{method="GET",route="/servers/:id",status="404"} 2`,
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Expected metrics to contain %s", expected)
+		}
+	}
+	if strings.Contains(string(body), `tf2dl_server_map{instance_id="i-2"`) {
+		t.Errorf("Expected no map series for a server without a map")
+	}
+}
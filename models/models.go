@@ -10,9 +10,32 @@ type Server struct {
 	Players        int    `json:"players"`
 	MaxPlayers     int    `json:"max_players"`
 	CreatedAt      string `json:"created_at"`
+	QueryMethod    string `json:"query_method"` // rcon or a2s
+	Region         string `json:"region"`       // region code, e.g. us-west
+	DisplayName    string `json:"display_name"` // shown in the server table, the region code if empty
+	Flag           string `json:"flag"`         // flag image path, /img/<region>.svg if empty
+	GamePort       int    `json:"game_port"`    // port players connect to, 27015 if not set
+	SortOrder      int    `json:"sort_order"`   // servers are listed in ascending order
+	Hidden         bool   `json:"hidden"`       // hidden servers are polled but not listed on the site
+}
+
+// ServerUpdate holds the registration fields of a partial server update, nil fields are left unchanged
+type ServerUpdate struct {
+	PublicIP       *string `json:"public_ip"`
+	PublicDNS      *string `json:"public_dns"`
+	Name           *string `json:"name"`
+	ServerHostname *string `json:"server_hostname"`
+	QueryMethod    *string `json:"query_method"`
+	Region         *string `json:"region"`
+	DisplayName    *string `json:"display_name"`
+	Flag           *string `json:"flag"`
+	GamePort       *int    `json:"game_port"`
+	SortOrder      *int    `json:"sort_order"`
+	Hidden         *bool   `json:"hidden"`
 }
 
 type ServerStatus struct {
+	InstanceID string `json:"instance_id"`
 	PublicIP   string `json:"public_ip"`
 	Map        string `json:"map"`
 	Players    string `json:"players"`
@@ -26,4 +49,104 @@ type PlayerSession struct {
 	DisconnectTime string `json:"disconnect_time,omitempty"`
 	Duration       int    `json:"duration"` // seconds
 	PublicIP       string `json:"public_ip"`
+	EndReason      string `json:"end_reason,omitempty"`
+}
+
+// User is a player who signed in through Steam
+type User struct {
+	SteamID64 uint64 `json:"steam_id64"`
+	AccountID string `json:"account_id"` // U:1:n
+	CreatedAt string `json:"created_at"`
+	LastLogin string `json:"last_login"`
+}
+
+// PlayerStats totals a player's sessions
+type PlayerStats struct {
+	Sessions       int    `json:"sessions"`
+	TotalTime      int    `json:"total_time"`      // seconds
+	LongestSession int    `json:"longest_session"` // seconds
+	FirstSeen      string `json:"first_seen,omitempty"`
+	LastSeen       string `json:"last_seen,omitempty"`
+}
+
+// Player is a Steam account that has played on the servers
+type Player struct {
+	SteamID64     uint64 `json:"steam_id64"`
+	AccountID     string `json:"account_id"` // U:1:n
+	FirstSeen     string `json:"first_seen"`
+	LastSeen      string `json:"last_seen"`
+	LastName      string `json:"last_name"`
+	TotalPlaytime int    `json:"total_playtime"` // seconds
+	SessionCount  int    `json:"session_count"`
+}
+
+// Erasure is an audit record of a player's data being erased or anonymized
+type Erasure struct {
+	Mode        string `json:"mode"`         // erase or anonymize
+	RequestedBy string `json:"requested_by"` // player or admin
+	Sessions    int    `json:"sessions"`     // sessions removed from the player
+	Duration    int    `json:"duration"`     // seconds, summed over those sessions
+	ErasedAt    string `json:"erased_at"`
+}
+
+// LeaderboardEntry is a player's place on a leaderboard
+type LeaderboardEntry struct {
+	Rank      int    `json:"rank"`
+	SteamID64 uint64 `json:"steam_id64,omitempty"` // 0 in privacy mode
+	Name      string `json:"name,omitempty"`       // last name seen, empty in privacy mode
+	Value     int    `json:"value"`                // seconds for playtime and longest session, otherwise a count
+}
+
+type PollStatus struct {
+	PublicIP            string `json:"public_ip"`
+	LastPoll            int64  `json:"last_poll"`    // unix seconds the last poll was sent
+	LastSuccess         int64  `json:"last_success"` // unix seconds, 0 if never reached
+	LastError           string `json:"last_error,omitempty"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	UpSince             int64  `json:"up_since"` // unix seconds of the first poll in the current run of successes, 0 while down
+}
+
+type MapChange struct {
+	PublicIP  string `json:"public_ip"`
+	Map       string `json:"map"`
+	StartedAt string `json:"started_at"`
+}
+
+// FeedState is what was last read from an RSS feed
+type FeedState struct {
+	URL           string
+	ETag          string
+	LastModified  string // Last-Modified header, sent back as If-Modified-Since
+	LastGUID      string // id of the newest item seen
+	LastPublished int64  // unix seconds of the newest publish date seen, 0 if none
+	CheckedAt     int64  // unix seconds
+}
+
+type Snapshot struct {
+	Time        int64   `json:"time"` // unix seconds, the start of the bucket for rolled up snapshots
+	Map         string  `json:"map"`
+	Players     float64 `json:"players"` // average over the bucket
+	PeakPlayers int     `json:"peak_players"`
+	MaxPlayers  int     `json:"max_players"`
+	Bots        float64 `json:"bots"`
+	Reachable   float64 `json:"reachable"` // share of polls that reached the server
+}
+
+// ServerState is a listed server as shown in the live server table
+type ServerState struct {
+	InstanceID  string `json:"instance_id"`
+	PublicIP    string `json:"public_ip"`
+	Hostname    string `json:"hostname"`
+	Region      string `json:"region"`
+	DisplayName string `json:"display_name"`
+	Flag        string `json:"flag"`
+	GamePort    int    `json:"game_port"`
+	SortOrder   int    `json:"sort_order"`
+	Map         string `json:"map"`
+	Players     int    `json:"players"`
+	MaxPlayers  int    `json:"max_players"`
+	Version     int    `json:"version"`  // game version at the last poll, 0 if unknown
+	Outdated    bool   `json:"outdated"` // behind the newest version seen on the fleet, or said so itself
+	Connect     string `json:"connect"`  // address to connect to in game
+	Online      bool   `json:"online"`   // whether the last poll reached the server
 }
@@ -0,0 +1,174 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Ntfy posts the message text as a plain body, with the title and link in ntfy's headers.
+// Any endpoint that accepts a plain text POST works.
+type Ntfy struct {
+	URL    string
+	Client *http.Client
+}
+
+func (n *Ntfy) Name() string { return "ntfy " + host(n.URL) }
+
+func (n *Ntfy) Notify(ctx context.Context, msg Message) error {
+	body := msg.Text
+	if body == "" {
+		body = msg.Title
+	}
+
+	header := http.Header{"Content-Type": {"text/plain"}}
+	if msg.Text != "" {
+		header.Set("Title", msg.Title)
+	}
+	if msg.URL != "" {
+		header.Set("Click", msg.URL)
+	}
+	return post(ctx, n.Client, n.URL, header, []byte(body))
+}
+
+// Discord posts the message as an embed to a Discord webhook
+type Discord struct {
+	URL    string
+	Client *http.Client
+}
+
+// colors of Discord embeds by event
+var discordColors = map[string]int{
+	EventUpdateReleased: 0xcf7336,
+	EventServerDown:     0xb33a3a,
+	EventServerUp:       0x5b7e3b,
+	EventServerOutdated: 0xe0b03c,
+}
+
+func (d *Discord) Name() string { return "discord " + host(d.URL) }
+
+func (d *Discord) Notify(ctx context.Context, msg Message) error {
+	type embed struct {
+		Title       string `json:"title"`
+		Description string `json:"description,omitempty"`
+		URL         string `json:"url,omitempty"`
+		Color       int    `json:"color,omitempty"`
+		Timestamp   string `json:"timestamp"`
+	}
+	payload := struct {
+		Embeds []embed `json:"embeds"`
+	}{[]embed{{
+		Title:       msg.Title,
+		Description: msg.Text,
+		URL:         msg.URL,
+		Color:       discordColors[msg.Event],
+		Timestamp:   msg.Time.Format(time.RFC3339),
+	}}}
+	return postJSON(ctx, d.Client, d.URL, nil, payload)
+}
+
+// Slack posts the message to a Slack incoming webhook, or anything accepting the same payload
+type Slack struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s *Slack) Name() string { return "slack " + host(s.URL) }
+
+func (s *Slack) Notify(ctx context.Context, msg Message) error {
+	title := "*" + msg.Title + "*"
+	if msg.URL != "" {
+		title = "*<" + msg.URL + "|" + msg.Title + ">*"
+	}
+	text := title
+	if msg.Text != "" {
+		text += "\n" + msg.Text
+	}
+	return postJSON(ctx, s.Client, s.URL, nil, map[string]string{"text": text})
+}
+
+// SignatureHeader carries the HMAC-SHA256 of a webhook body, as "sha256=<hex>"
+const SignatureHeader = "X-Signature-256"
+
+// Webhook posts the message as JSON. If Secret is set the body is signed with it,
+// so the receiver can check the alert came from us.
+type Webhook struct {
+	URL    string
+	Secret string
+	Client *http.Client
+}
+
+func (w *Webhook) Name() string { return "webhook " + host(w.URL) }
+
+func (w *Webhook) Notify(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{"Content-Type": {"application/json"}}
+	if w.Secret != "" {
+		header.Set(SignatureHeader, Sign(w.Secret, body))
+	}
+	return post(ctx, w.Client, w.URL, header, body)
+}
+
+// Sign returns the value of SignatureHeader for body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func postJSON(ctx context.Context, client *http.Client, target string, header http.Header, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Content-Type", "application/json")
+	return post(ctx, client, target, header, body)
+}
+
+func post(ctx context.Context, client *http.Client, target string, header http.Header, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = header
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// host returns the host of a target URL, which unlike the full URL of a webhook isn't a secret
+func host(target string) string {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return "(invalid url)"
+	}
+	return u.Host
+}
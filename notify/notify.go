@@ -0,0 +1,205 @@
+// Package notify sends alerts, such as a TF2 update being released or a server going down,
+// to any number of configured targets like ntfy, Discord and Slack.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Alert events
+const (
+	EventUpdateReleased = "update_released"
+	EventServerDown     = "server_down"
+	EventServerUp       = "server_up"
+	EventServerOutdated = "server_outdated"
+)
+
+// Message is a rendered alert, ready to be delivered
+type Message struct {
+	Event string    `json:"event"`
+	Title string    `json:"title"`
+	Text  string    `json:"text"`
+	URL   string    `json:"url,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// Notifier delivers messages to one target
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+	// Name identifies the target in logs without revealing its URL
+	Name() string
+}
+
+// Alert is something that happened, rendered into a Message with the template for its event
+type Alert struct {
+	Event string
+	Data  map[string]string // values for the templates, e.g. Server or Title
+	URL   string
+}
+
+// Template renders the title and text of a message from an alert's data
+type Template struct {
+	Title *template.Template
+	Text  *template.Template
+}
+
+// NewTemplate parses a title and text template. It panics if either doesn't parse, like template.Must.
+func NewTemplate(title, text string) Template {
+	return Template{
+		Title: template.Must(parseTemplate("title", title)),
+		Text:  template.Must(parseTemplate("text", text)),
+	}
+}
+
+// Override returns the template with its title and text replaced by the ones given.
+// An empty title or text keeps the current one.
+func (t Template) Override(title, text string) (Template, error) {
+	var err error
+	if title != "" {
+		if t.Title, err = parseTemplate("title", title); err != nil {
+			return t, fmt.Errorf("title: %w", err)
+		}
+	}
+	if text != "" {
+		if t.Text, err = parseTemplate("text", text); err != nil {
+			return t, fmt.Errorf("text: %w", err)
+		}
+	}
+	return t, nil
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=zero").Parse(text)
+}
+
+// DefaultTemplates are the templates a Dispatcher starts with
+func DefaultTemplates() map[string]Template {
+	return map[string]Template{
+		EventUpdateReleased: NewTemplate("New TF2 Update Released!", "{{.Title}}"),
+		EventServerDown: NewTemplate("{{.Server}} is down",
+			"{{.Server}} ({{.Address}}) failed {{.Failures}} polls in a row: {{.Error}}"),
+		EventServerUp: NewTemplate("{{.Server}} is back",
+			"{{.Server}} ({{.Address}}) is answering again after {{.Downtime}}"),
+		EventServerOutdated: NewTemplate("{{.Server}} is outdated",
+			"{{.Server}} ({{.Address}}) is running version {{.Version}}, the newest seen on the fleet is {{.Newest}}"),
+	}
+}
+
+// Dispatcher renders alerts and delivers them to every target in the background,
+// retrying failed deliveries with exponential backoff. A nil Dispatcher drops every alert.
+type Dispatcher struct {
+	Templates map[string]Template
+	Attempts  int           // deliveries tried per target
+	Backoff   time.Duration // wait before the first retry, doubled for each one after
+	Timeout   time.Duration // limit on each delivery attempt
+
+	targets []Notifier
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func NewDispatcher(targets ...Notifier) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		Templates: DefaultTemplates(),
+		Attempts:  4,
+		Backoff:   5 * time.Second,
+		Timeout:   10 * time.Second,
+		targets:   targets,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Send renders alert and starts delivering it to every target. It doesn't wait for delivery.
+func (d *Dispatcher) Send(alert Alert) {
+	if d == nil || len(d.targets) == 0 {
+		return
+	}
+
+	msg, err := d.render(alert)
+	if err != nil {
+		log.Printf("Error rendering %s alert: %v", alert.Event, err)
+		return
+	}
+
+	for _, target := range d.targets {
+		d.wg.Add(1)
+		go func(target Notifier) {
+			defer d.wg.Done()
+			d.deliver(target, msg)
+		}(target)
+	}
+}
+
+// Wait blocks until every alert sent so far has been delivered or given up on
+func (d *Dispatcher) Wait() {
+	if d != nil {
+		d.wg.Wait()
+	}
+}
+
+// Close stops retrying and waits for deliveries in progress to finish
+func (d *Dispatcher) Close() {
+	if d != nil {
+		d.cancel()
+		d.wg.Wait()
+	}
+}
+
+func (d *Dispatcher) render(alert Alert) (Message, error) {
+	msg := Message{Event: alert.Event, URL: alert.URL, Time: time.Now().UTC()}
+
+	tmpl, ok := d.Templates[alert.Event]
+	if !ok {
+		msg.Title = alert.Event
+		return msg, nil
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Title.Execute(&buf, alert.Data); err != nil {
+		return msg, err
+	}
+	msg.Title = buf.String()
+
+	buf.Reset()
+	if err := tmpl.Text.Execute(&buf, alert.Data); err != nil {
+		return msg, err
+	}
+	msg.Text = buf.String()
+
+	return msg, nil
+}
+
+func (d *Dispatcher) deliver(target Notifier, msg Message) {
+	backoff := d.Backoff
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(d.ctx, d.Timeout)
+		err := target.Notify(ctx, msg)
+		cancel()
+		if err == nil {
+			log.Printf("Sent %s alert to %s", msg.Event, target.Name())
+			return
+		}
+
+		if attempt >= d.Attempts {
+			log.Printf("Giving up on %s alert to %s after %d attempts: %v", msg.Event, target.Name(), attempt, err)
+			return
+		}
+		log.Printf("Error sending %s alert to %s, retrying in %s: %v", msg.Event, target.Name(), backoff, err)
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
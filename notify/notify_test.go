@@ -0,0 +1,209 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type request struct {
+	header http.Header
+	body   string
+}
+
+// recordServer answers with the given status codes in turn, then 200, and records every request
+func recordServer(t *testing.T, statuses ...int) (*httptest.Server, func() []request) {
+	var mu sync.Mutex
+	var requests []request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, request{r.Header, string(body)})
+		if len(requests) <= len(statuses) {
+			w.WriteHeader(statuses[len(requests)-1])
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []request {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]request(nil), requests...)
+	}
+}
+
+var testMessage = Message{
+	Event: EventServerDown,
+	Title: "surf (us) is down",
+	Text:  "surf (us) failed 3 polls in a row",
+	URL:   "https://servers.tf2dl.net/servers/i-1",
+	Time:  time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC),
+}
+
+// Each backend sends the payload its service expects
+func TestBackends(t *testing.T) {
+	server, requests := recordServer(t)
+
+	backends := []Notifier{
+		&Ntfy{URL: server.URL},
+		&Discord{URL: server.URL},
+		&Slack{URL: server.URL},
+		&Webhook{URL: server.URL, Secret: "secret"},
+	}
+	for _, backend := range backends {
+		if err := backend.Notify(context.Background(), testMessage); err != nil {
+			t.Fatalf("%s failed: %v", backend.Name(), err)
+		}
+	}
+
+	got := requests()
+	if ntfy := got[0]; ntfy.body != testMessage.Text || ntfy.header.Get("Title") != testMessage.Title || ntfy.header.Get("Click") != testMessage.URL {
+		t.Errorf("Unexpected ntfy request %+v", ntfy)
+	}
+
+	var discord struct {
+		Embeds []struct {
+			Title, Description, URL, Timestamp string
+			Color                              int
+		}
+	}
+	if err := json.Unmarshal([]byte(got[1].body), &discord); err != nil || len(discord.Embeds) != 1 {
+		t.Fatalf("Unexpected discord body %s", got[1].body)
+	}
+	if embed := discord.Embeds[0]; embed.Title != testMessage.Title || embed.Color == 0 || embed.Timestamp != "2026-10-18T10:00:00Z" {
+		t.Errorf("Unexpected discord embed %+v", embed)
+	}
+
+	var slack struct{ Text string }
+	json.Unmarshal([]byte(got[2].body), &slack)
+	if expected := "*<https://servers.tf2dl.net/servers/i-1|surf (us) is down>*\nsurf (us) failed 3 polls in a row"; slack.Text != expected {
+		t.Errorf("Unexpected slack body %s", got[2].body)
+	}
+
+	webhook := got[3]
+	if signature := webhook.header.Get(SignatureHeader); signature != Sign("secret", []byte(webhook.body)) {
+		t.Errorf("Expected webhook body to be signed, got %q", signature)
+	}
+	var decoded Message
+	if err := json.Unmarshal([]byte(webhook.body), &decoded); err != nil || decoded != testMessage {
+		t.Errorf("Expected webhook body to decode to the message, got %+v %v", decoded, err)
+	}
+}
+
+// Failed deliveries are retried with backoff until they succeed or run out of attempts
+func TestDispatcherRetries(t *testing.T) {
+	flaky, flakyRequests := recordServer(t, 500, 502)
+	broken, brokenRequests := recordServer(t, 500, 500, 500, 500)
+
+	d := NewDispatcher(&Ntfy{URL: flaky.URL}, &Ntfy{URL: broken.URL})
+	d.Backoff = time.Millisecond
+	d.Attempts = 3
+	d.Send(Alert{Event: EventUpdateReleased, Data: map[string]string{"Title": "Team Fortress 2 Update Released"}})
+	d.Wait()
+
+	got := flakyRequests()
+	if len(got) != 3 {
+		t.Fatalf("Expected 3 attempts on the flaky target, got %d", len(got))
+	}
+	if got[2].header.Get("Title") != "New TF2 Update Released!" || got[2].body != "Team Fortress 2 Update Released" {
+		t.Errorf("Unexpected update message %+v", got[2])
+	}
+	if n := len(brokenRequests()); n != 3 {
+		t.Errorf("Expected to give up after 3 attempts, got %d", n)
+	}
+}
+
+type stubNotifier struct{ calls int }
+
+func (s *stubNotifier) Name() string { return "stub" }
+
+func (s *stubNotifier) Notify(ctx context.Context, msg Message) error {
+	s.calls++
+	return errors.New("unavailable")
+}
+
+// Closing the dispatcher stops waiting to retry
+func TestDispatcherClose(t *testing.T) {
+	stub := &stubNotifier{}
+	d := NewDispatcher(stub)
+	d.Backoff = time.Hour
+	d.Send(Alert{Event: EventServerUp})
+
+	done := make(chan struct{})
+	go func() {
+		d.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not stop the retry")
+	}
+	if stub.calls != 1 {
+		t.Errorf("Expected 1 attempt, got %d", stub.calls)
+	}
+
+	var nilDispatcher *Dispatcher
+	nilDispatcher.Send(Alert{Event: EventServerUp}) // must not panic
+}
+
+// Templates can be replaced per event and missing values render empty
+func TestTemplates(t *testing.T) {
+	d := NewDispatcher()
+	d.Templates[EventServerDown] = NewTemplate("DOWN {{.Server}}", "{{.Missing}}")
+
+	msg, err := d.render(Alert{Event: EventServerDown, Data: map[string]string{"Server": "surf (eu)"}})
+	if err != nil {
+		t.Fatalf("Failed to render: %v", err)
+	}
+	if msg.Title != "DOWN surf (eu)" || msg.Text != "" {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+
+	if _, err := d.Templates[EventServerUp].Override("{{.Server", ""); err == nil {
+		t.Errorf("Expected an error for a template that doesn't parse")
+	}
+	d.Templates[EventServerDown], _ = d.Templates[EventServerDown].Override("", "{{.Server}} stopped answering")
+	if msg, _ := d.render(Alert{Event: EventServerDown, Data: map[string]string{"Server": "surf (eu)"}}); msg.Title != "DOWN surf (eu)" || msg.Text != "surf (eu) stopped answering" {
+		t.Errorf("Expected only the text to be overridden, got %+v", msg)
+	}
+
+	msg, _ = d.render(Alert{Event: EventServerUp, Data: map[string]string{"Server": "surf (eu)", "Address": "10.0.0.1", "Downtime": "5m0s"}})
+	if msg.Text != "surf (eu) (10.0.0.1) is answering again after 5m0s" {
+		t.Errorf("Unexpected default text %q", msg.Text)
+	}
+}
+
+func TestParseTargets(t *testing.T) {
+	targets, err := ParseTargets("ntfy:https://ntfy.sh/tf2dl, discord:https://discord.com/api/webhooks/1/abc\nwebhook:http://localhost:9000/hook", "secret")
+	if err != nil {
+		t.Fatalf("Failed to parse targets: %v", err)
+	}
+	var names []string
+	for _, target := range targets {
+		names = append(names, target.Name())
+	}
+	if strings.Join(names, ",") != "ntfy ntfy.sh,discord discord.com,webhook localhost:9000" {
+		t.Errorf("Unexpected targets %v", names)
+	}
+	if webhook := targets[2].(*Webhook); webhook.Secret != "secret" {
+		t.Errorf("Expected webhook to be signed")
+	}
+
+	for _, spec := range []string{"ntfy", "email:https://example.com", "slack:not a url", "slack:ftp://example.com"} {
+		if _, err := ParseTargets(spec, ""); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+	if targets, err := ParseTargets("  ", ""); err != nil || len(targets) != 0 {
+		t.Errorf("Expected no targets, got %v %v", targets, err)
+	}
+}
@@ -0,0 +1,50 @@
+package notify
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Target kinds accepted by ParseTargets
+const (
+	KindNtfy    = "ntfy"
+	KindDiscord = "discord"
+	KindSlack   = "slack"
+	KindWebhook = "webhook"
+)
+
+// ParseTargets creates notifiers from a list of kind:url entries separated by commas or whitespace,
+// e.g. "ntfy:https://ntfy.sh/tf2dl, discord:https://discord.com/api/webhooks/1/abc".
+// webhook targets are signed with secret if it isn't empty.
+func ParseTargets(spec, secret string) ([]Notifier, error) {
+	client := &http.Client{Timeout: 15 * time.Second}
+
+	var targets []Notifier
+	for _, entry := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' || r == '\t' }) {
+		kind, target, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("notify target %q is not kind:url", entry)
+		}
+		if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("notify target %q has an invalid url", kind)
+		}
+
+		switch kind {
+		case KindNtfy:
+			targets = append(targets, &Ntfy{URL: target, Client: client})
+		case KindDiscord:
+			targets = append(targets, &Discord{URL: target, Client: client})
+		case KindSlack:
+			targets = append(targets, &Slack{URL: target, Client: client})
+		case KindWebhook:
+			targets = append(targets, &Webhook{URL: target, Secret: secret, Client: client})
+		default:
+			return nil, fmt.Errorf("unknown notify target kind %q", kind)
+		}
+	}
+
+	return targets, nil
+}
@@ -0,0 +1,171 @@
+// Package parser turns the output of the TF2 `status` console command into typed data.
+package parser
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotStatus is returned when a response doesn't look like `status` output
+var ErrNotStatus = errors.New("parser: response is not status output")
+
+type Status struct {
+	Hostname   string   `json:"hostname"`
+	Version    int      `json:"version"`
+	Protocol   int      `json:"protocol"`
+	Secure     bool     `json:"secure"`
+	Outdated   bool     `json:"outdated"`
+	LocalAddr  string   `json:"local_addr"`          // udp/ip the server is bound to
+	PublicIP   string   `json:"public_ip,omitempty"` // as reported by the server, may differ from the address polled
+	ServerID   string   `json:"server_id,omitempty"` // the server's own steamid, e.g. [G:1:4913213]
+	Map        string   `json:"map"`
+	Tags       []string `json:"tags,omitempty"`
+	Humans     int      `json:"humans"`
+	Bots       int      `json:"bots"`
+	MaxPlayers int      `json:"max_players"`
+	Players    []Player `json:"players"`
+}
+
+type Player struct {
+	UserID    int           `json:"userid"`
+	Name      string        `json:"name"`
+	SteamID   string        `json:"steam_id"` // [U:1:n], or BOT
+	Connected time.Duration `json:"connected"`
+	Ping      int           `json:"ping"`
+	Loss      int           `json:"loss"`
+	State     string        `json:"state"`
+	Address   string        `json:"address,omitempty"`
+}
+
+var (
+	headerRe  = regexp.MustCompile(`^([a-z/]+)\s*:\s*(.*)$`)
+	playerRe  = regexp.MustCompile(`^#\s*(\d+)\s+"(.*)"\s+(\S+)\s*(.*)$`)
+	versionRe = regexp.MustCompile(`^(\d+)/(\d+)\s+\d+\s+(secure|insecure)?`)
+	publicRe  = regexp.MustCompile(`\(public ip(?: from steam)?:\s*([^,)\s]+)`)
+	countsRe  = regexp.MustCompile(`(\d+)\s*humans?,\s*(\d+)\s*bots?\s*\((\d+)\s*max\)`)
+)
+
+// Parse reads a full `status` response. Lines it doesn't recognise are skipped, so partial or
+// newer output still yields whatever could be read; only a response without hostname or map fails.
+func Parse(response string) (Status, error) {
+	var status Status
+	for _, line := range strings.Split(response, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			if player, ok := parsePlayer(line); ok {
+				status.Players = append(status.Players, player)
+			}
+			continue
+		}
+
+		match := headerRe.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		value := strings.TrimSpace(match[2])
+
+		switch match[1] {
+		case "hostname":
+			status.Hostname = value
+		case "version":
+			parseVersion(value, &status)
+		case "udp/ip":
+			if fields := strings.Fields(value); len(fields) > 0 {
+				status.LocalAddr = fields[0]
+			}
+			if m := publicRe.FindStringSubmatch(strings.ToLower(value)); m != nil {
+				status.PublicIP = m[1]
+			}
+		case "steamid":
+			if fields := strings.Fields(value); len(fields) > 0 {
+				status.ServerID = fields[0]
+			}
+		case "map":
+			if fields := strings.Fields(value); len(fields) > 0 {
+				status.Map = fields[0]
+			}
+		case "tags":
+			for _, tag := range strings.Split(value, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					status.Tags = append(status.Tags, tag)
+				}
+			}
+		case "players":
+			if m := countsRe.FindStringSubmatch(value); m != nil {
+				status.Humans, _ = strconv.Atoi(m[1])
+				status.Bots, _ = strconv.Atoi(m[2])
+				status.MaxPlayers, _ = strconv.Atoi(m[3])
+			}
+		}
+	}
+
+	if status.Hostname == "" && status.Map == "" {
+		return status, ErrNotStatus
+	}
+	return status, nil
+}
+
+// IsBot reports whether the player is a bot or SourceTV
+func (p Player) IsBot() bool {
+	return p.SteamID == "BOT"
+}
+
+func parseVersion(value string, status *Status) {
+	if m := versionRe.FindStringSubmatch(value); m != nil {
+		status.Version, _ = strconv.Atoi(m[1])
+		status.Protocol, _ = strconv.Atoi(m[2])
+		status.Secure = m[3] == "secure"
+	}
+	status.Outdated = strings.Contains(value, "outdated")
+}
+
+// parsePlayer reads a row of the player table, e.g.
+//
+//	#      3 "Player One"        [U:1:12345678]      12:34       45    0 active 203.0.113.7:27005
+//	#      2 "SourceTV"          BOT                                     active
+func parsePlayer(line string) (Player, bool) {
+	match := playerRe.FindStringSubmatch(line)
+	if match == nil {
+		return Player{}, false
+	}
+
+	player := Player{Name: match[2], SteamID: match[3]}
+	player.UserID, _ = strconv.Atoi(match[1])
+
+	fields := strings.Fields(match[4])
+	switch {
+	case len(fields) >= 4:
+		player.Connected = parseConnected(fields[0])
+		player.Ping, _ = strconv.Atoi(fields[1])
+		player.Loss, _ = strconv.Atoi(fields[2])
+		player.State = fields[3]
+		if len(fields) >= 5 {
+			player.Address = fields[4]
+		}
+	case len(fields) > 0:
+		// bots only report their state
+		player.State = fields[len(fields)-1]
+	}
+
+	return player, true
+}
+
+// parseConnected reads a connected time in mm:ss or hh:mm:ss form
+func parseConnected(value string) time.Duration {
+	var total time.Duration
+	for _, part := range strings.Split(value, ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0
+		}
+		total = total*60 + time.Duration(n)
+	}
+	return total * time.Second
+}
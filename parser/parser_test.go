@@ -0,0 +1,103 @@
+package parser
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func readFixture(t *testing.T, name string) string {
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatalf("Failed to read fixture %s: %v", name, err)
+	}
+	return string(data)
+}
+
+// Test a populated server with a bot, a name containing quotes and a player still spawning
+func TestParsePlayers(t *testing.T) {
+	status, err := Parse(readFixture(t, "status_players.txt"))
+	if err != nil {
+		t.Fatalf("Failed to parse status: %v", err)
+	}
+
+	if status.Hostname != "simple surf server (us) - servers.tf2dl.net" {
+		t.Errorf("Unexpected hostname %q", status.Hostname)
+	}
+	if status.Version != 8835751 || status.Protocol != 24 || !status.Secure || status.Outdated {
+		t.Errorf("Unexpected version fields %d/%d secure=%v outdated=%v", status.Version, status.Protocol, status.Secure, status.Outdated)
+	}
+	if status.LocalAddr != "0.0.0.0:27015" || status.PublicIP != "54.193.198.90" {
+		t.Errorf("Unexpected addresses %q %q", status.LocalAddr, status.PublicIP)
+	}
+	if status.ServerID != "[G:1:4913213]" {
+		t.Errorf("Unexpected server id %q", status.ServerID)
+	}
+	if status.Map != "surf_utopia_v3" {
+		t.Errorf("Unexpected map %q", status.Map)
+	}
+	if !reflect.DeepEqual(status.Tags, []string{"surf", "nocrits"}) {
+		t.Errorf("Unexpected tags %v", status.Tags)
+	}
+	if status.Humans != 3 || status.Bots != 1 || status.MaxPlayers != 24 {
+		t.Errorf("Unexpected counts %d humans %d bots %d max", status.Humans, status.Bots, status.MaxPlayers)
+	}
+
+	expected := []Player{
+		{UserID: 2, Name: "SourceTV", SteamID: "BOT", State: "active"},
+		{UserID: 3, Name: "Player One", SteamID: "[U:1:12345678]", Connected: 12*time.Minute + 34*time.Second, Ping: 45, Loss: 0, State: "active", Address: "203.0.113.7:27005"},
+		{UserID: 4, Name: `name with "quotes"`, SteamID: "[U:1:87654321]", Connected: time.Hour + 2*time.Minute + 3*time.Second, Ping: 80, Loss: 2, State: "active", Address: "198.51.100.22:27005"},
+		{UserID: 5, Name: "joining", SteamID: "[U:1:1000]", Connected: 8 * time.Second, Ping: 210, Loss: 0, State: "spawning", Address: "192.0.2.1:27005"},
+	}
+	if !reflect.DeepEqual(status.Players, expected) {
+		t.Errorf("Unexpected players\n got: %+v\nwant: %+v", status.Players, expected)
+	}
+
+	if !status.Players[0].IsBot() || status.Players[1].IsBot() {
+		t.Errorf("IsBot misclassified players")
+	}
+}
+
+// Test an empty server, which only has the table header
+func TestParseEmpty(t *testing.T) {
+	status, err := Parse(readFixture(t, "status_empty.txt"))
+	if err != nil {
+		t.Fatalf("Failed to parse status: %v", err)
+	}
+
+	if status.Map != "surf_kitsune" || status.Humans != 0 || status.MaxPlayers != 24 {
+		t.Errorf("Unexpected status %+v", status)
+	}
+	if len(status.Players) != 0 {
+		t.Errorf("Expected no players, got %+v", status.Players)
+	}
+}
+
+// Test an insecure, outdated server behind Steam datagram relay with a listen-server player
+func TestParseInsecureSDR(t *testing.T) {
+	status, err := Parse(readFixture(t, "status_insecure_sdr.txt"))
+	if err != nil {
+		t.Fatalf("Failed to parse status: %v", err)
+	}
+
+	if status.Secure || !status.Outdated || status.Version != 8622567 {
+		t.Errorf("Unexpected version fields %+v", status)
+	}
+	if status.LocalAddr != "169.254.121.50:48312" || status.PublicIP != "45.121.184.10" {
+		t.Errorf("Unexpected addresses %q %q", status.LocalAddr, status.PublicIP)
+	}
+	if status.Humans != 1 || status.Bots != 2 || len(status.Players) != 3 {
+		t.Errorf("Unexpected counts %+v", status)
+	}
+	if player := status.Players[2]; player.Address != "loopback" || player.Connected != 5*time.Minute {
+		t.Errorf("Unexpected loopback player %+v", player)
+	}
+}
+
+// Test that responses that aren't status output are rejected
+func TestParseNotStatus(t *testing.T) {
+	if _, err := Parse("Unknown command \"stauts\"\n"); err != ErrNotStatus {
+		t.Errorf("Expected ErrNotStatus, got %v", err)
+	}
+}
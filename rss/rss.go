@@ -0,0 +1,200 @@
+// Package rss watches the TF2 news feed for posts announcing a game update.
+package rss
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+
+	"github.com/sawatkins/tf2dl-servers/models"
+)
+
+// UpdateTitle is in the title of every post announcing a game update
+const UpdateTitle = "Team Fortress 2 Update Released"
+
+// Check outcomes
+const (
+	OutcomeError     = "error"
+	OutcomeUnchanged = "unchanged"
+	OutcomeNewItem   = "new_item"
+	OutcomeUpdate    = "update"
+)
+
+// Store keeps what a Checker has seen of a feed
+type Store interface {
+	// FeedState returns the saved state of the feed at url, with only the URL set if there is none
+	FeedState(url string) (models.FeedState, error)
+	SaveFeedState(state models.FeedState) error
+}
+
+// Checker fetches a feed periodically and calls OnUpdate for each new update post.
+// What it has seen is kept in its store, so a restart doesn't announce old posts again.
+type Checker struct {
+	URL      string
+	Interval time.Duration
+	Client   *http.Client
+	OnUpdate func(item *gofeed.Item)
+	OnCheck  func(outcome string) // called after every check Run makes, e.g. to count them
+
+	store Store
+}
+
+func NewChecker(url string, store Store, onUpdate func(item *gofeed.Item)) *Checker {
+	return &Checker{
+		URL:      url,
+		Interval: 3 * time.Hour,
+		Client:   &http.Client{Timeout: 30 * time.Second},
+		OnUpdate: onUpdate,
+		store:    store,
+	}
+}
+
+// Run checks the feed right away, then every Interval until ctx is done
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		outcome, err := c.Check(ctx)
+		if err != nil {
+			log.Printf("Error checking TF2 RSS feed: %v", err)
+		}
+		if c.OnCheck != nil {
+			c.OnCheck(outcome)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check fetches the feed once, calls OnUpdate for every update posted since the last check,
+// oldest first, and returns the outcome. The first check of
+// a feed only remembers where it is, without announcing anything.
+func (c *Checker) Check(ctx context.Context) (string, error) {
+	state, err := c.store.FeedState(c.URL)
+	if err != nil {
+		return OutcomeError, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		return OutcomeError, err
+	}
+	if state.ETag != "" {
+		req.Header.Set("If-None-Match", state.ETag)
+	}
+	if state.LastModified != "" {
+		req.Header.Set("If-Modified-Since", state.LastModified)
+	}
+
+	log.Println("Fetching rss feed")
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return OutcomeError, err
+	}
+	defer resp.Body.Close()
+
+	state.CheckedAt = time.Now().Unix()
+	if resp.StatusCode == http.StatusNotModified {
+		return OutcomeUnchanged, c.store.SaveFeedState(state)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return OutcomeError, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	feed, err := gofeed.NewParser().Parse(resp.Body)
+	if err != nil {
+		return OutcomeError, err
+	}
+
+	firstCheck := state.LastGUID == "" && state.LastPublished == 0
+	items := newItems(feed.Items, state)
+
+	state.ETag = resp.Header.Get("ETag")
+	state.LastModified = resp.Header.Get("Last-Modified")
+	if len(feed.Items) > 0 {
+		state.LastGUID = itemID(feed.Items[0])
+	}
+	for _, item := range feed.Items {
+		if published := itemTime(item); published != nil {
+			state.LastPublished = max(state.LastPublished, published.Unix())
+		}
+	}
+	if err := c.store.SaveFeedState(state); err != nil {
+		return OutcomeError, err
+	}
+
+	if firstCheck || len(items) == 0 {
+		return OutcomeUnchanged, nil
+	}
+
+	outcome := OutcomeNewItem
+	for _, item := range items {
+		if !strings.Contains(item.Title, UpdateTitle) {
+			continue
+		}
+		log.Printf("New TF2 update: %s", item.Title)
+		outcome = OutcomeUpdate
+		if c.OnUpdate != nil {
+			c.OnUpdate(item)
+		}
+	}
+	return outcome, nil
+}
+
+// newItems returns the items posted since state, oldest first. Feeds list the newest item first,
+// so an item without a date is new if it comes before the last one seen. If the last one seen
+// has dropped off the feed, undated items are assumed to be old.
+func newItems(items []*gofeed.Item, state models.FeedState) []*gofeed.Item {
+	seen := 0
+	for i, item := range items {
+		if state.LastGUID != "" && itemID(item) == state.LastGUID {
+			seen = i
+			break
+		}
+	}
+
+	var fresh []*gofeed.Item
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		if state.LastGUID != "" && itemID(item) == state.LastGUID {
+			continue
+		}
+		if published := itemTime(item); published != nil {
+			if published.Unix() > state.LastPublished {
+				fresh = append(fresh, item)
+			}
+		} else if i < seen {
+			fresh = append(fresh, item)
+		}
+	}
+	return fresh
+}
+
+// itemID identifies an item by its GUID, falling back to its link and title
+func itemID(item *gofeed.Item) string {
+	switch {
+	case item.GUID != "":
+		return item.GUID
+	case item.Link != "":
+		return item.Link
+	default:
+		return item.Title
+	}
+}
+
+// itemTime returns when an item was published or failing that updated, nil if it has neither
+func itemTime(item *gofeed.Item) *time.Time {
+	if item.PublishedParsed != nil {
+		return item.PublishedParsed
+	}
+	return item.UpdatedParsed
+}
@@ -0,0 +1,117 @@
+package rss
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+
+	"github.com/sawatkins/tf2dl-servers/models"
+)
+
+// memoryStore keeps feed states in a map
+type memoryStore map[string]models.FeedState
+
+func (m memoryStore) FeedState(url string) (models.FeedState, error) {
+	if state, ok := m[url]; ok {
+		return state, nil
+	}
+	return models.FeedState{URL: url}, nil
+}
+
+func (m memoryStore) SaveFeedState(state models.FeedState) error {
+	m[state.URL] = state
+	return nil
+}
+
+// feedServer serves a file from testdata, with an ETag unless it is empty
+type feedServer struct {
+	mu          sync.Mutex
+	file        string
+	etag        string
+	status      int
+	conditional int // requests that carried If-None-Match
+}
+
+func (f *feedServer) set(file, etag string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.file, f.etag = file, etag
+}
+
+func (f *feedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		return
+	}
+	if r.Header.Get("If-None-Match") != "" {
+		f.conditional++
+	}
+
+	content, err := os.Open("testdata/" + f.file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+	if f.etag != "" {
+		w.Header().Set("ETag", f.etag)
+	}
+	http.ServeContent(w, r, f.file, time.Time{}, content)
+}
+
+func TestCheck(t *testing.T) {
+	store := memoryStore{}
+	feed := &feedServer{}
+	feed.set("feed_old.xml", `"old"`)
+	server := httptest.NewServer(feed)
+	t.Cleanup(server.Close)
+
+	var announced []string
+	checker := NewChecker(server.URL, store, func(item *gofeed.Item) {
+		announced = append(announced, item.Link)
+	})
+	ctx := context.Background()
+
+	// the first check only remembers the latest post
+	if outcome, err := checker.Check(ctx); err != nil || outcome != OutcomeUnchanged {
+		t.Fatalf("Expected the first check to be unchanged, got %s %v", outcome, err)
+	}
+	if len(announced) != 0 {
+		t.Errorf("Expected nothing announced on the first check, got %v", announced)
+	}
+
+	if outcome, _ := checker.Check(ctx); outcome != OutcomeUnchanged || feed.conditional != 1 {
+		t.Errorf("Expected a conditional request for an unchanged feed, got %s after %d", outcome, feed.conditional)
+	}
+
+	// two updates and a news post, the newest without a date
+	feed.set("feed_new.xml", `"new"`)
+	outcome, err := checker.Check(ctx)
+	if err != nil || outcome != OutcomeUpdate {
+		t.Fatalf("Expected an update, got %s %v", outcome, err)
+	}
+	expected := []string{"https://www.teamfortress.com/post.php?id=4", "https://www.teamfortress.com/post.php?id=5"}
+	if len(announced) != 2 || announced[0] != expected[0] || announced[1] != expected[1] {
+		t.Errorf("Expected %v announced oldest first, got %v", expected, announced)
+	}
+
+	// a restart reads what was seen from the store, even without an ETag
+	feed.set("feed_new.xml", "")
+	restarted := NewChecker(server.URL, store, checker.OnUpdate)
+	if outcome, err := restarted.Check(ctx); err != nil || outcome != OutcomeUnchanged || len(announced) != 2 {
+		t.Errorf("Expected nothing new after a restart, got %s %v %v", outcome, err, announced)
+	}
+
+	feed.status = http.StatusBadGateway
+	if outcome, err := restarted.Check(ctx); err == nil || outcome != OutcomeError {
+		t.Errorf("Expected an error, got %s %v", outcome, err)
+	}
+}
@@ -1,73 +1,73 @@
-const POLL_INTERVAL = 35000; // 35 seconds
+const POLL_INTERVAL = 35000; // 35 seconds, only used by browsers without EventSource
 
-// Function to fetch server IPs
-async function fetchServerIPs() {
+// Function to fetch every server in one request
+async function fetchServers() {
     try {
-        const response = await fetch("/api/server-ips");
-        const ips = await response.json();
-        // console.log("ips", ips);
-        return ips;
+        const response = await fetch("/api/servers");
+        return await response.json();
     } catch (error) {
-        console.error('Error fetching server IPs:', error);
+        console.error('Error fetching servers:', error);
         return [];
     }
 }
 
-// Function to fetch server info
-async function fetchServerInfo(ip) {
-    try {
-        const response = await fetch(`/api/server-info?ip=${ip}`);
-        const serverInfo = await response.json();
-        // console.log("serverInfo", serverInfo);
-        return serverInfo;
-    } catch (error) {
-        console.error(`Error fetching server info for ${ip}:`, error);
-        return null;
+// Function to create an element with attributes and children, text children are set as text rather than HTML
+function createElement(tag, attributes, ...children) {
+    const element = document.createElement(tag);
+    for (const [name, value] of Object.entries(attributes)) {
+        element.setAttribute(name, value);
     }
+    element.append(...children);
+    return element;
 }
 
-// Function to update table row
+// Function to update table row, keeping rows in the servers' sort order
 function updateTableRow(serverInfo) {
     const tableBody = document.querySelector('#server-table table tbody');
-    let row = tableBody.querySelector(`tr[data-ip="${serverInfo.public_ip}"]`);
+    let row = tableBody.querySelector(`tr[data-ip="${CSS.escape(serverInfo.public_ip)}"]`);
     
     if (!row) {
         row = document.createElement('tr');
         row.setAttribute('data-ip', serverInfo.public_ip);
         tableBody.appendChild(row);
     }
-
-    // console.log("serverInfo", serverInfo);
-
-    // temportary fix
-    if (serverInfo.public_ip === "54.193.198.90") {
-        region = "us-west";
-    }
-    else {
-        region = "eu-central";
+    row.setAttribute('data-sort', serverInfo.sort_order);
+    const next = Array.from(tableBody.querySelectorAll('tr[data-ip]'))
+        .find(other => other !== row && Number(other.dataset.sort) > serverInfo.sort_order);
+    if (next) {
+        tableBody.insertBefore(row, next);
     }
 
-    row.innerHTML = `
-        <td class="region ${region}">
-            <img src="/img/${region}.svg" alt="${region} flag" class="flag-icon">
-            <span>${region}</span>
-        </td>
-        <td class="online-status">Online</td>
-        <td>${serverInfo.map}</td>
-        <td>${serverInfo.players}/${serverInfo.max_players}</td>
-        <td>
-            <a href="steam://connect/${serverInfo.public_ip}:27015">Connect</a>
-            <span class="copy-icon" title="Copy ${serverInfo.public_ip}" onclick="copyToClipboard(this, '${serverInfo.public_ip}')">
-                <img src="/img/copy.svg" alt="Copy ${serverInfo.public_ip}" style="width: 16px; height: 16px; vertical-align: middle;">
-            </span>
-        </td>
-    `;
+    const serverURL = `/servers/${encodeURIComponent(serverInfo.instance_id)}`;
+    const name = createElement('td', {class: `region ${serverInfo.region}`});
+    if (serverInfo.flag) {
+        name.append(createElement('img', {src: serverInfo.flag, alt: `${serverInfo.display_name} flag`, class: 'flag-icon'}), ' ');
+    }
+    name.append(createElement('a', {href: serverURL}, serverInfo.display_name));
+
+    const status = serverInfo.online === false
+        ? createElement('td', {class: 'offline-status'}, 'Offline')
+        : serverInfo.outdated
+            ? createElement('td', {class: 'outdated-status', title: 'Running an old version of TF2'}, 'Outdated')
+            : createElement('td', {class: 'online-status'}, 'Online');
+
+    const copy = createElement('span', {class: 'copy-icon', title: `Copy ${serverInfo.connect}`},
+        createElement('img', {src: '/img/copy.svg', alt: `Copy ${serverInfo.connect}`, style: 'width: 16px; height: 16px; vertical-align: middle;'}));
+    copy.addEventListener('click', () => copyToClipboard(copy, serverInfo.connect));
+
+    row.replaceChildren(
+        name,
+        status,
+        createElement('td', {}, createElement('a', {href: serverURL}, serverInfo.map)),
+        createElement('td', {}, `${serverInfo.players}/${serverInfo.max_players}`),
+        createElement('td', {}, createElement('a', {href: `steam://connect/${serverInfo.connect}`}, 'Connect'), ' ', copy),
+    );
 }
 
-// Function to copy IP to clipboard
-function copyToClipboard(element, ip) {
-    navigator.clipboard.writeText(ip).catch(err => {
-        console.error('Failed to copy IP: ', err);
+// Function to copy a connect address to clipboard
+function copyToClipboard(element, address) {
+    navigator.clipboard.writeText(address).catch(err => {
+        console.error('Failed to copy address: ', err);
     });
 }
 
@@ -92,8 +92,8 @@ async function pollServers() {
     //console.log("polling servers...");
     setRefreshingState();
 
-    const ips = await Promise.race([
-        fetchServerIPs(),
+    const servers = await Promise.race([
+        fetchServers(),
         new Promise(resolve => setTimeout(() => resolve([]), 5000)) // 5 seconds timeout
     ]);
 
@@ -101,16 +101,9 @@ async function pollServers() {
     const defaultRow = document.querySelector('#default-row');
     const table = document.querySelector('#server-table table');
 
-    if (ips.length > 0) {
+    if (servers.length > 0) {
         defaultRow.style.display = 'none';
-        ips.sort().reverse();
-        
-        const serverInfos = await Promise.all(ips.map(async (ip) => {
-            const serverInfo = await fetchServerInfo(ip);
-            return serverInfo;
-        }));
-
-        for (const serverInfo of serverInfos) {
+        for (const serverInfo of servers) {
             updateTableRow(serverInfo);
         }
     } else {
@@ -125,13 +118,50 @@ async function pollServers() {
     setReadyState();
 }
 
-// Start polling
-setInterval(pollServers, POLL_INTERVAL);
-pollServers();
+// Function to remove a server's row, showing the default row once none are left
+function removeTableRow(ip) {
+    const row = document.querySelector(`#server-table tr[data-ip="${CSS.escape(ip)}"]`);
+    if (row) {
+        row.remove();
+    }
+    if (!document.querySelector('#server-table tr[data-ip]')) {
+        document.querySelector('#default-row').style.display = 'table-row';
+    }
+}
+
+let stream = null;
+
+// Subscribe to server changes. The stream starts with the state of every server,
+// and the browser reconnects on its own if it drops.
+function subscribeServers() {
+    if (stream) {
+        stream.close();
+    }
+    setRefreshingState();
+
+    stream = new EventSource('/api/stream');
+    stream.onopen = setReadyState;
+    stream.addEventListener('server', event => {
+        document.querySelector('#default-row').style.display = 'none';
+        updateTableRow(JSON.parse(event.data));
+    });
+    stream.addEventListener('server-removed', event => {
+        removeTableRow(JSON.parse(event.data).public_ip);
+    });
+}
+
+let refresh = subscribeServers;
+if (window.EventSource) {
+    subscribeServers();
+} else {
+    // Start polling
+    refresh = pollServers;
+    setInterval(pollServers, POLL_INTERVAL);
+    pollServers();
+}
 
 // Add event listener for manual refresh
 document.addEventListener('DOMContentLoaded', function() {
     const refreshButton = document.getElementById('refresh');
-    refreshButton.addEventListener('click', pollServers);
+    refreshButton.addEventListener('click', () => refresh());
 });
-
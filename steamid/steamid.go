@@ -0,0 +1,101 @@
+// Package steamid converts between the forms a Steam account's id is written in:
+// SteamID64 (76561197960287930), Steam3 ([U:1:22202]) and the legacy STEAM_0:0:11101.
+package steamid
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrBot     = errors.New("steamid: bot")
+	ErrInvalid = errors.New("steamid: invalid id")
+)
+
+// ID is an individual account in the public universe, stored as its SteamID64
+type ID uint64
+
+// base is the SteamID64 of account 0: universe 1 (public), type 1 (individual), instance 1 (desktop)
+const base = 76561197960265728
+
+var (
+	steam3Re = regexp.MustCompile(`^\[?U:1:(\d+)\]?$`)
+	legacyRe = regexp.MustCompile(`^STEAM_[01]:([01]):(\d+)$`)
+)
+
+// FromAccount returns the ID of an account number, the n in [U:1:n]
+func FromAccount(account uint32) ID {
+	return ID(base + uint64(account))
+}
+
+// Parse reads any of the three forms, with or without the brackets around Steam3 ids.
+// Bots, which the status command lists as BOT, return ErrBot.
+func Parse(s string) (ID, error) {
+	s = strings.TrimSpace(s)
+	if s == "BOT" {
+		return 0, ErrBot
+	}
+
+	if m := steam3Re.FindStringSubmatch(s); m != nil {
+		account, err := strconv.ParseUint(m[1], 10, 32)
+		if err != nil || account == 0 {
+			return 0, fmt.Errorf("%w: %q", ErrInvalid, s)
+		}
+		return FromAccount(uint32(account)), nil
+	}
+
+	if m := legacyRe.FindStringSubmatch(s); m != nil {
+		high, err := strconv.ParseUint(m[2], 10, 31)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %q", ErrInvalid, s)
+		}
+		account := high<<1 | uint64(m[1][0]-'0')
+		if account == 0 {
+			return 0, fmt.Errorf("%w: %q", ErrInvalid, s)
+		}
+		return FromAccount(uint32(account)), nil
+	}
+
+	if id, err := strconv.ParseUint(s, 10, 64); err == nil {
+		if id <= base || id-base > 0xFFFFFFFF {
+			return 0, fmt.Errorf("%w: %q", ErrInvalid, s)
+		}
+		return ID(id), nil
+	}
+
+	return 0, fmt.Errorf("%w: %q", ErrInvalid, s)
+}
+
+// Account returns the account number, the n in [U:1:n]
+func (id ID) Account() uint32 {
+	return uint32(uint64(id) - base)
+}
+
+// SteamID64 returns the id as used in profile URLs and the Steam Web API
+func (id ID) SteamID64() uint64 {
+	return uint64(id)
+}
+
+// Steam3 returns U:1:n, the form player_sessions stores, without brackets
+func (id ID) Steam3() string {
+	return fmt.Sprintf("U:1:%d", id.Account())
+}
+
+// Legacy returns the STEAM_0:x:y form
+func (id ID) Legacy() string {
+	account := id.Account()
+	return fmt.Sprintf("STEAM_0:%d:%d", account&1, account>>1)
+}
+
+// ProfileURL returns the account's Steam community profile
+func (id ID) ProfileURL() string {
+	return fmt.Sprintf("https://steamcommunity.com/profiles/%d", uint64(id))
+}
+
+// String returns [U:1:n], as the status command prints it
+func (id ID) String() string {
+	return "[" + id.Steam3() + "]"
+}
@@ -0,0 +1,39 @@
+package steamid
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, s := range []string{"[U:1:22202]", "U:1:22202", "76561197960287930", "STEAM_0:0:11101", "STEAM_1:0:11101"} {
+		id, err := Parse(s)
+		if err != nil || id != 76561197960287930 {
+			t.Errorf("Expected %s to parse as 76561197960287930, got %d %v", s, id, err)
+		}
+	}
+
+	// odd account numbers keep their low bit in the legacy form's middle digit
+	if id, err := Parse("STEAM_0:1:11101"); err != nil || id.Account() != 22203 {
+		t.Errorf("Expected account 22203, got %d %v", id.Account(), err)
+	}
+
+	if _, err := Parse("BOT"); !errors.Is(err, ErrBot) {
+		t.Errorf("Expected BOT to be a bot, got %v", err)
+	}
+	for _, s := range []string{"", "Console", "STEAM_ID_PENDING", "[U:1:0]", "[A:1:22202]", "U:1:99999999999", "STEAM_0:2:11101", "123", "76561197960265728"} {
+		if _, err := Parse(s); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Expected %q to be invalid, got %v", s, err)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	id := FromAccount(22203)
+	if id.SteamID64() != 76561197960287931 || id.Steam3() != "U:1:22203" || id.String() != "[U:1:22203]" || id.Legacy() != "STEAM_0:1:11101" {
+		t.Errorf("Unexpected formats %d %s %s %s", id.SteamID64(), id.Steam3(), id, id.Legacy())
+	}
+	if url := id.ProfileURL(); url != "https://steamcommunity.com/profiles/76561197960287931" {
+		t.Errorf("Unexpected profile URL %s", url)
+	}
+}
@@ -0,0 +1,302 @@
+// Package updater moves the game servers onto a new TF2 patch. Each server is restarted
+// as soon as it is empty, or after warning the players still on it in chat.
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Update states of a server
+const (
+	StateWaiting    = "waiting"    // waiting for the server to empty
+	StateWarning    = "warning"    // counting down in chat before restarting with players on
+	StateRestarting = "restarting" // update triggered, waiting for the server to answer again
+	StateDone       = "done"
+	StateFailed     = "failed"
+)
+
+// Status is where a server is in its latest update
+type Status struct {
+	PublicIP  string `json:"public_ip"`
+	State     string `json:"state"`
+	Reason    string `json:"reason"`               // what started the update, e.g. the patch notes title
+	StartedAt int64  `json:"started_at"`           // unix seconds
+	ChangedAt int64  `json:"changed_at"`           // unix seconds of the last state change
+	RestartAt int64  `json:"restart_at,omitempty"` // unix seconds a warned server will be restarted at
+	Error     string `json:"error,omitempty"`
+}
+
+// Active reports whether the update is still in progress
+func (s Status) Active() bool {
+	return s.State != StateDone && s.State != StateFailed
+}
+
+// Poll is the latest poll of a server
+type Poll struct {
+	Players   int
+	Reachable bool
+	At        time.Time // when the poll was sent, zero if the server hasn't been polled
+}
+
+// Fleet is how the orchestrator sees and controls the game servers
+type Fleet interface {
+	// Servers returns the IPs of the servers to update
+	Servers() ([]string, error)
+	LastPoll(ip string) Poll
+	Say(ip, message string) error
+	// Quit makes the server exit, for its supervisor to update and restart it
+	Quit(ip string) error
+}
+
+// Orchestrator runs updates across the fleet, one goroutine per server
+type Orchestrator struct {
+	WaitForEmpty   time.Duration // how long to wait for a server to empty before warning its players, 0 waits indefinitely
+	Countdown      time.Duration // how long players are warned for before the restart
+	Hook           string        // shell command that updates and restarts the server in $SERVER_IP, instead of RCON quit
+	CheckInterval  time.Duration // how often player counts are checked
+	RestartTimeout time.Duration // how long a server has to answer again once the update is triggered
+
+	fleet    Fleet
+	mu       sync.Mutex
+	statuses map[string]*Status
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func New(fleet Fleet) *Orchestrator {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Orchestrator{
+		WaitForEmpty:   30 * time.Minute,
+		Countdown:      5 * time.Minute,
+		CheckInterval:  30 * time.Second,
+		RestartTimeout: 15 * time.Minute,
+		fleet:          fleet,
+		statuses:       map[string]*Status{},
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+}
+
+// Start begins updating every server that isn't already being updated and returns their IPs.
+// It doesn't wait for the updates to finish.
+func (o *Orchestrator) Start(reason string) ([]string, error) {
+	ips, err := o.fleet.Servers()
+	if err != nil {
+		return nil, err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var started []string
+	now := time.Now().Unix()
+	for _, ip := range ips {
+		if status, ok := o.statuses[ip]; ok && status.Active() {
+			continue
+		}
+		o.statuses[ip] = &Status{PublicIP: ip, State: StateWaiting, Reason: reason, StartedAt: now, ChangedAt: now}
+		started = append(started, ip)
+
+		o.wg.Add(1)
+		go func(ip string) {
+			defer o.wg.Done()
+			o.update(ip)
+		}(ip)
+	}
+	return started, nil
+}
+
+// Statuses returns the latest update of every server that has had one, sorted by IP
+func (o *Orchestrator) Statuses() []Status {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	statuses := make([]Status, 0, len(o.statuses))
+	for _, status := range o.statuses {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].PublicIP < statuses[j].PublicIP })
+	return statuses
+}
+
+// Status returns the latest update of one server, ok is false if it hasn't had one
+func (o *Orchestrator) Status(ip string) (status Status, ok bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if s, found := o.statuses[ip]; found {
+		return *s, true
+	}
+	return Status{}, false
+}
+
+// Wait blocks until every update started so far has finished
+func (o *Orchestrator) Wait() {
+	o.wg.Wait()
+}
+
+// Close stops the updates in progress and waits for them to return. Servers already
+// triggered are left to finish restarting on their own.
+func (o *Orchestrator) Close() {
+	o.cancel()
+	o.wg.Wait()
+}
+
+func (o *Orchestrator) update(ip string) {
+	if err := o.waitForRestart(ip); err != nil {
+		o.fail(ip, err)
+		return
+	}
+
+	o.setState(ip, StateRestarting)
+	if err := o.trigger(ip); err != nil {
+		o.fail(ip, fmt.Errorf("triggering update: %w", err))
+		return
+	}
+
+	if err := o.waitForServer(ip, time.Now()); err != nil {
+		o.fail(ip, err)
+		return
+	}
+	o.setState(ip, StateDone)
+}
+
+// waitForRestart returns once the server is empty, or once its players have been warned
+// for the whole countdown
+func (o *Orchestrator) waitForRestart(ip string) error {
+	started := time.Now()
+	var restartAt time.Time
+	var warnings []time.Duration
+	for {
+		if poll := o.fleet.LastPoll(ip); poll.Reachable && poll.Players == 0 {
+			return nil
+		}
+
+		now := time.Now()
+		if restartAt.IsZero() && o.WaitForEmpty > 0 && now.Sub(started) >= o.WaitForEmpty {
+			restartAt = now.Add(o.Countdown)
+			warnings = warningTimes(o.Countdown)
+			o.mu.Lock()
+			o.statuses[ip].RestartAt = restartAt.Unix()
+			o.mu.Unlock()
+			o.setState(ip, StateWarning)
+		}
+
+		wait := o.CheckInterval
+		if !restartAt.IsZero() {
+			remaining := restartAt.Sub(now)
+			if remaining <= 0 {
+				return nil
+			}
+			if len(warnings) > 0 && remaining <= warnings[0] {
+				message := "Server restarting for a TF2 update in " + formatRemaining(warnings[0])
+				if err := o.fleet.Say(ip, message); err != nil {
+					log.Printf("Error warning players on %s of the update: %v", ip, err)
+				}
+				for len(warnings) > 0 && remaining <= warnings[0] {
+					warnings = warnings[1:]
+				}
+			}
+
+			wait = min(wait, remaining)
+			if len(warnings) > 0 {
+				wait = min(wait, remaining-warnings[0])
+			}
+		}
+
+		select {
+		case <-o.ctx.Done():
+			return errors.New("stopped before the server was restarted")
+		case <-time.After(wait):
+		}
+	}
+}
+
+// waitForServer waits for the first successful poll sent after the update was triggered.
+// A poll already in flight when the server quit can still succeed, so finishing later isn't enough.
+func (o *Orchestrator) waitForServer(ip string, triggered time.Time) error {
+	deadline := time.After(o.RestartTimeout)
+	ticker := time.NewTicker(o.CheckInterval)
+	defer ticker.Stop()
+	for {
+		if poll := o.fleet.LastPoll(ip); poll.Reachable && poll.At.After(triggered) {
+			return nil
+		}
+
+		select {
+		case <-o.ctx.Done():
+			return errors.New("stopped while the server was restarting")
+		case <-deadline:
+			return fmt.Errorf("server did not answer within %s of the update", o.RestartTimeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (o *Orchestrator) trigger(ip string) error {
+	if o.Hook == "" {
+		return o.fleet.Quit(ip)
+	}
+
+	cmd := exec.CommandContext(o.ctx, "sh", "-c", o.Hook)
+	cmd.Env = append(os.Environ(), "SERVER_IP="+ip)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (o *Orchestrator) setState(ip, state string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	status := o.statuses[ip]
+	status.State = state
+	status.ChangedAt = time.Now().Unix()
+	log.Printf("Update of %s: %s", ip, state)
+}
+
+func (o *Orchestrator) fail(ip string, err error) {
+	o.mu.Lock()
+	o.statuses[ip].Error = err.Error()
+	o.mu.Unlock()
+	o.setState(ip, StateFailed)
+	log.Printf("Update of %s failed: %v", ip, err)
+}
+
+// warningTimes returns how long before the restart players are warned, longest first.
+// They are warned when the countdown starts and at a few round times after.
+func warningTimes(countdown time.Duration) []time.Duration {
+	times := []time.Duration{countdown}
+	for _, t := range []time.Duration{10 * time.Minute, 5 * time.Minute, time.Minute, 30 * time.Second, 10 * time.Second} {
+		if t < countdown {
+			times = append(times, t)
+		}
+	}
+	return times
+}
+
+func formatRemaining(d time.Duration) string {
+	switch {
+	case d >= time.Minute && d%time.Minute == 0:
+		if d == time.Minute {
+			return "1 minute"
+		}
+		return fmt.Sprintf("%d minutes", d/time.Minute)
+	case d.Round(time.Second) == time.Second:
+		return "1 second"
+	default:
+		return fmt.Sprintf("%d seconds", d/time.Second)
+	}
+}
@@ -0,0 +1,216 @@
+package updater
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeFleet reports a fixed player count per server until it quits, and
+// answers again right after unless it is set to stay down. With inFlight set,
+// the poll sent just before the quit is reported until it is cleared.
+type fakeFleet struct {
+	mu        sync.Mutex
+	players   map[string]int
+	quit      map[string]time.Time
+	messages  map[string][]string
+	staysDown bool
+	inFlight  bool
+}
+
+func newFakeFleet(players map[string]int) *fakeFleet {
+	return &fakeFleet{players: players, quit: map[string]time.Time{}, messages: map[string][]string{}}
+}
+
+func (f *fakeFleet) Servers() ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ips []string
+	for ip := range f.players {
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+func (f *fakeFleet) LastPoll(ip string) Poll {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if quit, ok := f.quit[ip]; ok && f.inFlight {
+		return Poll{Players: f.players[ip], Reachable: true, At: quit.Add(-time.Millisecond)}
+	}
+	if quit, ok := f.quit[ip]; ok {
+		return Poll{Reachable: !f.staysDown, At: quit.Add(time.Second)}
+	}
+	return Poll{Players: f.players[ip], Reachable: true, At: time.Now()}
+}
+
+func (f *fakeFleet) Say(ip, message string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages[ip] = append(f.messages[ip], message)
+	return nil
+}
+
+func (f *fakeFleet) Quit(ip string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.quit[ip] = time.Now()
+	return nil
+}
+
+func newTestOrchestrator(fleet Fleet) *Orchestrator {
+	o := New(fleet)
+	o.CheckInterval = 5 * time.Millisecond
+	o.WaitForEmpty = 20 * time.Millisecond
+	o.Countdown = 20 * time.Millisecond
+	o.RestartTimeout = time.Second
+	return o
+}
+
+// An empty server is restarted right away, a busy one after its players were warned
+func TestUpdate(t *testing.T) {
+	fleet := newFakeFleet(map[string]int{"10.0.0.1": 0, "10.0.0.2": 5})
+	o := newTestOrchestrator(fleet)
+	t.Cleanup(o.Close)
+
+	started, err := o.Start("Team Fortress 2 Update Released")
+	if err != nil || len(started) != 2 {
+		t.Fatalf("Expected both servers to start updating, got %v %v", started, err)
+	}
+	o.Wait()
+
+	for _, status := range o.Statuses() {
+		if status.State != StateDone || status.Reason != "Team Fortress 2 Update Released" || status.Error != "" {
+			t.Errorf("Expected %s to be updated, got %+v", status.PublicIP, status)
+		}
+	}
+	if status, _ := o.Status("10.0.0.2"); status.RestartAt == 0 {
+		t.Errorf("Expected a restart time for the busy server, got %+v", status)
+	}
+
+	if len(fleet.messages["10.0.0.1"]) != 0 {
+		t.Errorf("Expected no warnings on the empty server, got %v", fleet.messages["10.0.0.1"])
+	}
+	if messages := fleet.messages["10.0.0.2"]; len(messages) != 1 || !strings.HasPrefix(messages[0], "Server restarting for a TF2 update in") {
+		t.Errorf("Expected the busy server to be warned, got %v", messages)
+	}
+	if len(fleet.quit) != 2 {
+		t.Errorf("Expected both servers to quit, got %v", fleet.quit)
+	}
+}
+
+// Servers still being updated aren't started again
+func TestStartSkipsActive(t *testing.T) {
+	fleet := newFakeFleet(map[string]int{"10.0.0.1": 3})
+	o := newTestOrchestrator(fleet)
+	o.WaitForEmpty = 0 // wait for the server to empty
+	t.Cleanup(o.Close)
+
+	o.Start("first")
+	started, _ := o.Start("second")
+	if len(started) != 0 {
+		t.Errorf("Expected nothing to start, got %v", started)
+	}
+	if status, ok := o.Status("10.0.0.1"); !ok || status.State != StateWaiting || status.Reason != "first" {
+		t.Errorf("Expected the first update to be waiting, got %+v", status)
+	}
+
+	fleet.mu.Lock()
+	fleet.players["10.0.0.1"] = 0
+	fleet.mu.Unlock()
+	o.Wait()
+	if status, _ := o.Status("10.0.0.1"); status.State != StateDone {
+		t.Errorf("Expected the update to finish once the server emptied, got %+v", status)
+	}
+
+	if started, _ := o.Start("third"); len(started) != 1 {
+		t.Errorf("Expected a finished server to update again, got %v", started)
+	}
+}
+
+// A server that doesn't come back fails the update
+func TestRestartTimeout(t *testing.T) {
+	fleet := newFakeFleet(map[string]int{"10.0.0.1": 0})
+	fleet.staysDown = true
+	o := newTestOrchestrator(fleet)
+	o.RestartTimeout = 20 * time.Millisecond
+	t.Cleanup(o.Close)
+
+	o.Start("patch")
+	o.Wait()
+	if status, _ := o.Status("10.0.0.1"); status.State != StateFailed || !strings.Contains(status.Error, "did not answer") {
+		t.Errorf("Expected the update to fail, got %+v", status)
+	}
+}
+
+// A poll sent before the quit that answers after it doesn't count as the server being back
+func TestRestartIgnoresPollInFlight(t *testing.T) {
+	fleet := newFakeFleet(map[string]int{"10.0.0.1": 0})
+	fleet.inFlight = true
+	o := newTestOrchestrator(fleet)
+	t.Cleanup(o.Close)
+
+	o.Start("patch")
+	time.Sleep(50 * time.Millisecond)
+	if status, _ := o.Status("10.0.0.1"); status.State != StateRestarting {
+		t.Fatalf("Expected the server to still be restarting, got %+v", status)
+	}
+
+	fleet.mu.Lock()
+	fleet.inFlight = false
+	fleet.mu.Unlock()
+	o.Wait()
+	if status, _ := o.Status("10.0.0.1"); status.State != StateDone {
+		t.Errorf("Expected the update to finish once a later poll answered, got %+v", status)
+	}
+}
+
+// The hook runs with the server's IP instead of RCON quit, and its output explains a failure
+func TestHook(t *testing.T) {
+	fleet := newFakeFleet(map[string]int{"10.0.0.1": 0})
+	o := newTestOrchestrator(fleet)
+	out := filepath.Join(t.TempDir(), "hook")
+	o.Hook = `echo "$SERVER_IP" > ` + out
+	t.Cleanup(o.Close)
+
+	o.Start("patch")
+	o.Wait()
+	if written, _ := os.ReadFile(out); string(written) != "10.0.0.1\n" {
+		t.Errorf("Expected the hook to be run for 10.0.0.1, got %q", written)
+	}
+	if len(fleet.quit) != 0 {
+		t.Errorf("Expected no RCON quit with a hook, got %v", fleet.quit)
+	}
+	if status, _ := o.Status("10.0.0.1"); status.State != StateDone {
+		t.Errorf("Expected the update to finish, got %+v", status)
+	}
+
+	o.Hook = "echo no space left on device; exit 1"
+	o.RestartTimeout = time.Millisecond
+	o.Start("patch")
+	o.Wait()
+	if status, _ := o.Status("10.0.0.1"); status.State != StateFailed || !strings.Contains(status.Error, "no space left on device") {
+		t.Errorf("Expected the hook's output in the error, got %+v", status)
+	}
+}
+
+func TestWarningTimes(t *testing.T) {
+	if times := warningTimes(5 * time.Minute); !reflect.DeepEqual(times, []time.Duration{5 * time.Minute, time.Minute, 30 * time.Second, 10 * time.Second}) {
+		t.Errorf("Unexpected warning times %v", times)
+	}
+
+	for d, expected := range map[time.Duration]string{
+		5 * time.Minute:  "5 minutes",
+		time.Minute:      "1 minute",
+		90 * time.Second: "90 seconds",
+		time.Second:      "1 second",
+	} {
+		if formatted := formatRemaining(d); formatted != expected {
+			t.Errorf("Expected %s to format as %q, got %q", d, expected, formatted)
+		}
+	}
+}